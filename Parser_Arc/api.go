@@ -0,0 +1,482 @@
+//go:build api
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB  DBConfig  `mapstructure:"db"`
+	API APIConfig `mapstructure:"api"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+// APIConfig - HTTP API хранилища сигнатур. Без cert и key API работает по HTTP.
+type APIConfig struct {
+	Listen string     `mapstructure:"listen"`
+	Cert   string     `mapstructure:"cert"`
+	Key    string     `mapstructure:"key"`
+	Tokens []APIToken `mapstructure:"tokens"`
+}
+
+// APIToken - токен доступа: read - поиск и просмотр, admin - также включение,
+// отключение и метки. Имя токена записывается в историю сигнатуры.
+type APIToken struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+	Role  string `mapstructure:"role"`
+}
+
+const (
+	roleRead  = "read"
+	roleAdmin = "admin"
+)
+
+var config Config
+
+// Описание API в формате OpenAPI 3, отдаётся по /openapi.yaml.
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+func main() {
+	initLog()
+
+	log.Println("=== Старт API сигнатур ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	if err := checkTokens(config.API.Tokens); err != nil {
+		log.Fatalf("Ошибка конфигурации API: %v", err)
+	}
+	listen := config.API.Listen
+	if listen == "" {
+		listen = "127.0.0.1:8080"
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           &apiServer{db: db, tokens: config.API.Tokens},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("API сигнатур запущено на %s, токенов: %d", listen, len(config.API.Tokens))
+	fmt.Printf("API сигнатур: %s (описание: /openapi.yaml)\n", listen)
+	if config.API.Cert != "" || config.API.Key != "" {
+		err = server.ListenAndServeTLS(config.API.Cert, config.API.Key)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Ошибка API: %v", err)
+	}
+	log.Println("=== Завершение работы API сигнатур ===")
+}
+
+// checkTokens проверяет, что у токенов есть имя, значение и известная роль.
+func checkTokens(tokens []APIToken) error {
+	if len(tokens) == 0 {
+		return fmt.Errorf("В api.tokens не задано ни одного токена")
+	}
+	seen := map[string]bool{}
+	for _, t := range tokens {
+		if t.Name == "" || t.Token == "" {
+			return fmt.Errorf("У токена в api.tokens должны быть name и token")
+		}
+		if t.Role != roleRead && t.Role != roleAdmin {
+			return fmt.Errorf("Неизвестная роль токена %s: %q (допустимы read и admin)", t.Name, t.Role)
+		}
+		if seen[t.Token] {
+			return fmt.Errorf("Токен %s совпадает с другим токеном", t.Name)
+		}
+		seen[t.Token] = true
+	}
+	return nil
+}
+
+// apiServer обрабатывает запросы API:
+//
+//	GET    /openapi.yaml                     описание API (без токена)
+//	GET    /signatures                       поиск с постраничным выводом
+//	GET    /signatures/{sid}                 сигнатура с историей
+//	POST   /signatures/{sid}/enable          включить правило (admin)
+//	POST   /signatures/{sid}/disable         отключить правило (admin)
+//	GET    /signatures/{sid}/tags            метки аналитиков
+//	PUT    /signatures/{sid}/tags            заменить метки (admin)
+//	POST   /signatures/{sid}/tags            добавить метки (admin)
+//	DELETE /signatures/{sid}/tags/{tag}      удалить метку (admin)
+//	GET    /coverage?cve=...                 покрытие CVE правилами по источникам
+type apiServer struct {
+	db     *sql.DB
+	tokens []APIToken
+}
+
+// apiError - ошибка с кодом ответа HTTP.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(sid string) error {
+	return &apiError{http.StatusNotFound, "Сигнатура не найдена: " + sid}
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// HEAD обрабатывается как GET: сервер отбрасывает тело ответа.
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if r.URL.Path == "/openapi.yaml" && read {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+		return
+	}
+
+	token := s.authenticate(r)
+	if token == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="signatures"`)
+		writeError(w, &apiError{http.StatusUnauthorized, "Требуется токен доступа"})
+		return
+	}
+	if !read && token.Role != roleAdmin {
+		writeError(w, &apiError{http.StatusForbidden, "Изменение сигнатур доступно только токенам с ролью admin"})
+		return
+	}
+
+	var result interface{}
+	var err error
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	note := ChangeNote{Actor: token.Name}
+	switch {
+	case len(parts) == 1 && parts[0] == "signatures" && read:
+		result, err = s.search(r.URL.Query())
+	case len(parts) == 1 && parts[0] == "coverage" && read:
+		result, err = s.coverage(r.URL.Query())
+	case len(parts) < 2 || parts[0] != "signatures":
+		err = &apiError{http.StatusNotFound, "Неизвестный путь: " + r.URL.Path}
+	case len(parts) == 2 && read:
+		result, err = s.detail(parts[1])
+	case len(parts) == 3 && (parts[2] == "enable" || parts[2] == "disable") && r.Method == http.MethodPost:
+		result, err = s.toggle(r, parts[1], parts[2] == "enable", note)
+	case len(parts) == 3 && parts[2] == "tags":
+		result, err = s.tags(r, parts[1], note)
+	case len(parts) == 4 && parts[2] == "tags" && r.Method == http.MethodDelete:
+		tag := parts[3]
+		result, err = s.changeTags(parts[1], func(tags []string) []string {
+			var kept []string
+			for _, t := range tags {
+				if t != tag {
+					kept = append(kept, t)
+				}
+			}
+			return kept
+		}, note)
+	default:
+		err = &apiError{http.StatusMethodNotAllowed, "Метод не поддерживается: " + r.Method + " " + r.URL.Path}
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !read {
+		log.Printf("API: %s %s %s", token.Name, r.Method, r.URL.Path)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// authenticate находит токен из заголовка Authorization: Bearer.
+func (s *apiServer) authenticate(r *http.Request) *APIToken {
+	header := r.Header.Get("Authorization")
+	value := strings.TrimPrefix(header, "Bearer ")
+	if value == header || value == "" {
+		return nil
+	}
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(s.tokens[i].Token)) == 1 {
+			return &s.tokens[i]
+		}
+	}
+	return nil
+}
+
+// search выполняет поиск по параметрам запроса.
+func (s *apiServer) search(q url.Values) (interface{}, error) {
+	list := func(name string) []string {
+		return splitList(strings.Join(q[name], ","))
+	}
+	search := SignatureSearch{
+		Filter: ExportFilter{
+			Sources:    list("source"),
+			IDs:        list("sid"),
+			Classtypes: list("classtype"),
+			Severities: list("severity"),
+			Protocols:  list("proto"),
+			Tags:       list("tag"),
+			Metadata:   list("metadata"),
+		},
+		Text:    q.Get("q"),
+		CVE:     q.Get("cve"),
+		IP:      q.Get("ip"),
+		IPSide:  q.Get("ip_side"),
+		Content: q.Get("content"),
+		Data:    q.Get("data"),
+		Buffer:  q.Get("buffer"),
+	}
+	if value := q.Get("enabled"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, badRequest("Некорректное значение enabled: %s", value)
+		}
+		search.Enabled = &enabled
+	}
+	for name, field := range map[string]*int{"limit": &search.Limit, "offset": &search.Offset} {
+		if value := q.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, badRequest("Некорректное значение %s: %s", name, value)
+			}
+			*field = n
+		}
+	}
+	if err := search.validate(); err != nil {
+		return nil, badRequest("%v", err)
+	}
+
+	items, total, err := searchSignatures(s.db, search)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"total": total, "limit": search.Limit, "offset": search.Offset, "items": items}, nil
+}
+
+// coverage сообщает, какие CVE покрыты включёнными, только отключёнными или никакими правилами.
+func (s *apiServer) coverage(q url.Values) (interface{}, error) {
+	cves, err := normalizeCVEList(splitList(strings.Join(q["cve"], ",")))
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	coverage, err := cveCoverage(s.db, cves, splitList(strings.Join(q["source"], ",")))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"items": coverage}, nil
+}
+
+// detail возвращает сигнатуру вместе с историей изменений.
+func (s *apiServer) detail(sid string) (interface{}, error) {
+	record, err := loadSignatureRecord(s.db, sid)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, notFound(sid)
+	}
+	history, err := signatureHistory(s.db, sid)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		*SignatureRecord
+		History []HistoryEntry `json:"history"`
+	}{record, history}, nil
+}
+
+// toggle включает или отключает правило; комментарий обязателен и попадает в историю.
+func (s *apiServer) toggle(r *http.Request, sid string, enable bool, note ChangeNote) (interface{}, error) {
+	var body struct {
+		Comment string `json:"comment"`
+		Deps    bool   `json:"deps"`
+	}
+	if err := readBody(r, &body); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(body.Comment) == "" {
+		return nil, badRequest("Не указан комментарий (comment)")
+	}
+	if err := s.exists(sid); err != nil {
+		return nil, err
+	}
+	note.Comment = strings.TrimSpace(body.Comment)
+
+	if enable {
+		deps, err := enableRules(s.db, []string{sid}, body.Deps, note)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"sid": sid, "enabled": true, "flowbit_setters": nonNil(deps), "setters_enabled": body.Deps}, nil
+	}
+	broken, err := disableRules(s.db, []string{sid}, note)
+	if err != nil {
+		return nil, err
+	}
+	issues := []map[string]string{}
+	for _, issue := range broken {
+		issues = append(issues, map[string]string{"sid": issue.SID, "flowbit": issue.Name})
+	}
+	return map[string]interface{}{"sid": sid, "enabled": false, "broken_flowbits": issues}, nil
+}
+
+// tags показывает, заменяет или дополняет метки аналитиков.
+func (s *apiServer) tags(r *http.Request, sid string, note ChangeNote) (interface{}, error) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		record, err := loadSignatureRecord(s.db, sid)
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, notFound(sid)
+		}
+		return map[string]interface{}{"sid": sid, "tags": record.Tags}, nil
+	}
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		return nil, &apiError{http.StatusMethodNotAllowed, "Метод не поддерживается: " + r.Method + " " + r.URL.Path}
+	}
+
+	var body struct {
+		Tags    []string `json:"tags"`
+		Comment string   `json:"comment"`
+	}
+	if err := readBody(r, &body); err != nil {
+		return nil, err
+	}
+	if err := checkTags(body.Tags); err != nil {
+		return nil, badRequest("%v", err)
+	}
+	note.Comment = strings.TrimSpace(body.Comment)
+	replace := r.Method == http.MethodPut
+	return s.changeTags(sid, func(tags []string) []string {
+		if replace {
+			return body.Tags
+		}
+		return append(tags, body.Tags...)
+	}, note)
+}
+
+func (s *apiServer) changeTags(sid string, change func([]string) []string, note ChangeNote) (interface{}, error) {
+	if err := s.exists(sid); err != nil {
+		return nil, err
+	}
+	tags, err := changeTags(s.db, sid, change, note)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"sid": sid, "tags": tags}, nil
+}
+
+// exists проверяет, что сигнатура есть и не удалена.
+func (s *apiServer) exists(sid string) error {
+	record, err := loadSignatureRecord(s.db, sid)
+	if err != nil {
+		return err
+	}
+	if record == nil || record.DeletedAt != nil {
+		return notFound(sid)
+	}
+	return nil
+}
+
+// readBody разбирает JSON-тело запроса; пустое тело допустимо.
+func readBody(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return badRequest("Некорректное тело запроса: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// writeError отвечает {"error": "..."}; ошибки БД записываются в лог и не раскрываются клиенту.
+func writeError(w http.ResponseWriter, err error) {
+	status, message := http.StatusInternalServerError, "Внутренняя ошибка"
+	if e, ok := err.(*apiError); ok {
+		status, message = e.status, e.message
+	} else {
+		log.Printf("API: %v", err)
+	}
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало работы API сигнатур ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
@@ -0,0 +1,288 @@
+//go:build api
+
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// apiStore - таблицы signatures и signature_history тестовой БД API.
+type apiStore struct {
+	t       *testing.T
+	rows    map[string][]driver.Value // столбцы signatureColumns по sid
+	tags    map[string][]string
+	deleted map[string]bool
+	history []HistoryEntry
+	limits  [][2]int64 // LIMIT и OFFSET запросов поиска
+}
+
+func newAPIStore(t *testing.T, count int) *apiStore {
+	s := &apiStore{t: t, rows: map[string][]driver.Value{}, tags: map[string][]string{}, deleted: map[string]bool{}}
+	for i := 1; i <= count; i++ {
+		sid := fmt.Sprint(2000000 + i)
+		s.rows[sid] = signatureRow(t, fmt.Sprintf(`alert tcp any any -> any any (msg:"rule %d"; content:"a"; sid:%s; rev:1;)`, i, sid), "et")
+	}
+	return s
+}
+
+func (s *apiStore) sids() []string {
+	var sids []string
+	for sid := range s.rows {
+		if !s.deleted[sid] {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Strings(sids)
+	return sids
+}
+
+// record - строка сигнатуры с метками и временем, как в scanSignatureRecord.
+func (s *apiStore) record(sid string, extra ...driver.Value) []driver.Value {
+	tags, _ := pq.StringArray(s.tags[sid]).Value()
+	var deleted driver.Value
+	if s.deleted[sid] {
+		deleted = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	}
+	row := append(append([]driver.Value{}, s.rows[sid]...), tags, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil, deleted)
+	return append(row, extra...)
+}
+
+func (s *apiStore) query(query string, args []driver.Value) ([][]driver.Value, error) {
+	switch {
+	case strings.Contains(query, "COUNT(*) OVER ()"):
+		limit, offset := args[len(args)-2].(int64), args[len(args)-1].(int64)
+		s.limits = append(s.limits, [2]int64{limit, offset})
+		sids := s.sids()
+		var rows [][]driver.Value
+		for i := offset; i < int64(len(sids)) && i < offset+limit; i++ {
+			rows = append(rows, s.record(sids[i], int64(len(sids))))
+		}
+		return rows, nil
+	case strings.Contains(query, "FOR UPDATE"):
+		sid := args[0].(string)
+		if s.rows[sid] == nil || s.deleted[sid] {
+			return nil, nil
+		}
+		tags, _ := pq.StringArray(s.tags[sid]).Value()
+		return [][]driver.Value{{tags}}, nil
+	case strings.Contains(query, "FROM signatures") && strings.Contains(query, "WHERE sid = $1"):
+		sid := args[0].(string)
+		if s.rows[sid] == nil {
+			return nil, nil
+		}
+		return [][]driver.Value{s.record(sid)}, nil
+	case strings.Contains(query, "FROM signature_history"):
+		var rows [][]driver.Value
+		for i := len(s.history) - 1; i >= 0; i-- {
+			h := s.history[i]
+			rows = append(rows, []driver.Value{h.Event, h.Actor, h.Comment, h.Details, h.At})
+		}
+		return rows, nil
+	case strings.Contains(query, "UPDATE signatures SET tags"):
+		var tags pq.StringArray
+		if err := tags.Scan(args[1]); err != nil {
+			return nil, err
+		}
+		s.tags[args[0].(string)] = tags
+		return [][]driver.Value{{}}, nil
+	case strings.Contains(query, "INSERT INTO signature_history"):
+		text := func(v driver.Value) string {
+			value, _ := v.(string)
+			return value
+		}
+		s.history = append(s.history, HistoryEntry{Event: "tags", Actor: text(args[1]), Comment: text(args[2]), Details: text(args[3]), At: time.Now()})
+		return [][]driver.Value{{}}, nil
+	}
+	s.t.Errorf("неожиданный запрос: %s", query)
+	return nil, fmt.Errorf("неожиданный запрос")
+}
+
+// apiTokens - токены тестового сервера: analyst (read) и soc (admin).
+var apiTokens = []APIToken{{Name: "analyst", Token: "read-token", Role: roleRead}, {Name: "soc", Token: "admin-token", Role: roleAdmin}}
+
+// apiRequest выполняет запрос к серверу и возвращает ответ.
+func apiRequest(t *testing.T, server http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIAccess(t *testing.T) {
+	store := newAPIStore(t, 3)
+	store.deleted["2000003"] = true
+	server := &apiServer{db: openFakeDB(t, store.query), tokens: apiTokens}
+
+	tests := []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"GET", "/openapi.yaml", "", "", http.StatusOK},
+		{"HEAD", "/openapi.yaml", "", "", http.StatusOK},
+		{"GET", "/signatures", "", "", http.StatusUnauthorized},
+		{"GET", "/signatures", "wrong-token", "", http.StatusUnauthorized},
+		{"GET", "/signatures", "read-token", "", http.StatusOK},
+		{"HEAD", "/signatures", "read-token", "", http.StatusOK},
+		{"GET", "/signatures/2000001", "read-token", "", http.StatusOK},
+		{"HEAD", "/signatures/2000001", "read-token", "", http.StatusOK},
+		{"GET", "/signatures/2000001/tags", "read-token", "", http.StatusOK},
+		{"HEAD", "/signatures/2000001/tags", "read-token", "", http.StatusOK},
+		{"GET", "/signatures/9999999", "read-token", "", http.StatusNotFound},
+		{"GET", "/signatures/9999999/tags", "read-token", "", http.StatusNotFound},
+		{"GET", "/rules", "read-token", "", http.StatusNotFound},
+		{"POST", "/signatures/2000001/tags", "read-token", `{"tags":["a"]}`, http.StatusForbidden},
+		{"PUT", "/signatures/2000001/tags", "read-token", `{"tags":["a"]}`, http.StatusForbidden},
+		{"DELETE", "/signatures/2000001/tags/a", "read-token", "", http.StatusForbidden},
+		{"POST", "/signatures/2000001/disable", "read-token", `{"comment":"x"}`, http.StatusForbidden},
+		{"POST", "/signatures/2000001/disable", "admin-token", `{}`, http.StatusBadRequest},
+		{"POST", "/signatures/9999999/tags", "admin-token", `{"tags":["a"]}`, http.StatusNotFound},
+		{"POST", "/signatures/2000003/tags", "admin-token", `{"tags":["a"]}`, http.StatusNotFound},
+		{"PATCH", "/signatures/2000001", "admin-token", "", http.StatusMethodNotAllowed},
+		{"GET", "/signatures?limit=abc", "read-token", "", http.StatusBadRequest},
+		{"GET", "/signatures?offset=-1", "read-token", "", http.StatusBadRequest},
+		{"GET", "/signatures?enabled=maybe", "read-token", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := apiRequest(t, server, tt.method, tt.path, tt.token, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s %s (%s): код %d, ожидался %d: %s", tt.method, tt.path, tt.token, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: нет заголовка WWW-Authenticate", tt.method, tt.path)
+		}
+		if tt.want >= 400 {
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Errorf("%s %s: ответ об ошибке %s", tt.method, tt.path, w.Body)
+			}
+		}
+	}
+	if len(store.history) != 0 {
+		t.Errorf("запросы без прав изменили историю: %+v", store.history)
+	}
+}
+
+func TestAPISearchPagination(t *testing.T) {
+	store := newAPIStore(t, 5)
+	server := &apiServer{db: openFakeDB(t, store.query), tokens: apiTokens}
+
+	tests := []struct {
+		query  string
+		limit  int64
+		offset int64
+		sids   []string
+	}{
+		{"", defaultSearchLimit, 0, []string{"2000001", "2000002", "2000003", "2000004", "2000005"}},
+		{"?limit=2", 2, 0, []string{"2000001", "2000002"}},
+		{"?limit=2&offset=2", 2, 2, []string{"2000003", "2000004"}},
+		{"?limit=2&offset=4", 2, 4, []string{"2000005"}},
+		{"?limit=2&offset=10", 2, 10, []string{}},
+		{"?limit=100000", maxSearchLimit, 0, []string{"2000001", "2000002", "2000003", "2000004", "2000005"}},
+	}
+	for _, tt := range tests {
+		w := apiRequest(t, server, "GET", "/signatures"+tt.query, "read-token", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /signatures%s: код %d: %s", tt.query, w.Code, w.Body)
+		}
+		var page struct {
+			Total  int               `json:"total"`
+			Limit  int64             `json:"limit"`
+			Offset int64             `json:"offset"`
+			Items  []SignatureRecord `json:"items"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		sids := []string{}
+		for _, item := range page.Items {
+			sids = append(sids, item.SID)
+		}
+		// При смещении за последней страницей строк нет, и общее число неизвестно.
+		total := 5
+		if len(sids) == 0 {
+			total = 0
+		}
+		if page.Total != total || page.Limit != tt.limit || page.Offset != tt.offset || !reflect.DeepEqual(sids, tt.sids) {
+			t.Errorf("GET /signatures%s: total %d, limit %d, offset %d, sid %v", tt.query, page.Total, page.Limit, page.Offset, sids)
+		}
+		if last := store.limits[len(store.limits)-1]; last != [2]int64{tt.limit, tt.offset} {
+			t.Errorf("GET /signatures%s: LIMIT/OFFSET запроса %v", tt.query, last)
+		}
+	}
+}
+
+func TestAPITags(t *testing.T) {
+	store := newAPIStore(t, 1)
+	server := &apiServer{db: openFakeDB(t, store.query), tokens: apiTokens}
+	const path = "/signatures/2000001/tags"
+
+	tests := []struct {
+		method, path, body string
+		want               []string
+	}{
+		{"POST", path, `{"tags":["triage","fp:proxy"],"comment":"ложные срабатывания"}`, []string{"fp:proxy", "triage"}},
+		{"POST", path, `{"tags":["triage","apt"]}`, []string{"apt", "fp:proxy", "triage"}},
+		{"DELETE", path + "/triage", "", []string{"apt", "fp:proxy"}},
+		{"PUT", path, `{"tags":["reviewed"]}`, []string{"reviewed"}},
+		{"GET", path, "", []string{"reviewed"}},
+		{"PUT", path, `{"tags":[]}`, []string{}},
+	}
+	for _, tt := range tests {
+		w := apiRequest(t, server, tt.method, tt.path, "admin-token", tt.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: код %d: %s", tt.method, tt.path, w.Code, w.Body)
+		}
+		var result struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.Tags, tt.want) {
+			t.Errorf("%s %s: метки %v, ожидалось %v", tt.method, tt.path, result.Tags, tt.want)
+		}
+	}
+
+	// Каждое изменение записано в историю с именем токена; GET истории не меняет.
+	if len(store.history) != 5 {
+		t.Fatalf("событий истории %d, ожидалось 5: %+v", len(store.history), store.history)
+	}
+	first := store.history[0]
+	if first.Actor != "soc" || first.Comment != "ложные срабатывания" || first.Details != "fp:proxy,triage" {
+		t.Errorf("первое событие истории %+v", first)
+	}
+
+	for _, body := range []string{`{"tags":["two words"]}`, `{"tags":["a,b"]}`, `{"labels":["a"]}`, `{"tags":`} {
+		if w := apiRequest(t, server, "POST", path, "admin-token", body); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: код %d, ожидался 400", body, w.Code)
+		}
+	}
+
+	w := apiRequest(t, server, "GET", "/signatures/2000001", "read-token", "")
+	var detail struct {
+		SID     string         `json:"sid"`
+		History []HistoryEntry `json:"history"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.SID != "2000001" || len(detail.History) != 5 || detail.History[0].Details != "" {
+		t.Errorf("сигнатура %s, история %+v", detail.SID, detail.History)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile - файл выгрузки, который пишется во временный файл в каталоге назначения
+// и заменяет целевой файл только в commit: сенсор, читающий выгрузку во время
+// экспорта, видит либо старую версию, либо новую целиком.
+type atomicFile struct {
+	*bufio.Writer
+	file *os.File
+	path string
+	done bool
+}
+
+// createAtomic создаёт временный файл рядом с path. После записи нужно вызвать commit,
+// при ошибке - abort (abort после commit ничего не делает, его удобно вызывать через defer).
+func createAtomic(path string) (*atomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка создания временного файла для %s: %v", path, err)
+	}
+	return &atomicFile{Writer: bufio.NewWriterSize(file, 64*1024), file: file, path: path}, nil
+}
+
+// commit сбрасывает данные на диск и переименовывает временный файл в целевой.
+func (f *atomicFile) commit() error {
+	if f.done {
+		return fmt.Errorf("Файл %s уже закрыт", f.path)
+	}
+	err := f.Flush()
+	if err == nil {
+		err = f.file.Chmod(0644)
+	}
+	if err == nil {
+		err = f.file.Sync()
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.done = true
+	if err == nil {
+		err = os.Rename(f.file.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.file.Name())
+		return fmt.Errorf("Ошибка записи в файл %s: %v", f.path, err)
+	}
+	return syncDir(filepath.Dir(f.path))
+}
+
+// abort удаляет временный файл, если commit не был выполнен.
+func (f *atomicFile) abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// writeFileAtomic записывает data в path через временный файл.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return err
+	}
+	defer f.abort()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("Ошибка записи в файл %s: %v", path, err)
+	}
+	return f.commit()
+}
+
+// syncDir сбрасывает на диск каталог, чтобы переименование пережило сбой питания.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия каталога %s: %v", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("Ошибка синхронизации каталога %s: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CatalogEntry - сигнатура в виде данных для выгрузок NDJSON, CSV и SQLite.
+type CatalogEntry struct {
+	SID        string              `json:"sid"`
+	GID        string              `json:"gid"`
+	Rev        string              `json:"rev,omitempty"`
+	Action     string              `json:"action"`
+	Proto      string              `json:"proto"`
+	SrcIP      string              `json:"src_ip,omitempty"`
+	SrcPort    string              `json:"src_port,omitempty"`
+	Direction  string              `json:"direction,omitempty"`
+	DstIP      string              `json:"dst_ip,omitempty"`
+	DstPort    string              `json:"dst_port,omitempty"`
+	Msg        string              `json:"msg"`
+	Classtype  string              `json:"classtype,omitempty"`
+	Priority   string              `json:"priority,omitempty"`
+	References []Reference         `json:"references"`
+	Severity   string              `json:"severity,omitempty"`
+	Policies   []string            `json:"policies"`
+	CreatedAt  string              `json:"created_at,omitempty"`
+	UpdatedAt  string              `json:"updated_at,omitempty"`
+	Mitre      []string            `json:"mitre"`
+	Deployment []string            `json:"deployment"`
+	Metadata   map[string][]string `json:"metadata"`
+	Flowbits   []Flowbit           `json:"flowbits"`
+	Source     string              `json:"source"`
+	Filename   string              `json:"filename"`
+	Local      bool                `json:"local"`
+	Enabled    bool                `json:"enabled"`
+	Dialect    string              `json:"dialect,omitempty"`
+	MinVersion string              `json:"min_engine_version,omitempty"`
+	Rule       string              `json:"rule,omitempty"` // полный текст правила
+	Options    []RuleOption        `json:"-"`              // опции правила для шаблонов выгрузки
+}
+
+// Option возвращает значение первой опции правила с указанным именем или пустую строку.
+func (e CatalogEntry) Option(name string) string {
+	value, _ := (&Rule{Options: e.Options}).Option(name)
+	return value
+}
+
+// Values возвращает значения всех опций правила с указанным именем.
+func (e CatalogEntry) Values(name string) []string {
+	return (&Rule{Options: e.Options}).Values(name)
+}
+
+// Contents возвращает content правила, проверяющие указанные буферы (http_uri, dns_query...),
+// или все content, если буферы не указаны.
+func (e CatalogEntry) Contents(buffers ...string) []RuleContent {
+	contents := ruleContents(&Rule{Options: e.Options, Dialect: e.Dialect})
+	if len(buffers) == 0 {
+		return contents
+	}
+	var result []RuleContent
+	for _, c := range contents {
+		for _, buffer := range buffers {
+			if c.Buffer == normalizeBuffer(buffer) {
+				result = append(result, c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Reference - опция reference правила (reference:cve,2021-44228).
+type Reference struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Classification - класс правил из classification.config.
+type Classification struct {
+	Name        string
+	Description string
+	Priority    int
+}
+
+// Стандартные классы правил Suricata и ET (classification.config).
+var classifications = []Classification{
+	{"not-suspicious", "Not Suspicious Traffic", 3},
+	{"unknown", "Unknown Traffic", 3},
+	{"bad-unknown", "Potentially Bad Traffic", 2},
+	{"attempted-recon", "Attempted Information Leak", 2},
+	{"successful-recon-limited", "Information Leak", 2},
+	{"successful-recon-largescale", "Large Scale Information Leak", 2},
+	{"attempted-dos", "Attempted Denial of Service", 2},
+	{"successful-dos", "Denial of Service", 2},
+	{"attempted-user", "Attempted User Privilege Gain", 1},
+	{"unsuccessful-user", "Unsuccessful User Privilege Gain", 1},
+	{"successful-user", "Successful User Privilege Gain", 1},
+	{"attempted-admin", "Attempted Administrator Privilege Gain", 1},
+	{"successful-admin", "Successful Administrator Privilege Gain", 1},
+	{"rpc-portmap-decode", "Decode of an RPC Query", 2},
+	{"shellcode-detect", "Executable code was detected", 1},
+	{"string-detect", "A suspicious string was detected", 3},
+	{"suspicious-filename-detect", "A suspicious filename was detected", 2},
+	{"suspicious-login", "An attempted login using a suspicious username was detected", 2},
+	{"system-call-detect", "A system call was detected", 2},
+	{"tcp-connection", "A TCP connection was detected", 4},
+	{"trojan-activity", "A Network Trojan was detected", 1},
+	{"unusual-client-port-connection", "A client was using an unusual port", 2},
+	{"network-scan", "Detection of a Network Scan", 3},
+	{"denial-of-service", "Detection of a Denial of Service Attack", 2},
+	{"non-standard-protocol", "Detection of a non-standard protocol or event", 2},
+	{"protocol-command-decode", "Generic Protocol Command Decode", 3},
+	{"web-application-activity", "Access to a potentially vulnerable web application", 2},
+	{"web-application-attack", "Web Application Attack", 1},
+	{"misc-activity", "Misc activity", 3},
+	{"misc-attack", "Misc Attack", 2},
+	{"icmp-event", "Generic ICMP event", 3},
+	{"inappropriate-content", "Inappropriate Content was Detected", 1},
+	{"policy-violation", "Potential Corporate Privacy Violation", 1},
+	{"default-login-attempt", "Attempt to login by a default username and password", 2},
+	{"targeted-activity", "Targeted Malicious Activity was Detected", 1},
+	{"exploit-kit", "Exploit Kit Activity Detected", 1},
+	{"external-ip-check", "Device Retrieving External IP Address Detected", 2},
+	{"domain-c2", "Domain Observed Used for C2 Detected", 1},
+	{"pup-activity", "Possibly Unwanted Program Detected", 2},
+	{"credential-theft", "Successful Credential Theft Detected", 1},
+	{"social-engineering", "Possible Social Engineering Attempted", 2},
+	{"coin-mining", "Crypto Currency Mining Activity Detected", 2},
+	{"command-and-control", "Malware Command and Control Activity Detected", 1},
+}
+
+// Префиксы ссылок из reference.config.
+var referenceURLs = map[string]string{
+	"bugtraq": "http://www.securityfocus.com/bid/",
+	"cve":     "https://cve.mitre.org/cgi-bin/cvename.cgi?name=CVE-",
+	"nessus":  "http://cgi.nessus.org/plugins/dump.php3?id=",
+	"osvdb":   "http://osvdb.org/show/osvdb/",
+	"msb":     "http://technet.microsoft.com/en-us/security/bulletin/",
+	"url":     "http://",
+}
+
+// URL возвращает ссылку для reference или пустую строку для неизвестного типа.
+func (r Reference) URL() string {
+	prefix, ok := referenceURLs[strings.ToLower(r.Type)]
+	if !ok {
+		return ""
+	}
+	value := r.Value
+	switch strings.ToLower(r.Type) {
+	case "url":
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			return value
+		}
+	case "cve":
+		value = strings.TrimPrefix(strings.ToUpper(value), "CVE-")
+	}
+	return prefix + value
+}
+
+// Столбцы CSV по умолчанию и все доступные столбцы.
+var (
+	defaultCatalogColumns = []string{"sid", "gid", "rev", "action", "proto", "src_ip", "src_port", "direction",
+		"dst_ip", "dst_port", "msg", "classtype", "severity", "policies", "mitre", "created_at", "updated_at",
+		"source", "filename", "enabled", "min_engine_version"}
+	catalogColumns = append(append([]string{}, defaultCatalogColumns...),
+		"priority", "references", "deployment", "flowbits", "local", "dialect", "rule")
+)
+
+// catalogEntry собирает запись каталога из сигнатуры. Поля metadata, classtype и
+// reference берутся из сохранённых опций правила.
+func catalogEntry(sig Signature) CatalogEntry {
+	rule := sig.rule()
+	meta := parseMetadata(rule)
+	entry := CatalogEntry{
+		SID:        sig.SID,
+		GID:        "1",
+		Action:     sig.Type,
+		Proto:      sig.Proto,
+		SrcIP:      sig.SrcIP,
+		SrcPort:    sig.SrcPort,
+		Direction:  rule.Direction,
+		DstIP:      sig.DstIP,
+		DstPort:    sig.DstPort,
+		Msg:        sig.Msg,
+		References: []Reference{},
+		Severity:   meta.Severity,
+		Policies:   nonNil(meta.Policies),
+		CreatedAt:  meta.CreatedAt,
+		UpdatedAt:  meta.UpdatedAt,
+		Mitre:      nonNil(meta.Mitre),
+		Deployment: nonNil(meta.Deployment),
+		Metadata:   meta.Pairs,
+		Flowbits:   sig.Flowbits,
+		Source:     sig.Source,
+		Filename:   sig.Filename,
+		Local:      sig.Local,
+		Enabled:    sig.Enabled,
+		Dialect:    sig.Dialect,
+		MinVersion: sig.MinVersion,
+		Options:    sig.Options,
+	}
+	if entry.Flowbits == nil {
+		entry.Flowbits = []Flowbit{}
+	}
+	if gid, ok := rule.Option("gid"); ok {
+		entry.GID = gid
+	}
+	entry.Rev, _ = rule.Option("rev")
+	entry.Classtype, _ = rule.Option("classtype")
+	entry.Priority, _ = rule.Option("priority")
+	for _, ref := range rule.Values("reference") {
+		kind, value, _ := strings.Cut(ref, ",")
+		entry.References = append(entry.References, Reference{Type: strings.TrimSpace(kind), Value: strings.TrimSpace(value)})
+	}
+	if len(sig.Options) > 0 {
+		entry.Rule = rule.String()
+	}
+	return entry
+}
+
+// column возвращает значение столбца CSV; списки записываются через запятую.
+func (e CatalogEntry) column(name string) string {
+	switch name {
+	case "sid":
+		return e.SID
+	case "gid":
+		return e.GID
+	case "rev":
+		return e.Rev
+	case "action":
+		return e.Action
+	case "proto":
+		return e.Proto
+	case "src_ip":
+		return e.SrcIP
+	case "src_port":
+		return e.SrcPort
+	case "direction":
+		return e.Direction
+	case "dst_ip":
+		return e.DstIP
+	case "dst_port":
+		return e.DstPort
+	case "msg":
+		return e.Msg
+	case "classtype":
+		return e.Classtype
+	case "priority":
+		return e.Priority
+	case "severity":
+		return e.Severity
+	case "policies":
+		return strings.Join(e.Policies, ",")
+	case "mitre":
+		return strings.Join(e.Mitre, ",")
+	case "deployment":
+		return strings.Join(e.Deployment, ",")
+	case "references":
+		refs := make([]string, len(e.References))
+		for i, ref := range e.References {
+			refs[i] = ref.Type + ":" + ref.Value
+		}
+		return strings.Join(refs, ",")
+	case "flowbits":
+		bits := make([]string, len(e.Flowbits))
+		for i, bit := range e.Flowbits {
+			bits[i] = strings.TrimSuffix(bit.Cmd+":"+bit.Name, ":")
+		}
+		return strings.Join(bits, ",")
+	case "created_at":
+		return e.CreatedAt
+	case "updated_at":
+		return e.UpdatedAt
+	case "source":
+		return e.Source
+	case "filename":
+		return e.Filename
+	case "local":
+		return strconv.FormatBool(e.Local)
+	case "enabled":
+		return strconv.FormatBool(e.Enabled)
+	case "dialect":
+		return e.Dialect
+	case "min_engine_version":
+		return e.MinVersion
+	case "rule":
+		return e.Rule
+	}
+	return ""
+}
+
+// checkCatalogColumns проверяет, что все запрошенные столбцы CSV существуют.
+func checkCatalogColumns(columns []string) error {
+	known := map[string]bool{}
+	for _, c := range catalogColumns {
+		known[c] = true
+	}
+	for _, c := range columns {
+		if !known[c] {
+			return fmt.Errorf("Неизвестный столбец: %s (доступны: %s)", c, strings.Join(catalogColumns, ", "))
+		}
+	}
+	return nil
+}
+
+// catalogWriter записывает записи каталога по одной, по мере чтения из БД.
+type catalogWriter interface {
+	write(entry CatalogEntry) error
+	flush() error
+}
+
+// ndjsonWriter записывает каждую запись каталога отдельной строкой JSON.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &ndjsonWriter{enc: enc}
+}
+
+func (w *ndjsonWriter) write(entry CatalogEntry) error {
+	if err := w.enc.Encode(entry); err != nil {
+		return fmt.Errorf("Ошибка сериализации (SID: %s): %v", entry.SID, err)
+	}
+	return nil
+}
+
+func (w *ndjsonWriter) flush() error {
+	return nil
+}
+
+// csvWriter записывает каталог в CSV с заголовком из выбранных столбцов.
+type csvWriter struct {
+	cw      *csv.Writer
+	columns []string
+	row     []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return nil, err
+	}
+	return &csvWriter{cw: cw, columns: columns, row: make([]string, len(columns))}, nil
+}
+
+func (w *csvWriter) write(entry CatalogEntry) error {
+	for i, c := range w.columns {
+		w.row[i] = entry.column(c)
+	}
+	return w.cw.Write(w.row)
+}
+
+func (w *csvWriter) flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// catalogRules - правила для тестов выгрузок каталога.
+var catalogRules = []string{
+	`alert http $HOME_NET any -> $EXTERNAL_NET 80 (msg:"ET MALWARE a, \"b\""; flow:established,to_server; flowbits:set,mal; http.uri; content:"/x"; reference:url,example.com/a; reference:cve,2021-44228; classtype:trojan-activity; metadata:signature_severity Major, policy balanced-ips drop, mitre_technique_id T1190, created_at 2021_12_10; sid:2000001; rev:3;)`,
+	`drop tcp any any -> any any (msg:"second"; content:"b"; gid:3; classtype:custom-class; sid:2000002;)`,
+}
+
+func catalogEntries(t *testing.T) []CatalogEntry {
+	t.Helper()
+	var entries []CatalogEntry
+	for _, text := range catalogRules {
+		entries = append(entries, templateEntry(t, text))
+	}
+	return entries
+}
+
+func TestCSVWriterColumns(t *testing.T) {
+	columns := []string{"sid", "gid", "msg", "references", "policies", "flowbits", "local", "min_engine_version"}
+	if err := checkCatalogColumns(columns); err != nil {
+		t.Fatalf("checkCatalogColumns: %v", err)
+	}
+	if err := checkCatalogColumns([]string{"sid", "nosuch"}); err == nil || !strings.Contains(err.Error(), "nosuch") {
+		t.Errorf("ошибка для неизвестного столбца: %v", err)
+	}
+
+	var b bytes.Buffer
+	w, err := newCSVWriter(&b, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range catalogEntries(t) {
+		if err := w.write(e); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV не читается: %v\n%s", err, b.String())
+	}
+	want := [][]string{
+		columns,
+		{"2000001", "1", `ET MALWARE a, "b"`, "url:example.com/a,cve:2021-44228", "balanced-ips", "set:mal", "false", ""},
+		{"2000002", "3", "second", "", "", "", "false", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("CSV %q, ожидалось %q", records, want)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := newNDJSONWriter(&b)
+	for _, e := range catalogEntries(t) {
+		if err := w.write(e); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("строк %d, ожидалось 2:\n%s", len(lines), b.String())
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("строка не JSON: %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"sid": "2000001", "gid": "1", "rev": "3", "action": "alert", "msg": `ET MALWARE a, "b"`,
+		"classtype": "trojan-activity", "severity": "Major", "created_at": "2021-12-10", "local": false,
+	} {
+		if first[key] != want {
+			t.Errorf("поле %s = %v, ожидалось %v", key, first[key], want)
+		}
+	}
+	if _, ok := first["options"]; ok {
+		t.Error("опции правила не должны попадать в NDJSON")
+	}
+	if refs, _ := json.Marshal(first["references"]); string(refs) != `[{"type":"url","value":"example.com/a"},{"type":"cve","value":"2021-44228"}]` {
+		t.Errorf("references %s", refs)
+	}
+
+	// Пустые списки записываются как [], а не null; пустые необязательные поля опускаются.
+	var second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("строка не JSON: %v", err)
+	}
+	for _, key := range []string{"references", "policies", "mitre", "deployment", "flowbits"} {
+		if list, ok := second[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("поле %s = %#v, ожидался пустой список", key, second[key])
+		}
+	}
+	for _, key := range []string{"rev", "severity", "created_at", "min_engine_version"} {
+		if _, ok := second[key]; ok {
+			t.Errorf("пустое поле %s записано", key)
+		}
+	}
+	if strings.Contains(lines[0], `&`) || !strings.Contains(lines[0], `"rule":"alert http`) {
+		t.Errorf("строка %s", lines[0])
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RuleContent - опция content правила в виде для поиска.
+type RuleContent struct {
+	Position  int               `json:"position"` // номер content в правиле, начиная с 0
+	Buffer    string            `json:"buffer"`   // буфер проверки: pkt_data, http_uri, dns_query...
+	Value     string            `json:"value"`    // значение опции, как в правиле
+	Pattern   []byte            `json:"-"`        // байты после раскрытия экранирования и |hex|
+	Negated   bool              `json:"negated,omitempty"`
+	Nocase    bool              `json:"nocase,omitempty"`
+	Modifiers map[string]string `json:"modifiers,omitempty"` // depth, offset, fast_pattern...
+}
+
+// Буфер content без sticky-буфера и модификатора: данные пакета или потока.
+const defaultContentBuffer = "pkt_data"
+
+// Sticky-буферы без точки в имени. Все буферы Suricata с точкой в имени (http.uri,
+// dns.query, tls.sni...) тоже sticky.
+var stickyBuffers = keywordSet(`file_data pkt_data raw_data js_data base64_data
+dns_query tls_sni tls_cert_subject tls_cert_issuer tls_cert_serial tls_cert_fingerprint
+ja3_hash ja3_string ja3s_hash ja3s_string`)
+
+// Опции с точкой в имени без значения, которые не являются буферами.
+var notBuffers = keywordSet(`tls.store`)
+
+// Имена буферов Suricata, которые не сводятся к модификатору Snort 2 заменой точки на "_".
+var contentBufferNames = map[string]string{
+	"file.data":          "file_data",
+	"http.uri.raw":       "http_raw_uri",
+	"http.header.raw":    "http_raw_header",
+	"http.host.raw":      "http_raw_host",
+	"http.cookie.raw":    "http_raw_cookie",
+	"http.request_body":  "http_client_body",
+	"http.response_body": "http_server_body",
+}
+
+// normalizeBuffer приводит имена буферов Suricata, Snort 2 и Snort 3 к одному виду:
+// http.uri, http_uri (модификатор Snort 2) и http_uri (sticky-буфер Snort 3) - это http_uri.
+func normalizeBuffer(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if buffer, ok := contentBufferNames[name]; ok {
+		return buffer
+	}
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// ruleContents возвращает опции content правила с буфером, модификаторами и раскрытыми байтами.
+// Sticky-буфер действует до следующего буфера, модификатор буфера Snort 2 (http_uri после
+// content) относится к предыдущему content. content, которые не удалось раскрыть (ошибку
+// показывает lint), пропускаются.
+func ruleContents(rule *Rule) []RuleContent {
+	var contents []RuleContent
+	sticky := defaultContentBuffer
+	last := -1 // индекс последнего content в contents
+	position := 0
+
+	for _, opt := range rule.Options {
+		name := opt.Name
+		switch {
+		case name == "content" || name == "uricontent":
+			last = -1
+			position++
+			pattern, negated, err := decodeContent(opt.Value)
+			if err != nil {
+				continue
+			}
+			buffer := sticky
+			if name == "uricontent" {
+				buffer = "http_uri"
+			}
+			contents = append(contents, RuleContent{Position: position - 1, Buffer: buffer, Value: opt.Value, Pattern: pattern, Negated: negated})
+			last = len(contents) - 1
+		case rule.Dialect == DialectSnort3 && strings.HasPrefix(name, "http_"):
+			// В Snort 3 HTTP-буферы - sticky и могут иметь параметры (http_header:field host).
+			sticky = name
+		case last >= 0 && name == "nocase":
+			contents[last].Nocase = true
+		case last >= 0 && (contentModifiers[name] || snort3ContentModifiers[name]):
+			if contents[last].Modifiers == nil {
+				contents[last].Modifiers = map[string]string{}
+			}
+			contents[last].Modifiers[name] = opt.Value
+		case last >= 0 && opt.Value == "" && strings.HasPrefix(name, "http_"):
+			contents[last].Buffer = name
+		case opt.Value == "" && (stickyBuffers[name] || strings.Contains(name, ".") && !notBuffers[name]):
+			sticky = normalizeBuffer(name)
+		}
+	}
+	return contents
+}
+
+// decodeContent раскрывает значение content или строку поиска в том же синтаксисе:
+// !"..." - отрицание, кавычки и экранирование \" \; \\ снимаются, участки |0d 0a|
+// заменяются байтами.
+func decodeContent(value string) ([]byte, bool, error) {
+	value = strings.TrimSpace(value)
+	negated := strings.HasPrefix(value, "!")
+	if negated {
+		value = strings.TrimSpace(value[1:])
+	}
+	value = unquote(value)
+
+	parts := strings.Split(value, "|")
+	if len(parts)%2 == 0 {
+		return nil, negated, fmt.Errorf("Незакрытый участок |hex| в content: %s", value)
+	}
+	var pattern []byte
+	for i, part := range parts {
+		if i%2 == 0 {
+			pattern = append(pattern, part...)
+			continue
+		}
+		data, err := hex.DecodeString(strings.Join(strings.Fields(part), ""))
+		if err != nil {
+			return nil, negated, fmt.Errorf("Некорректный участок |%s| в content: %v", part, err)
+		}
+		pattern = append(pattern, data...)
+	}
+	if len(pattern) == 0 {
+		return nil, negated, fmt.Errorf("Пустой content")
+	}
+	return pattern, negated, nil
+}
+
+// asciiLower переводит в нижний регистр только латинские буквы, как nocase движков;
+// остальные байты, в том числе не UTF-8, не меняются.
+func asciiLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, b := range data {
+		if b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		lower[i] = b
+	}
+	return lower
+}
+
+// saveRuleContents заменяет сохранённые content сигнатуры.
+func saveRuleContents(db *sql.DB, sid string, contents []RuleContent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM signature_contents WHERE sid = $1`, sid); err != nil {
+		return fmt.Errorf("Ошибка удаления content (SID: %s): %v", sid, err)
+	}
+	for _, c := range contents {
+		modifiers, err := json.Marshal(c.Modifiers)
+		if err != nil {
+			return fmt.Errorf("Ошибка сериализации модификаторов content: %v", err)
+		}
+		if c.Modifiers == nil {
+			modifiers = []byte("{}")
+		}
+		_, err = tx.Exec(`
+            INSERT INTO signature_contents (sid, position, buffer, value, pattern, pattern_lower, negated, nocase, modifiers)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			sid, c.Position, c.Buffer, c.Value, c.Pattern, asciiLower(c.Pattern), c.Negated, c.Nocase, string(modifiers))
+		if err != nil {
+			return fmt.Errorf("Ошибка записи content (SID: %s): %v", sid, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return nil
+}
+
+// refreshContents заново заполняет signature_contents по сохранённым опциям, например для
+// сигнатур, загруженных до появления поиска по content или после изменения разбора.
+func refreshContents(db *sql.DB) (int, error) {
+	contents := map[string][]RuleContent{}
+	err := querySignatures(db, "deleted_at IS NULL AND options != '[]'::JSONB", nil, func(sig Signature) error {
+		contents[sig.SID] = ruleContents(sig.rule())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec(`DELETE FROM signature_contents WHERE sid NOT IN (SELECT sid FROM signatures WHERE deleted_at IS NULL)`); err != nil {
+		return 0, fmt.Errorf("Ошибка удаления content удалённых сигнатур: %v", err)
+	}
+	for sid, list := range contents {
+		if err := saveRuleContents(db, sid, list); err != nil {
+			return 0, err
+		}
+	}
+	return len(contents), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeContent(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []byte
+		negated bool
+	}{
+		{`"abc"`, []byte("abc"), false},
+		{`!"abc"`, []byte("abc"), true},
+		{`"|0d 0a|Host|3a 20|"`, []byte("\r\nHost: "), false},
+		{`"|0D0A|"`, []byte("\r\n"), false},
+		{`"a\"b\;c\\"`, []byte(`a"b;c\`), false},
+	}
+	for _, tt := range tests {
+		got, negated, err := decodeContent(tt.value)
+		if err != nil {
+			t.Errorf("decodeContent(%s): %v", tt.value, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) || negated != tt.negated {
+			t.Errorf("decodeContent(%s) = %q, %v, ожидалось %q, %v", tt.value, got, negated, tt.want, tt.negated)
+		}
+	}
+
+	for _, value := range []string{`"|0d 0a"`, `"|zz|"`, `""`, `"|0|"`} {
+		if _, _, err := decodeContent(value); err == nil {
+			t.Errorf("ожидалась ошибка для %s", value)
+		}
+	}
+}
+
+func TestRuleContents(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dialect string
+		buffers []string
+	}{
+		{"suricata sticky buffers",
+			`alert http any any -> any any (content:"a"; http.uri; content:"b"; nocase; http.header; content:"c"; sid:1;)`,
+			DialectSuricata, []string{"pkt_data", "http_uri", "http_header"}},
+		{"snort2 modifiers",
+			`alert tcp any any -> any any (content:"a"; http_uri; content:"b"; nocase; depth:4; http_header; uricontent:"c"; sid:1;)`,
+			DialectSnort2, []string{"http_uri", "http_header", "http_uri"}},
+		{"snort3 sticky buffers",
+			`alert http (http_uri; content:"a"; http_header:field host; content:"b"; sid:1;)`,
+			DialectSnort3, []string{"http_uri", "http_header"}},
+		{"file data",
+			`alert http any any -> any any (file.data; content:"a"; pkt_data; content:"b"; sid:1;)`,
+			DialectSuricata, []string{"file_data", "pkt_data"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.Dialect = tt.dialect
+			contents := ruleContents(rule)
+			if len(contents) != len(tt.buffers) {
+				t.Fatalf("content %d, ожидалось %d: %+v", len(contents), len(tt.buffers), contents)
+			}
+			for i, c := range contents {
+				if c.Buffer != tt.buffers[i] || c.Position != i {
+					t.Errorf("content %d: буфер %s, позиция %d, ожидалось %s, %d", i, c.Buffer, c.Position, tt.buffers[i], i)
+				}
+			}
+		})
+	}
+
+	rule, _ := parseRule(`alert tcp any any -> any any (content:"A"; nocase; depth:4; fast_pattern; sid:1;)`)
+	c := ruleContents(rule)[0]
+	if !c.Nocase || c.Modifiers["depth"] != "4" || len(c.Modifiers) != 2 {
+		t.Errorf("модификаторы content: %+v", c)
+	}
+}
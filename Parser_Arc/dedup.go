@@ -0,0 +1,124 @@
+//go:build dedup
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB DBConfig `mapstructure:"db"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+var config Config
+
+func main() {
+	initLog()
+
+	format := flag.String("format", "text", "Формат отчёта: text или json")
+	all := flag.Bool("all", false, "Показывать также дубли внутри одного источника")
+	refresh := flag.Bool("refresh", false, "Пересчитать отпечатки по сохранённым опциям перед поиском")
+	flag.Parse()
+
+	log.Println("=== Старт поиска дублей ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	if *refresh {
+		updated, err := refreshFingerprints(db)
+		if err != nil {
+			log.Fatalf("Ошибка пересчёта отпечатков: %v", err)
+		}
+		log.Printf("Пересчитано отпечатков: %d", updated)
+	}
+
+	groups, err := findDuplicates(db, !*all)
+	if err != nil {
+		log.Fatalf("Ошибка поиска дублей: %v", err)
+	}
+
+	if err := printDuplicates(groups, *format); err != nil {
+		log.Fatalf("Ошибка вывода отчёта: %v", err)
+	}
+	log.Printf("Найдено групп дублей: %d", len(groups))
+	log.Println("=== Завершение поиска дублей ===")
+}
+
+func printDuplicates(groups []DuplicateGroup, format string) error {
+	switch format {
+	case "json":
+		if groups == nil {
+			groups = []DuplicateGroup{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(groups)
+	case "text":
+		for _, group := range groups {
+			fmt.Printf("%s\n", group.Fingerprint)
+			for _, sig := range group.Signatures {
+				state := "вкл"
+				if !sig.Enabled {
+					state = "выкл"
+				}
+				fmt.Printf("    %-20s sid:%-10s %-4s %s\n", sig.Source, sig.SID, state, sig.Msg)
+			}
+		}
+		fmt.Printf("Групп дублей: %d\n", len(groups))
+		return nil
+	}
+	return fmt.Errorf("Неподдерживаемый формат отчёта: %s", format)
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало поиска дублей ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
@@ -0,0 +1,56 @@
+//go:build dedup
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout возвращает вывод fn в os.Stdout.
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = fn()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestPrintDuplicates(t *testing.T) {
+	groups := []DuplicateGroup{{Fingerprint: "abc", Signatures: []DuplicateItem{
+		{Source: "ET Open", SID: "2000001", Msg: "ET TEST", Enabled: true},
+		{Source: "Snort", SID: "1001", Msg: "TEST", Enabled: false},
+	}}}
+
+	text := captureStdout(t, func() error { return printDuplicates(groups, "text") })
+	for _, part := range []string{"abc\n", "ET Open", "sid:2000001", "вкл", "выкл", "Групп дублей: 1"} {
+		if !strings.Contains(text, part) {
+			t.Errorf("в отчёте нет %q:\n%s", part, text)
+		}
+	}
+
+	var parsed []DuplicateGroup
+	out := captureStdout(t, func() error { return printDuplicates(groups, "json") })
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil || len(parsed) != 1 || len(parsed[0].Signatures) != 2 {
+		t.Errorf("JSON-отчёт %s: %v", out, err)
+	}
+	if out := captureStdout(t, func() error { return printDuplicates(nil, "json") }); strings.TrimSpace(out) != "[]" {
+		t.Errorf("пустой JSON-отчёт %s", out)
+	}
+	if err := printDuplicates(groups, "xml"); err == nil {
+		t.Error("ожидалась ошибка для формата xml")
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeltaManifest - изменения выгрузки профиля с прошлой выгрузки (метки since) до until.
+// Применённый к файлу прошлой выгрузки (BaseSHA256), он даёт файл с хэшем SHA256.
+type DeltaManifest struct {
+	Profile    string      `json:"profile"`
+	Format     string      `json:"format"`
+	Since      time.Time   `json:"since"`
+	Until      time.Time   `json:"until"`
+	BaseSHA256 string      `json:"base_sha256"`
+	SHA256     string      `json:"sha256"`
+	Header     []string    `json:"header"` // заголовок новой полной выгрузки
+	Added      []DeltaRule `json:"added"`
+	Changed    []DeltaRule `json:"changed"`
+	Removed    []DeltaRule `json:"removed"`
+}
+
+// DeltaRule - правило дельты; для удалённых правил Rule пустой.
+type DeltaRule struct {
+	GID  string `json:"gid"`
+	SID  string `json:"sid"`
+	Rule string `json:"rule,omitempty"`
+}
+
+// Форматы, для которых создаются дельты: одно правило в строке.
+var deltaFormats = map[string]bool{DialectSuricata: true, DialectSnort3: true}
+
+// ruleGID возвращает gid правила; правила без опции gid относятся к gid 1.
+func ruleGID(rule *Rule) string {
+	if gid, ok := rule.Option("gid"); ok {
+		return gid
+	}
+	return "1"
+}
+
+// loadWatermark возвращает время и хэш прошлой выгрузки профиля.
+func loadWatermark(db *sql.DB, profile string) (time.Time, string, bool, error) {
+	var at time.Time
+	var sum sql.NullString
+	err := db.QueryRow(`SELECT exported_at, sha256 FROM export_watermarks WHERE profile = $1`, profile).Scan(&at, &sum)
+	if err == sql.ErrNoRows {
+		return at, "", false, nil
+	}
+	if err != nil {
+		return at, "", false, fmt.Errorf("Ошибка чтения метки выгрузки %s: %v", profile, err)
+	}
+	return at, sum.String, true, nil
+}
+
+// saveWatermark записывает метку выгрузки профиля: время начала выгрузки и хэш файла.
+func saveWatermark(db *sql.DB, profile string, at time.Time, sum string) error {
+	_, err := db.Exec(`
+        INSERT INTO export_watermarks (profile, exported_at, sha256) VALUES ($1, $2, $3)
+        ON CONFLICT (profile) DO UPDATE SET exported_at = EXCLUDED.exported_at, sha256 = EXCLUDED.sha256`,
+		profile, at, nullIfEmpty(sum))
+	if err != nil {
+		return fmt.Errorf("Ошибка записи метки выгрузки %s: %v", profile, err)
+	}
+	return nil
+}
+
+// writeDeltaRules записывает дельту в виде файла правил: добавленные и изменённые
+// правила целиком, удалённые - строками комментария # removed gid:sid.
+func writeDeltaRules(w io.Writer, m *DeltaManifest) {
+	fmt.Fprintf(w, "# delta format:%s; profile:%s; since:%s; until:%s; added:%d; changed:%d; removed:%d\n",
+		m.Format, m.Profile, m.Since.Format(time.RFC3339), m.Until.Format(time.RFC3339), len(m.Added), len(m.Changed), len(m.Removed))
+	fmt.Fprintf(w, "# base_sha256:%s; sha256:%s\n", m.BaseSHA256, m.SHA256)
+	for _, section := range []struct {
+		name  string
+		rules []DeltaRule
+	}{{"added", m.Added}, {"changed", m.Changed}} {
+		fmt.Fprintf(w, "# %s\n", section.name)
+		for _, r := range section.rules {
+			fmt.Fprintln(w, r.Rule)
+		}
+	}
+	for _, r := range m.Removed {
+		fmt.Fprintf(w, "# removed %s:%s\n", r.GID, r.SID)
+	}
+}
+
+// applyDelta применяет дельту к файлу полной выгрузки и возвращает новый файл:
+// заголовок из дельты и правила в порядке (gid, sid), как их записывает экспорт.
+// Если хэши базы или результата не совпадают с дельтой, возвращается ошибка, и сенсор
+// должен получить полную выгрузку; force отключает проверку.
+func applyDelta(base []byte, m *DeltaManifest, force bool) ([]byte, error) {
+	if !deltaFormats[m.Format] {
+		return nil, fmt.Errorf("Дельты не поддерживаются для формата %s", m.Format)
+	}
+	if sum := sha256Hex(base); sum != m.BaseSHA256 && !force {
+		return nil, fmt.Errorf("Файл не соответствует базе дельты: SHA-256 %s, ожидается %s", sum, m.BaseSHA256)
+	}
+
+	rules, err := ruleLines(string(base), parseRule)
+	if err != nil {
+		return nil, err
+	}
+	// В removed могут быть sid, которых в базе нет (см. deltaRemoved): они пропускаются.
+	for _, r := range m.Removed {
+		delete(rules, r.SID)
+	}
+	for _, list := range [][]DeltaRule{m.Changed, m.Added} {
+		for _, r := range list {
+			rules[r.SID] = r
+		}
+	}
+
+	sorted := make([]DeltaRule, 0, len(rules))
+	for _, r := range rules {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return lessGIDSID(sorted[i], sorted[j]) })
+
+	var b strings.Builder
+	b.WriteString(strings.Join(m.Header, "\n") + "\n")
+	for _, r := range sorted {
+		b.WriteString(r.Rule + "\n")
+	}
+	result := []byte(b.String())
+	if sum := sha256Hex(result); sum != m.SHA256 && !force {
+		return nil, fmt.Errorf("Результат применения дельты не совпадает с выгрузкой: SHA-256 %s, ожидается %s", sum, m.SHA256)
+	}
+	return result, nil
+}
+
+// ruleLines разбирает файл выгрузки с одним правилом в строке и возвращает правила по sid.
+// Строки комментариев пропускаются; parse - разбор строки (parseRule или parseDionisRecord).
+func ruleLines(content string, parse func(string) (*Rule, error)) (map[string]DeltaRule, error) {
+	rules := map[string]DeltaRule{}
+	for i, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("строка %d: %v", i+1, err)
+		}
+		sid, _ := rule.Option("sid")
+		rules[sid] = DeltaRule{GID: ruleGID(rule), SID: sid, Rule: line}
+	}
+	return rules, nil
+}
+
+// lessGIDSID повторяет порядок выгрузки exportOrder: числовые gid и sid по возрастанию,
+// нечисловые - после них в порядке строк.
+func lessGIDSID(a, b DeltaRule) bool {
+	if c := compareNumeric(a.GID, b.GID); c != 0 {
+		return c < 0
+	}
+	if c := compareNumeric(a.SID, b.SID); c != 0 {
+		return c < 0
+	}
+	return a.SID < b.SID
+}
+
+func compareNumeric(a, b string) int {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA != nil && errB != nil:
+		return 0
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readDeltaManifest разбирает JSON-манифест дельты.
+func readDeltaManifest(data []byte) (*DeltaManifest, error) {
+	var m DeltaManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Ошибка разбора дельты: %v", err)
+	}
+	return &m, nil
+}
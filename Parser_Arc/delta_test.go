@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestApplyDelta(t *testing.T) {
+	rule := func(sid, msg string) string {
+		return `alert tcp any any -> any any (msg:"` + msg + `"; content:"a"; sid:` + sid + `;)`
+	}
+	gidRule := `alert tcp any any -> any any (msg:"gid"; gid:3; sid:5;)`
+	base := []byte(strings.Join([]string{
+		"# export format:suricata; filter:source=ET",
+		rule("2", "two"),
+		rule("10", "ten"),
+		rule("100", "hundred"),
+		gidRule,
+	}, "\n") + "\n")
+	want := []byte(strings.Join([]string{
+		"# export format:suricata; filter:source=ET,PT",
+		rule("2", "two"),
+		rule("9", "nine"),
+		rule("10", "ten v2"),
+		gidRule,
+	}, "\n") + "\n")
+
+	m := &DeltaManifest{
+		Format:     DialectSuricata,
+		BaseSHA256: sha256Hex(base),
+		SHA256:     sha256Hex(want),
+		Header:     []string{"# export format:suricata; filter:source=ET,PT"},
+		Added:      []DeltaRule{{GID: "1", SID: "9", Rule: rule("9", "nine")}},
+		Changed:    []DeltaRule{{GID: "1", SID: "10", Rule: rule("10", "ten v2")}},
+		// sid 500 в базе нет: такое правило могло не проходить фильтр и в прошлой выгрузке.
+		Removed: []DeltaRule{{GID: "1", SID: "100"}, {GID: "1", SID: "500"}},
+	}
+	got, err := applyDelta(base, m, false)
+	if err != nil {
+		t.Fatalf("applyDelta: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("результат:\n%s\nожидалось:\n%s", got, want)
+	}
+
+	// Дельта к другой базе не применяется без force.
+	other := append([]byte("# changed\n"), base...)
+	if _, err := applyDelta(other, m, false); err == nil || !strings.Contains(err.Error(), "базе дельты") {
+		t.Errorf("ожидалась ошибка несовпадения базы, получено %v", err)
+	}
+	if got, err := applyDelta(other, m, true); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("applyDelta с force: %v\n%s", err, got)
+	}
+
+	// Результат, не совпадающий с хэшем дельты, отклоняется.
+	broken := *m
+	broken.SHA256 = sha256Hex([]byte("other"))
+	if _, err := applyDelta(base, &broken, false); err == nil || !strings.Contains(err.Error(), "Результат") {
+		t.Errorf("ожидалась ошибка хэша результата, получено %v", err)
+	}
+
+	dionis := *m
+	dionis.Format = DialectDionis
+	if _, err := applyDelta(base, &dionis, false); err == nil {
+		t.Error("ожидалась ошибка для формата dionis")
+	}
+}
+
+func TestLessGIDSID(t *testing.T) {
+	rules := []DeltaRule{
+		{GID: "1", SID: "2"}, {GID: "1", SID: "10"}, {GID: "1", SID: "x"}, {GID: "3", SID: "1"},
+	}
+	for i := 0; i+1 < len(rules); i++ {
+		if !lessGIDSID(rules[i], rules[i+1]) || lessGIDSID(rules[i+1], rules[i]) {
+			t.Errorf("порядок %+v и %+v нарушен", rules[i], rules[i+1])
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Версия схемы записей Dionis-NX. Увеличивается при любом изменении набора или
+// порядка полей, экранирования и сопоставления действий.
+const DionisSchemaVersion = 2
+
+// Лимит правил в одном файле по умолчанию.
+const DionisDefaultMaxRules = 10000
+
+// Сопоставление действий правил с действиями Dionis-NX.
+var dionisActions = map[string]string{
+	"alert":      "alert",
+	"log":        "alert",
+	"pass":       "pass",
+	"drop":       "drop",
+	"sdrop":      "drop",
+	"reject":     "reject",
+	"rejectsrc":  "reject",
+	"rejectdst":  "reject",
+	"rejectboth": "reject",
+}
+
+// Sticky-буферы Suricata и соответствующие им модификаторы content Dionis-NX.
+// Модификатор дописывается после каждого content буфера, для pcre ставится флаг буфера.
+var dionisBufferModifiers = map[string]string{
+	"http.uri":          "http_uri",
+	"http.uri.raw":      "http_raw_uri",
+	"http.method":       "http_method",
+	"http.header":       "http_header",
+	"http.header.raw":   "http_raw_header",
+	"http.cookie":       "http_cookie",
+	"http.request_body": "http_client_body",
+	"http.stat_code":    "http_stat_code",
+	"http.stat_msg":     "http_stat_msg",
+}
+
+// Опции, которые Dionis-NX выполняет. Правила с другими опциями не выгружаются
+// и перечисляются в отчёте.
+var dionisSupported = keywordSet(`
+msg sid rev gid classtype reference priority metadata
+content nocase depth offset distance within fast_pattern isdataat pcre
+byte_test byte_jump byte_extract dsize flow flowbits threshold detection_filter
+ttl tos id ipopts fragbits flags seq ack window itype icode icmp_id icmp_seq ip_proto sameip
+pkt_data file_data http_uri http_raw_uri http_method http_header http_raw_header http_cookie
+http_client_body http_stat_code http_stat_msg
+`)
+
+// Опции, которые выносятся в отдельные поля записи.
+var dionisFields = keywordSet(`msg sid rev classtype`)
+
+// Обратное сопоставление действий при импорте. log, sdrop и reject* при экспорте
+// становятся alert, drop и reject; исходное действие правила сохраняется в поле
+// rule_action и при импорте восстанавливается из него.
+var dionisImportActions = map[string]string{
+	"alert":  "alert",
+	"pass":   "pass",
+	"drop":   "drop",
+	"reject": "reject",
+}
+
+// dionisRecord собирает запись Dionis-NX и проверяет её обратным разбором:
+// запись, собранная из разобранного правила, должна совпасть с исходной.
+func dionisRecord(sig Signature) (string, []string) {
+	record, problems := buildDionisRecord(sig)
+	if len(problems) > 0 {
+		return "", problems
+	}
+	rule, err := parseDionisRecord(record)
+	if err != nil {
+		return "", []string{fmt.Sprintf("запись не разбирается: %v", err)}
+	}
+	check, err := signatureFromRule(rule, sig.Filename, sig.Source)
+	if err != nil {
+		return "", []string{fmt.Sprintf("запись не разбирается: %v", err)}
+	}
+	if again, _ := buildDionisRecord(check); again != record {
+		return "", []string{"запись искажается при обратном разборе: " + record}
+	}
+	return record, nil
+}
+
+// buildDionisRecord собирает запись Dionis-NX. Поля записи идут в фиксированном порядке
+// в виде имя:значение; и пропускаются, если значение пустое. Остальные опции правила
+// после перевода имён записываются в поле options в синтаксисе правил.
+// Возвращает список опций, которые Dionis-NX не поддерживает.
+func buildDionisRecord(sig Signature) (string, []string) {
+	if len(sig.Options) == 0 {
+		return "", []string{"опции правила не сохранены, требуется повторная загрузка источника"}
+	}
+	if sig.Dialect == DialectSnort3 {
+		return "", []string{"правило в синтаксисе Snort 3"}
+	}
+
+	var problems []string
+	action, ok := dionisActions[sig.Type]
+	if !ok {
+		problems = append(problems, "действие "+sig.Type)
+	}
+
+	rule := sig.rule()
+	var options []string
+	buffer := "" // модификатор текущего sticky-буфера
+	for _, opt := range rule.Options {
+		name, value := opt.Name, opt.Value
+		if modifier, ok := dionisBufferModifiers[name]; ok {
+			buffer = modifier
+			continue
+		}
+		switch {
+		case dionisFields[name]:
+			continue
+		case name == "file.data":
+			name = "file_data"
+		case name == "pcre" && buffer != "":
+			value = addPcreFlag(value, buffer)
+		}
+		if name == "file_data" || name == "pkt_data" {
+			buffer = ""
+		}
+		if !dionisSupported[name] {
+			problems = append(problems, opt.Name)
+			continue
+		}
+
+		text := name
+		if value != "" {
+			text += ":" + value
+		}
+		options = append(options, text+";")
+		if name == "content" && buffer != "" {
+			options = append(options, buffer+";")
+		}
+	}
+	if len(problems) > 0 {
+		return "", problems
+	}
+
+	msg, _ := rule.Option("msg")
+	rev, _ := rule.Option("rev")
+	classtype, _ := rule.Option("classtype")
+	ruleAction := "" // исходное действие, если Dionis-NX его не различает
+	if sig.Type != action {
+		ruleAction = sig.Type
+	}
+	fields := [][2]string{
+		{"action", action},
+		{"rule_action", ruleAction},
+		{"proto", sig.Proto},
+		{"src_ip", sig.SrcIP},
+		{"src_port", sig.SrcPort},
+		{"direction", rule.Direction},
+		{"dst_ip", sig.DstIP},
+		{"dst_port", sig.DstPort},
+		{"sid", sig.SID},
+		{"rev", rev},
+		{"msg", unquote(msg)},
+		{"classtype", classtype},
+		{"source", sig.Source},
+		{"filename", sig.Filename},
+		{"options", strings.Join(options, " ")},
+	}
+
+	var b strings.Builder
+	for _, f := range fields {
+		if f[1] == "" {
+			continue
+		}
+		b.WriteString(f[0] + ":" + dionisEscape(f[1]) + ";")
+	}
+	return b.String(), nil
+}
+
+// addPcreFlag добавляет к pcre флаг HTTP-буфера в стиле Snort 2 (U, H, P...).
+func addPcreFlag(value, buffer string) string {
+	for flag, name := range pcreBufferFlags {
+		if name == buffer && strings.HasSuffix(value, `"`) {
+			return value[:len(value)-1] + string(flag) + `"`
+		}
+	}
+	return value
+}
+
+// dionisEscape экранирует значение поля: \ -> \\, ; -> \;, перевод строки -> \n.
+func dionisEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, "\n", `\n`, "\r", `\r`).Replace(value)
+}
+
+// dionisWriter записывает записи Dionis-NX потоком в файлы не более чем по maxRules записей.
+// Если записи помещаются в один файл, он называется outputFile, иначе к имени
+// добавляется номер части: export_dionis_001.txt. Число частей и записей в них, которые
+// указываются в заголовке, известны только в конце, поэтому записи частей сначала
+// пишутся во временные файлы, а в close собираются с заголовком в итоговые файлы.
+type dionisWriter struct {
+	outputFile string
+	maxRules   int
+	header     []string // строки комментария после строки схемы
+	parts      []*os.File
+	counts     []int
+	current    *bufio.Writer
+}
+
+func newDionisWriter(outputFile string, maxRules int, header []string) *dionisWriter {
+	if maxRules <= 0 {
+		maxRules = DionisDefaultMaxRules
+	}
+	return &dionisWriter{outputFile: outputFile, maxRules: maxRules, header: header}
+}
+
+// write добавляет запись, начиная новую часть при достижении лимита.
+func (w *dionisWriter) write(record string) error {
+	if len(w.parts) == 0 || w.counts[len(w.counts)-1] == w.maxRules {
+		if w.current != nil {
+			if err := w.current.Flush(); err != nil {
+				return fmt.Errorf("Ошибка записи во временный файл: %v", err)
+			}
+		}
+		part, err := os.CreateTemp(filepath.Dir(w.outputFile), "."+filepath.Base(w.outputFile)+".part-*")
+		if err != nil {
+			return fmt.Errorf("Ошибка создания временного файла: %v", err)
+		}
+		w.parts = append(w.parts, part)
+		w.counts = append(w.counts, 0)
+		w.current = bufio.NewWriter(part)
+	}
+	w.counts[len(w.counts)-1]++
+	if _, err := w.current.WriteString(record + "\n"); err != nil {
+		return fmt.Errorf("Ошибка записи во временный файл: %v", err)
+	}
+	return nil
+}
+
+// close записывает итоговые файлы с заголовками и удаляет части прошлой выгрузки,
+// которых нет в новой. Возвращает имена записанных файлов.
+func (w *dionisWriter) close() ([]string, error) {
+	defer w.abort()
+	if w.current != nil {
+		if err := w.current.Flush(); err != nil {
+			return nil, fmt.Errorf("Ошибка записи во временный файл: %v", err)
+		}
+	}
+	parts := len(w.parts)
+	total := parts // пустая выгрузка - один файл с заголовком
+	if total == 0 {
+		total = 1
+	}
+	ext := filepath.Ext(w.outputFile)
+	base := strings.TrimSuffix(w.outputFile, ext)
+
+	var files []string
+	for part := 0; part < total; part++ {
+		name := w.outputFile
+		if parts > 1 {
+			name = fmt.Sprintf("%s_%03d%s", base, part+1, ext)
+		}
+		out, err := createAtomic(name)
+		if err != nil {
+			return nil, err
+		}
+		defer out.abort()
+
+		count := 0
+		if part < parts {
+			count = w.counts[part]
+		}
+		fmt.Fprintf(out, "# dionis-nx schema:%d; part:%d/%d; rules:%d\n", DionisSchemaVersion, part+1, total, count)
+		for _, line := range w.header {
+			fmt.Fprintln(out, line)
+		}
+		if part < parts {
+			if _, err := w.parts[part].Seek(0, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("Ошибка чтения временного файла: %v", err)
+			}
+			if _, err := io.Copy(out, w.parts[part]); err != nil {
+				return nil, fmt.Errorf("Ошибка записи в файл %s: %v", name, err)
+			}
+		}
+		if err := out.commit(); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+
+	return files, removeStaleDionisParts(w.outputFile, files)
+}
+
+// removeStaleDionisParts удаляет файлы прошлой выгрузки outputFile, которых нет среди
+// files: лишние части или единственный файл, если выгрузка теперь делится на части.
+func removeStaleDionisParts(outputFile string, files []string) error {
+	ext := filepath.Ext(outputFile)
+	stale, _ := filepath.Glob(strings.TrimSuffix(outputFile, ext) + "_[0-9][0-9][0-9]" + ext)
+	stale = append(stale, outputFile)
+	written := map[string]bool{}
+	for _, name := range files {
+		written[name] = true
+	}
+	for _, name := range stale {
+		if !written[name] {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Ошибка удаления файла %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// abort удаляет временные файлы частей.
+func (w *dionisWriter) abort() {
+	for _, part := range w.parts {
+		part.Close()
+		os.Remove(part.Name())
+	}
+	w.parts, w.counts, w.current = nil, nil, nil
+}
+
+// parseDionisRules разбирает файл записей Dionis-NX. Строки, начинающиеся с #, пропускаются.
+func parseDionisRules(content string) ([]*Rule, []error) {
+	var rules []*Rule
+	var errs []error
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseDionisRecord(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("строка %d: %v", i+1, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+// parseDionisRecord превращает запись Dionis-NX в правило Suricata. Понимает записи
+// схем 1 и 2 и записи старого экспорта (type:...;proto:...;src_ip:...;dst_ip:...;sid:...;msg:...;),
+// в которых нет портов и опций. Поля source и filename не переносятся в правило:
+// при загрузке они берутся из источника и имени файла.
+func parseDionisRecord(line string) (*Rule, error) {
+	fields, err := splitDionisFields(line)
+	if err != nil {
+		return nil, err
+	}
+
+	action := dionisImportActions[fields["action"]]
+	if _, legacy := fields["type"]; legacy && fields["action"] == "" {
+		// В старом экспорте поле type содержит действие правила или тип сигнатуры.
+		action = "alert"
+		if ruleActions[fields["type"]] {
+			action = fields["type"]
+		}
+		if fields["msg"] == "N/A" {
+			fields["msg"] = ""
+		}
+	}
+	if action == "" {
+		return nil, fmt.Errorf("Неизвестное действие записи: %s", fields["action"])
+	}
+	if original := fields["rule_action"]; original != "" {
+		if dionisActions[original] != action {
+			return nil, fmt.Errorf("Действие правила %s не соответствует действию записи %s", original, action)
+		}
+		action = original
+	}
+	if fields["sid"] == "" {
+		return nil, fmt.Errorf("В записи отсутствует sid")
+	}
+
+	header := []string{action, fields["proto"], fields["src_ip"], fields["src_port"], fields["direction"], fields["dst_ip"], fields["dst_port"]}
+	defaults := []string{"", "ip", "any", "any", "->", "any", "any"}
+	for i := range header {
+		if header[i] == "" {
+			header[i] = defaults[i]
+		}
+	}
+
+	var options []string
+	if msg := fields["msg"]; msg != "" {
+		options = append(options, "msg:"+quote(msg)+";")
+	}
+	if fields["options"] != "" {
+		options = append(options, fields["options"])
+	}
+	if fields["classtype"] != "" {
+		options = append(options, "classtype:"+fields["classtype"]+";")
+	}
+	options = append(options, "sid:"+fields["sid"]+";")
+	if fields["rev"] != "" {
+		options = append(options, "rev:"+fields["rev"]+";")
+	}
+
+	rule, err := parseRule(strings.Join(header, " ") + " (" + strings.Join(options, " ") + ")")
+	if err != nil {
+		return nil, err
+	}
+	rule.Dialect = DialectSuricata
+	return rule, nil
+}
+
+// splitDionisFields разбирает поля записи имя:значение; и снимает экранирование dionisEscape.
+func splitDionisFields(line string) (map[string]string, error) {
+	fields := map[string]string{}
+	var current strings.Builder
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			switch r {
+			case 'n':
+				current.WriteRune('\n')
+			case 'r':
+				current.WriteRune('\r')
+			default:
+				current.WriteRune(r)
+			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			name, value, ok := strings.Cut(current.String(), ":")
+			if !ok {
+				return nil, fmt.Errorf("Поле без имени: %s", current.String())
+			}
+			fields[strings.TrimSpace(name)] = value
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if escaped || strings.TrimSpace(current.String()) != "" {
+		return nil, fmt.Errorf("Запись не завершена символом ;")
+	}
+	return fields, nil
+}
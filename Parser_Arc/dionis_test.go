@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDionisRecordKeepsRuleAction(t *testing.T) {
+	tests := []struct {
+		action string
+		want   string // начало записи
+	}{
+		{"alert", "action:alert;proto:tcp;"},
+		{"log", "action:alert;rule_action:log;proto:tcp;"},
+		{"drop", "action:drop;proto:tcp;"},
+		{"sdrop", "action:drop;rule_action:sdrop;proto:tcp;"},
+		{"rejectsrc", "action:reject;rule_action:rejectsrc;proto:tcp;"},
+		{"pass", "action:pass;proto:tcp;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			rule, err := parseRule(tt.action + ` tcp any any -> any 80 (msg:"x"; content:"a"; sid:100; rev:1;)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyDialect(rule, DialectSuricata)
+			sig, err := signatureFromRule(rule, "test.rules", "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, problems := dionisRecord(sig)
+			if len(problems) > 0 {
+				t.Fatalf("запись не собрана: %v", problems)
+			}
+			if !strings.HasPrefix(record, tt.want) {
+				t.Fatalf("запись %s, ожидалось начало %s", record, tt.want)
+			}
+
+			imported, err := parseDionisRecord(record)
+			if err != nil {
+				t.Fatalf("parseDionisRecord: %v", err)
+			}
+			if imported.Action != tt.action {
+				t.Errorf("действие после импорта %s, ожидалось %s", imported.Action, tt.action)
+			}
+		})
+	}
+}
+
+func TestParseDionisRecordRejectsMismatchedRuleAction(t *testing.T) {
+	for _, record := range []string{
+		"action:alert;rule_action:sdrop;proto:tcp;sid:1;",
+		"action:drop;rule_action:unknown;proto:tcp;sid:1;",
+	} {
+		if _, err := parseDionisRecord(record); err == nil {
+			t.Errorf("ожидалась ошибка для записи %s", record)
+		}
+	}
+}
+
+func TestDionisRecordSkipsEmptyFields(t *testing.T) {
+	rule, err := parseRule(`alert tcp any any -> any any (content:"a"; sid:101;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Сигнатура без msg и filename (NULL в БД).
+	sig, err := signatureFromRule(rule, "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, problems := dionisRecord(sig)
+	if len(problems) > 0 {
+		t.Fatalf("запись не собрана: %v", problems)
+	}
+	want := `action:alert;proto:tcp;src_ip:any;src_port:any;direction:->;dst_ip:any;dst_port:any;sid:101;source:test;options:content:"a"\;;`
+	if record != want {
+		t.Errorf("запись\n%s\nожидалось\n%s", record, want)
+	}
+}
+
+func TestDionisRecordEscaping(t *testing.T) {
+	rule, err := parseRule(`alert tcp any any -> any any (msg:"a\;b \\ c"; content:"x|3b|y\;z"; pcre:"/\d+\;/"; sid:102; rev:2;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(rule, "test.rules", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, problems := dionisRecord(sig)
+	if len(problems) > 0 {
+		t.Fatalf("запись не собрана: %v", problems)
+	}
+	for _, want := range []string{
+		`;msg:a\;b \\ c;`,
+		`;options:content:"x|3b|y\\\;z"\; pcre:"/\\d+\\\;/"\;;`,
+	} {
+		if !strings.Contains(record, want) {
+			t.Errorf("запись\n%s\nне содержит\n%s", record, want)
+		}
+	}
+
+	imported, err := parseDionisRecord(record)
+	if err != nil {
+		t.Fatalf("parseDionisRecord: %v", err)
+	}
+	if !equalRules(imported, rule) {
+		t.Errorf("правило после импорта\n%s\nожидалось\n%s", imported.Raw, rule.Raw)
+	}
+	if _, err := parseDionisRecord(`action:alert;sid:1;msg:a\`); err == nil {
+		t.Error("ожидалась ошибка для записи с незавершённым экранированием")
+	}
+}
+
+func TestDionisWriterSplitsParts(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "export_dionis.txt")
+	// Файлы прошлых выгрузок: единственный файл и лишняя часть.
+	writeTestFile(t, output, "old\n")
+	writeTestFile(t, filepath.Join(dir, "export_dionis_004.txt"), "old\n")
+
+	w := newDionisWriter(output, 2, []string{"# profile:dionis"})
+	for i := 1; i <= 5; i++ {
+		if err := w.write(fmt.Sprintf("action:alert;sid:%d;", i)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	files, err := w.close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	want := map[string]string{
+		"export_dionis_001.txt": "# dionis-nx schema:2; part:1/3; rules:2\n# profile:dionis\naction:alert;sid:1;\naction:alert;sid:2;\n",
+		"export_dionis_002.txt": "# dionis-nx schema:2; part:2/3; rules:2\n# profile:dionis\naction:alert;sid:3;\naction:alert;sid:4;\n",
+		"export_dionis_003.txt": "# dionis-nx schema:2; part:3/3; rules:1\n# profile:dionis\naction:alert;sid:5;\n",
+	}
+	checkDionisFiles(t, dir, files, want)
+
+	// Выгрузка снова помещается в один файл: части удаляются.
+	w = newDionisWriter(output, 2, nil)
+	if err := w.write("action:alert;sid:1;"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if files, err = w.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	checkDionisFiles(t, dir, files, map[string]string{
+		"export_dionis.txt": "# dionis-nx schema:2; part:1/1; rules:1\naction:alert;sid:1;\n",
+	})
+}
+
+// checkDionisFiles проверяет, что в каталоге dir остались только файлы want и что
+// close вернул именно их.
+func checkDionisFiles(t *testing.T, dir string, files []string, want map[string]string) {
+	t.Helper()
+	if len(files) != len(want) {
+		t.Errorf("записаны файлы %v, ожидалось %d", files, len(want))
+	}
+	for _, name := range files {
+		if _, ok := want[filepath.Base(name)]; !ok {
+			t.Errorf("записан лишний файл %s", name)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("в каталоге файлы %v, ожидалось %d", names, len(want))
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("файл %s: %v", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("файл %s:\n%s\nожидалось:\n%s", name, data, content)
+		}
+	}
+}
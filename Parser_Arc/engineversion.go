@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Минимальные версии Suricata для ключевых слов и протоколов заголовка.
+// Слова, которых нет в таблице, поддерживаются всеми версиями начиная с 5.0.
+var suricataKeywordVersions = map[string]string{
+	// 6.0
+	"http2.frametype": "6.0", "http2.errorcode": "6.0", "http2.priority": "6.0", "http2.window": "6.0",
+	"http2.size_update": "6.0", "http2.settings": "6.0", "http2.header_name": "6.0", "http2.header": "6.0",
+	"mqtt.type": "6.0", "mqtt.flags": "6.0", "mqtt.qos": "6.0", "mqtt.reason_code": "6.0",
+	"mqtt.connack.session_present": "6.0", "mqtt.connect.clientid": "6.0", "mqtt.connect.flags": "6.0",
+	"mqtt.connect.password": "6.0", "mqtt.connect.username": "6.0", "mqtt.connect.willmessage": "6.0",
+	"mqtt.connect.willtopic": "6.0", "mqtt.protocol_version": "6.0", "mqtt.publish.message": "6.0",
+	"mqtt.publish.topic": "6.0", "mqtt.subscribe.topic": "6.0", "mqtt.unsubscribe.topic": "6.0",
+	"rfb.name": "6.0", "rfb.sectype": "6.0", "rfb.secresult": "6.0",
+	"snmp.version": "6.0", "snmp.community": "6.0", "snmp.pdu_type": "6.0", "snmp.usm": "6.0",
+	"ike.init_spi": "6.0", "ike.resp_spi": "6.0", "ike.chosen_sa_attribute": "6.0", "ike.exchtype": "6.0",
+	"ike.vendor": "6.0", "ike.key_exchange_payload": "6.0", "ike.key_exchange_payload_length": "6.0",
+	"ike.nonce_payload": "6.0", "ike.nonce_payload_length": "6.0",
+	"quic.version": "6.0", "quic.cyu.hash": "6.0", "quic.cyu.string": "6.0",
+	"pcrexform": "6.0", "xor": "6.0", "datarep": "6.0", "to_md5": "6.0", "to_sha1": "6.0", "to_sha256": "6.0",
+	"ipv4.hdr": "6.0", "ipv6.hdr": "6.0", "tcp.hdr": "6.0", "udp.hdr": "6.0", "icmpv4.hdr": "6.0",
+	"icmpv6.hdr": "6.0", "icmpv6.mtu": "6.0", "tcp.mss": "6.0", "flow.age": "6.0",
+	"file.name": "6.0", "file.magic": "6.0", "http.location": "6.0", "http.server": "6.0",
+	"dns.opcode": "6.0", "nfs.version": "6.0",
+
+	// 7.0
+	"requires": "7.0.3", "frame": "7.0", "tls.cert_chain_len": "7.0",
+	"tls.random": "7.0", "tls.random_time": "7.0", "tls.random_bytes": "7.0",
+	"to_lowercase": "7.0", "to_uppercase": "7.0", "strip_pseudo_headers": "7.0",
+	"header_lowercase": "7.0", "url_decode": "7.0", "dotprefix": "7.0",
+	"http.request_header": "7.0", "http.response_header": "7.0",
+	"quic.sni": "7.0", "quic.ua": "7.0", "smb.ntlmssp_user": "7.0", "smb.ntlmssp_domain": "7.0",
+	"smb.version": "7.0", "nfq_set_mark": "7.0", "ja4.hash": "7.0",
+
+	// 8.0
+	"entropy": "8.0", "from_base64": "8.0", "flow.pkts": "8.0", "flow.bytes": "8.0", "tcp.flags": "8.0",
+	"dns.answer.name": "8.0", "dns.query.name": "8.0", "krb5.ticket_encryption": "8.0",
+}
+
+var suricataProtoVersions = map[string]string{
+	"http2": "6.0", "mqtt": "6.0", "rfb": "6.0", "snmp": "6.0", "ike": "6.0", "quic": "6.0",
+	"http1": "7.0", "pgsql": "7.0", "bittorrent-dht": "7.0", "telnet": "7.0",
+	"websocket": "8.0", "ldap": "8.0", "doh2": "8.0",
+}
+
+var (
+	versionRe         = regexp.MustCompile(`^\d+(\.\d+)*$`)
+	requiresVersionRe = regexp.MustCompile(`version\s*>=\s*(\d+(?:\.\d+)*)`)
+)
+
+// ruleMinVersion возвращает минимальную версию Suricata, которая загрузит правило,
+// в виде major.minor.patch или пустую строку, если правило не требует версии новее 5.0.
+// Учитываются протокол заголовка, ключевые слова и условие version >= опции requires.
+func ruleMinVersion(rule *Rule) string {
+	if rule.Dialect != DialectSuricata {
+		return ""
+	}
+	min := suricataProtoVersions[strings.ToLower(rule.Proto)]
+	raise := func(version string) {
+		if version != "" && compareVersions(version, min) > 0 {
+			min = version
+		}
+	}
+	for _, opt := range rule.Options {
+		raise(suricataKeywordVersions[opt.Name])
+		if opt.Name == "requires" {
+			for _, m := range requiresVersionRe.FindAllStringSubmatch(opt.Value, -1) {
+				raise(m[1])
+			}
+		}
+	}
+	if min == "" {
+		return ""
+	}
+	return normalizeVersion(min)
+}
+
+// compareVersions сравнивает версии вида 7.0.3 по числовым компонентам;
+// недостающие компоненты считаются нулями, пустая версия меньше любой другой.
+func compareVersions(a, b string) int {
+	if a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// validVersion проверяет формат версии движка, заданной пользователем.
+func validVersion(version string) bool {
+	return versionRe.MatchString(version)
+}
+
+// normalizeVersion дополняет версию нулями до трёх компонент, чтобы массивы
+// компонент одинаковой длины можно было сравнивать в SQL.
+func normalizeVersion(version string) string {
+	parts := strings.Split(version, ".")
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	return strings.Join(parts, ".")
+}
+
+// reportIncompatible записывает в лог включённые правила, которые не загрузит
+// указанная версия движка, и возвращает их количество.
+func reportIncompatible(db *sql.DB, version string) (int, error) {
+	rows, err := db.Query(`
+        SELECT sid, COALESCE(msg, ''), min_engine_version
+        FROM signatures
+        WHERE deleted_at IS NULL AND COALESCE(enabled_override, enabled, TRUE)
+          AND min_engine_version IS NOT NULL
+          AND string_to_array(min_engine_version, '.')::INT[] > string_to_array($1, '.')::INT[]
+        ORDER BY sid
+    `, normalizeVersion(version))
+	if err != nil {
+		return 0, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var sid, msg, min string
+		if err := rows.Scan(&sid, &msg, &min); err != nil {
+			return 0, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		log.Printf("SID %s (%s) требует Suricata %s и не выгружается для версии %s", sid, msg, min, version)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return count, nil
+}
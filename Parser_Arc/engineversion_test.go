@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleMinVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dialect string
+		want    string
+	}{
+		{"old keywords", `alert http any any -> any any (msg:"x"; http.uri; content:"a"; sid:1;)`, DialectSuricata, ""},
+		{"protocol", `alert http2 any any -> any any (msg:"x"; sid:1;)`, DialectSuricata, "6.0.0"},
+		{"keyword", `alert tls any any -> any any (msg:"x"; tls.random; content:"a"; sid:1;)`, DialectSuricata, "7.0.0"},
+		{"highest of protocol and keywords", `alert http2 any any -> any any (msg:"x"; to_lowercase; entropy:value 5; sid:1;)`, DialectSuricata, "8.0.0"},
+		{"requires keyword", `alert tcp any any -> any any (msg:"x"; requires:feature foo; sid:1;)`, DialectSuricata, "7.0.3"},
+		{"requires version", `alert tcp any any -> any any (msg:"x"; requires:version >= 7.0.10; sid:1;)`, DialectSuricata, "7.0.10"},
+		{"lower requires version", `alert websocket any any -> any any (msg:"x"; requires:version >= 7; sid:1;)`, DialectSuricata, "8.0.0"},
+		{"snort rule", `alert tcp any any -> any any (msg:"x"; xor:"key"; sid:1;)`, DialectSnort2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.Dialect = tt.dialect
+			if got := ruleMinVersion(rule); got != tt.want {
+				t.Errorf("ruleMinVersion = %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"7.0", "7.0.0", 0},
+		{"7.0.3", "7.0", 1},
+		{"7.0.10", "7.0.9", 1},
+		{"6.0", "7", -1},
+		{"10.0", "9.1", 1},
+		{"", "5.0", -1},
+		{"5.0", "", 1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, ожидалось %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// Фильтр engine_version сравнивает в SQL массивы компонент string_to_array(...)::INT[];
+// после normalizeVersion у них одинаковая длина, и сравнение массивов совпадает с compareVersions.
+func TestNormalizeVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"7", "7.0.0"},
+		{"7.0", "7.0.0"},
+		{"7.0.3", "7.0.3"},
+		{"7.0.3.1", "7.0.3.1"},
+	}
+	for _, tt := range tests {
+		if got := normalizeVersion(tt.version); got != tt.want {
+			t.Errorf("normalizeVersion(%q) = %q, ожидалось %q", tt.version, got, tt.want)
+		}
+		if compareVersions(tt.version, tt.want) != 0 {
+			t.Errorf("версия %s после normalizeVersion сравнивается иначе", tt.version)
+		}
+	}
+
+	// Правило с min_engine_version выгружается, если min <= engine_version.
+	for _, tt := range []struct {
+		min, engine string
+		exported    bool
+	}{
+		{"7.0.3", "7", false},
+		{"7.0.3", "7.0.3", true},
+		{"7.0.0", "7", true},
+		{"6.0.0", "7.0.10", true},
+		{"8.0.0", "7.0.10", false},
+	} {
+		engine := normalizeVersion(tt.engine)
+		if len(strings.Split(engine, ".")) != len(strings.Split(tt.min, ".")) {
+			t.Errorf("версии %s и %s разной длины", tt.min, engine)
+		}
+		if got := compareVersions(tt.min, engine) <= 0; got != tt.exported {
+			t.Errorf("min %s, engine_version %s: выгружается %v, ожидалось %v", tt.min, tt.engine, got, tt.exported)
+		}
+	}
+
+	for version, valid := range map[string]bool{"7": true, "7.0.3": true, "": false, "7.x": false, "v7": false, "7.": false} {
+		if validVersion(version) != valid {
+			t.Errorf("validVersion(%q) = %v", version, !valid)
+		}
+	}
+}
+
+func TestEngineVersionFilter(t *testing.T) {
+	f := ExportFilter{EngineVersion: "7"}
+	if err := f.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	where, args := f.where()
+	if !strings.Contains(where, "string_to_array(signatures.min_engine_version, '.')::INT[] <= string_to_array($1, '.')::INT[]") {
+		t.Errorf("условие %s", where)
+	}
+	if len(args) != 1 || args[0] != "7.0.0" {
+		t.Errorf("аргументы %v, ожидалось [7.0.0]", args)
+	}
+	if err := (ExportFilter{EngineVersion: "7.x"}).validate(); err == nil {
+		t.Error("ожидалась ошибка для версии 7.x")
+	}
+}
@@ -1,19 +1,38 @@
+//go:build export
+
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	DB      DBConfig        `mapstructure:"db"`
-	Sources []SourceConfig  `mapstructure:"sources"`
+	DB        DBConfig         `mapstructure:"db"`
+	Sources   []SourceConfig   `mapstructure:"sources"`
+	Dionis    DionisConfig     `mapstructure:"dionis"`
+	Export    ExportConfig     `mapstructure:"export"`
+	Exports   []ExportProfile  `mapstructure:"exports"`
+	Formats   []TemplateFormat `mapstructure:"formats"`
+	Snapshots SnapshotConfig   `mapstructure:"snapshots"`
+	Signing   SigningConfig    `mapstructure:"signing"`
+	Serve     ServeConfig      `mapstructure:"serve"`
 }
 
 type DBConfig struct {
@@ -31,14 +50,104 @@ type SourceConfig struct {
 	Path string `mapstructure:"path"`
 }
 
-type Signature struct {
-	Type     string
-	Proto    string
-	SrcIP    string
-	DstIP    string
-	SID      string
-	Msg      string
-	Filename string
+type DionisConfig struct {
+	MaxRulesPerFile int `mapstructure:"max_rules_per_file"`
+}
+
+// SnapshotConfig - хранилище снимков выгрузок.
+type SnapshotConfig struct {
+	Dir string `mapstructure:"dir"`
+}
+
+func (c SnapshotConfig) dir() string {
+	if c.Dir == "" {
+		return "snapshots"
+	}
+	return c.Dir
+}
+
+// SigningConfig - закрытый ключ Ed25519 (PEM), которым подписываются манифесты выгрузок.
+// Без ключа манифесты записываются без подписи.
+type SigningConfig struct {
+	Key string `mapstructure:"key"`
+}
+
+// signingKey загружает ключ подписи из конфигурации или возвращает nil, если он не задан.
+func signingKey() (ed25519.PrivateKey, error) {
+	if config.Signing.Key == "" {
+		return nil, nil
+	}
+	return loadPrivateKey(config.Signing.Key)
+}
+
+// ExportConfig - параметры экспорта по умолчанию. Аргументы командной строки
+// заменяют соответствующие поля фильтра.
+type ExportConfig struct {
+	Filter ExportFilter `mapstructure:"filter"`
+}
+
+// ExportProfile - именованная выгрузка для группы сенсоров: формат, фильтр, путь к файлу,
+// комментарий в заголовке и значения переменных ($HOME_NET, $HTTP_PORTS...) для заголовков правил.
+type ExportProfile struct {
+	Name      string            `mapstructure:"name"`
+	Format    string            `mapstructure:"format"`
+	Output    string            `mapstructure:"output"`
+	Header    string            `mapstructure:"header"`
+	Filter    ExportFilter      `mapstructure:"filter"`
+	Variables map[string]string `mapstructure:"variables"`
+	Columns   []string          `mapstructure:"columns"`
+	Delta     bool              `mapstructure:"delta"`  // записывать дельту с прошлой выгрузки
+	Reload    []SuricataReload  `mapstructure:"reload"` // сенсоры Suricata, которым передаётся выгрузка
+
+	template *template.Template // шаблон пользовательского формата, см. check
+}
+
+// check проверяет формат и фильтр профиля и подставляет файл выгрузки по умолчанию.
+// Шаблон пользовательского формата разбирается с переменными профиля.
+func (p *ExportProfile) check() error {
+	file, ok := exportFiles[ExportFormat(p.Format)]
+	if !ok {
+		f, custom := templateFormat(p.Format)
+		if !custom {
+			return fmt.Errorf("Неподдерживаемый формат экспорта: %s", p.Format)
+		}
+		t, err := parseTemplateFormat(f, p.Variables)
+		if err != nil {
+			return err
+		}
+		p.template, file = t, f.Output
+		if file == "" {
+			file = "export_" + f.Name + ".txt"
+		}
+	}
+	if p.Output == "" {
+		p.Output = file
+	}
+	if len(p.Reload) > 0 && ExportFormat(p.Format) != Suricata {
+		return fmt.Errorf("перезагрузка правил на сенсорах поддерживается только для формата suricata")
+	}
+	for _, t := range p.Reload {
+		if err := t.check(); err != nil {
+			return err
+		}
+	}
+	return p.Filter.validate()
+}
+
+// key - имя профиля для метки выгрузки; для выгрузки без профиля - файл выгрузки.
+func (p ExportProfile) key() string {
+	if p.Name == "" {
+		return p.Output
+	}
+	return p.Name
+}
+
+// title - имя профиля для лога; для выгрузки без профиля - формат.
+func (p ExportProfile) title() string {
+	if p.Name == "" {
+		return p.Format
+	}
+	return p.Name + " (" + p.Format + ")"
 }
 
 type ExportFormat string
@@ -46,17 +155,166 @@ type ExportFormat string
 const (
 	Suricata ExportFormat = "suricata"
 	Dionis   ExportFormat = "dionis"
+	Snort3   ExportFormat = "snort3"
+	NDJSON   ExportFormat = "ndjson"
+	CSV      ExportFormat = "csv"
+	SQLite   ExportFormat = "sqlite"
 )
 
+// Файлы выгрузки по форматам.
+var exportFiles = map[ExportFormat]string{
+	Suricata: "export_suricata.txt",
+	Dionis:   "export_dionis.txt",
+	Snort3:   "export_snort3.txt",
+	NDJSON:   "export_signatures.ndjson",
+	CSV:      "export_signatures.csv",
+	SQLite:   "export_signatures.sqlite",
+}
+
 var config Config
 
+// templateFormat ищет пользовательский формат в разделе formats.
+func templateFormat(name string) (TemplateFormat, bool) {
+	for _, f := range config.Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return TemplateFormat{}, false
+}
+
+// checkTemplateFormats проверяет при запуске шаблоны всех пользовательских форматов.
+func checkTemplateFormats() error {
+	seen := map[string]bool{}
+	for _, f := range config.Formats {
+		if _, builtin := exportFiles[ExportFormat(f.Name)]; builtin || f.Name == "" || seen[f.Name] {
+			return fmt.Errorf("Недопустимое или повторяющееся имя пользовательского формата: %q", f.Name)
+		}
+		seen[f.Name] = true
+		if _, err := parseTemplateFormat(f, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
 	initLog()
 
+	// Команды. apply-delta, verify и keygen не обращаются к БД и выполняются в том числе на сенсоре.
+	commands := map[string]func([]string) error{
+		"apply-delta": runApplyDelta,
+		"verify":      runVerify,
+		"keygen":      runKeygen,
+		"snapshots":   runSnapshots,
+		"serve":       runServe,
+		"sensors":     runSensors,
+	}
+	if len(os.Args) > 1 && commands[os.Args[1]] != nil {
+		if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+			log.Printf("Ошибка: %v", err)
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	source := flag.String("source", "", "Экспортировать только правила указанных источников через запятую")
+	sid := flag.String("sid", "", "Диапазоны sid через запятую: 2000000-2099999, 1:9000001-9000999 (с gid), 2100498")
+	classtype := flag.String("classtype", "", "Экспортировать только правила указанных classtype через запятую")
+	proto := flag.String("proto", "", "Экспортировать только правила указанных протоколов через запятую (например tcp,http)")
+	filename := flag.String("filename", "", "Шаблоны имён файлов правил через запятую (например emerging-malware*,emerging-trojan*)")
+	tag := flag.String("tag", "", "Экспортировать только правила с указанными metadata tag или метками аналитиков через запятую")
+	metadata := flag.String("metadata", "", "Условия metadata ключ=значение через запятую (например deployment=Perimeter)")
+	createdSince := flag.String("created-since", "", "Только правила, созданные поставщиком начиная с даты YYYY-MM-DD")
+	updatedSince := flag.String("updated-since", "", "Только правила, обновлённые поставщиком начиная с даты YYYY-MM-DD")
+	severity := flag.String("severity", "", "Экспортировать только указанные signature_severity через запятую (например Major,Critical)")
+	policy := flag.String("policy", "", "Экспортировать только правила указанных политик Snort через запятую (например security-ips)")
+	dedup := flag.Bool("dedup", false, "Не выгружать правила, совпадающие по отпечатку с правилами других источников")
+	engineVersion := flag.String("engine-version", "", "Версия Suricata, для которой выполняется экспорт (например 6.0.15): правила для более новых версий не выгружаются")
+	formats := flag.String("format", "suricata,dionis", "Форматы экспорта через запятую: suricata, dionis, snort3, ndjson, csv, sqlite или форматы из раздела formats")
+	columns := flag.String("columns", strings.Join(defaultCatalogColumns, ","), "Столбцы выгрузки CSV через запятую")
+	withDelta := flag.Bool("delta", false, "Записать также дельту с прошлой выгрузки (suricata, snort3)")
+	profile := flag.String("profile", "", "Имя профиля из раздела exports или all для всех профилей")
+	flag.Parse()
+
 	log.Println("=== Старт выполнения экспорта ===")
 	if err := loadConfig(); err != nil {
 		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
+	if err := checkTemplateFormats(); err != nil {
+		log.Fatalf("Ошибка пользовательского формата: %v", err)
+	}
+
+	// Заданные аргументы командной строки заменяют поля фильтра профиля.
+	applyFlags := func(filter ExportFilter) ExportFilter {
+		override := func(field *[]string, value string) {
+			if list := splitList(value); len(list) > 0 {
+				*field = list
+			}
+		}
+		override(&filter.Sources, *source)
+		override(&filter.IDs, *sid)
+		override(&filter.Classtypes, *classtype)
+		override(&filter.Severities, *severity)
+		override(&filter.Policies, *policy)
+		override(&filter.Protocols, *proto)
+		override(&filter.Filenames, *filename)
+		override(&filter.Tags, *tag)
+		override(&filter.Metadata, *metadata)
+		if *createdSince != "" {
+			filter.CreatedSince = *createdSince
+		}
+		if *updatedSince != "" {
+			filter.UpdatedSince = *updatedSince
+		}
+		if *engineVersion != "" {
+			filter.EngineVersion = *engineVersion
+		}
+		if *dedup {
+			filter.CollapseDuplicates = true
+		}
+		return filter
+	}
+
+	// Без -profile выгружаются форматы из -format в файлы по умолчанию
+	// с фильтром из раздела export.
+	var profiles []ExportProfile
+	switch *profile {
+	case "":
+		for _, name := range splitList(*formats) {
+			profiles = append(profiles, ExportProfile{Format: name, Filter: config.Export.Filter})
+		}
+	case "all":
+		profiles = config.Exports
+	default:
+		for _, p := range config.Exports {
+			if p.Name == *profile {
+				profiles = append(profiles, p)
+			}
+		}
+		if len(profiles) == 0 {
+			log.Fatalf("Профиль экспорта %s не найден в разделе exports", *profile)
+		}
+	}
+	if len(profiles) == 0 {
+		log.Fatalf("Нет профилей экспорта")
+	}
+
+	for i := range profiles {
+		p := &profiles[i]
+		p.Filter = applyFlags(p.Filter)
+		p.Delta = p.Delta || *withDelta
+		if err := p.check(); err != nil {
+			log.Fatalf("Ошибка профиля экспорта %s: %v", p.title(), err)
+		}
+		if len(p.Columns) == 0 {
+			p.Columns = splitList(*columns)
+		}
+		if err := checkCatalogColumns(p.Columns); err != nil {
+			log.Fatalf("Ошибка профиля экспорта %s: %v", p.title(), err)
+		}
+	}
 
 	db, err := connectToDB(config.DB)
 	if err != nil {
@@ -64,21 +322,236 @@ func main() {
 	}
 	defer db.Close()
 
-	if err := exportSignatures(db, Suricata, "export_suricata.txt"); err != nil {
-		log.Printf("Ошибка экспорта в Suricata: %v", err)
-	} else {
-		log.Println("Экспорт для Suricata завершён успешно.")
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
 	}
 
-	if err := exportSignatures(db, Dionis, "export_dionis.txt"); err != nil {
-		log.Printf("Ошибка экспорта в Dionis: %v", err)
-	} else {
-		log.Println("Экспорт для Dionis завершён успешно.")
+	key, err := signingKey()
+	if err != nil {
+		log.Fatalf("Ошибка загрузки ключа подписи: %v", err)
+	}
+	if key == nil {
+		log.Println("Ключ подписи (signing.key) не задан: манифесты выгрузок записываются без подписи")
+	}
+
+	// Ошибки выгрузки и перезагрузки правил на сенсорах дают код возврата 1.
+	failed := 0
+	for _, p := range profiles {
+		if p.Filter.EngineVersion != "" {
+			count, err := reportIncompatible(db, p.Filter.EngineVersion)
+			if err != nil {
+				log.Printf("Ошибка проверки совместимости с версией %s: %v", p.Filter.EngineVersion, err)
+			} else {
+				log.Printf("Правил, несовместимых с Suricata %s: %d", p.Filter.EngineVersion, count)
+			}
+		}
+
+		if dir := filepath.Dir(p.Output); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Printf("Ошибка создания каталога %s: %v", dir, err)
+				failed++
+				continue
+			}
+		}
+
+		var result exportResult
+		switch ExportFormat(p.Format) {
+		case Suricata, Dionis, Snort3:
+			err = exportSignatures(db, p, &result)
+		default:
+			err = exportCatalog(db, p, &result)
+		}
+		if err != nil {
+			log.Printf("Ошибка экспорта %s: %v", p.title(), err)
+			failed++
+			continue
+		}
+		log.Printf("Экспорт %s завершён успешно.", p.title())
+
+		// Каждая выгрузка сохраняется неизменяемым снимком для отката. Выгрузка без снимка
+		// или манифеста считается неудачной и на сенсоры не отправляется.
+		snapshot := &Snapshot{Profile: p.key(), Format: p.Format, Output: p.Output, Rules: result.Rules, Filter: p.Filter.String()}
+		if err := createSnapshot(db, config.Snapshots.dir(), snapshot, result.Files, result.Sources); err != nil {
+			log.Printf("Ошибка создания снимка выгрузки %s: %v", p.title(), err)
+			failed++
+			continue
+		}
+		log.Printf("Снимок %d выгрузки %s: правил %d, SHA-256 %s", snapshot.ID, p.title(), snapshot.Rules, snapshot.SHA256)
+
+		manifest := &ExportManifest{Profile: p.key(), Format: p.Format, Snapshot: snapshot.ID, Generated: time.Now().UTC()}
+		if err := writeManifest(manifestPath(p.Output), manifest, result.Files, result.Artifacts, key); err != nil {
+			log.Printf("Ошибка записи манифеста выгрузки %s: %v", p.title(), err)
+			failed++
+			continue
+		}
+
+		for _, t := range p.Reload {
+			stats, err := reloadSuricata(t, p.Output, result.Rules)
+			if err != nil {
+				log.Printf("Ошибка перезагрузки правил %s на сенсоре %s: %v", p.title(), t.Name, err)
+				failed++
+				continue
+			}
+			log.Printf("Правила %s перезагружены на сенсоре %s: загружено %d, пропущено %d",
+				p.title(), t.Name, stats.Loaded, stats.Skipped)
+		}
 	}
 
+	if failed > 0 {
+		log.Fatalf("=== Экспорт завершён с ошибками: %d ===", failed)
+	}
 	log.Println("=== Завершение выполнения экспорта ===")
 }
 
+const snapshotsUsage = `Использование:
+  export snapshots list [-profile ИМЯ]   список снимков выгрузок
+  export snapshots diff A B              различия правил снимков A и B
+  export snapshots rollback N            опубликовать файлы снимка N по путям выгрузки`
+
+// runSnapshots выполняет команды хранилища снимков.
+func runSnapshots(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(snapshotsUsage)
+		os.Exit(2)
+	}
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("Ошибка загрузки конфигурации: %v", err)
+	}
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+	if err := initDB(db); err != nil {
+		return fmt.Errorf("Ошибка инициализации БД: %v", err)
+	}
+	dir := config.Snapshots.dir()
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		profile := fs.String("profile", "", "Показать только снимки профиля")
+		fs.Parse(args[1:])
+		list, err := listSnapshots(db, *profile)
+		if err != nil {
+			return err
+		}
+		for _, s := range list {
+			var sources []string
+			for _, src := range s.Sources {
+				if src.Archive != "" {
+					sources = append(sources, fmt.Sprintf("%s:%s@%.12s", src.Source, src.Archive, src.SHA256))
+				} else {
+					sources = append(sources, src.Source)
+				}
+			}
+			fmt.Printf("%6d  %s  %-20s %-8s правил:%-6d sha256:%.12s  файлов:%d  источники: %s\n",
+				s.ID, s.CreatedAt.Format("2006-01-02 15:04:05"), s.Profile, s.Format, s.Rules, s.SHA256, len(s.Files),
+				strings.Join(sources, ", "))
+		}
+	case "diff":
+		if len(args) != 3 {
+			fmt.Println(snapshotsUsage)
+			os.Exit(2)
+		}
+		var rules [2]map[string]DeltaRule
+		for i, arg := range args[1:] {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("Некорректный номер снимка: %s", arg)
+			}
+			s, err := loadSnapshot(db, id)
+			if err != nil {
+				return err
+			}
+			if rules[i], err = snapshotRules(dir, s); err != nil {
+				return err
+			}
+		}
+		d := diffSnapshots(rules[0], rules[1])
+		for _, section := range []struct {
+			mark  string
+			rules []DeltaRule
+		}{{"+", d.Added}, {"-", d.Removed}, {"~", d.Changed}} {
+			for _, r := range section.rules {
+				fmt.Printf("%s %s:%s %s\n", section.mark, r.GID, r.SID, r.Rule)
+			}
+		}
+		fmt.Printf("Добавлено: %d, удалено: %d, изменено: %d\n", len(d.Added), len(d.Removed), len(d.Changed))
+	case "rollback":
+		if len(args) != 2 {
+			fmt.Println(snapshotsUsage)
+			os.Exit(2)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("Некорректный номер снимка: %s", args[1])
+		}
+		s, err := loadSnapshot(db, id)
+		if err != nil {
+			return err
+		}
+		key, err := signingKey()
+		if err != nil {
+			return fmt.Errorf("Ошибка загрузки ключа подписи: %v", err)
+		}
+		if err := rollbackSnapshot(db, dir, s); err != nil {
+			return err
+		}
+		var files []string
+		for _, f := range s.Files {
+			files = append(files, f.Path)
+		}
+		manifest := &ExportManifest{Profile: s.Profile, Format: s.Format, Snapshot: s.ID, Generated: time.Now().UTC()}
+		if err := writeManifest(manifestPath(s.Output), manifest, files, nil, key); err != nil {
+			return err
+		}
+		log.Printf("Снимок %d профиля %s опубликован", s.ID, s.Profile)
+		fmt.Printf("Снимок %d профиля %s (%s, правил %d) опубликован: %d файл(ов)\n",
+			s.ID, s.Profile, s.CreatedAt.Format("2006-01-02 15:04:05"), s.Rules, len(s.Files))
+	default:
+		fmt.Println(snapshotsUsage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// runVerify проверяет подпись манифеста выгрузки и хэши файлов. Код возврата 0 -
+// файлы можно загружать в сенсор.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	keyFile := fs.String("key", "", "Открытый ключ Ed25519 (PEM)")
+	fs.Parse(args)
+	if *keyFile == "" || fs.NArg() == 0 {
+		return fmt.Errorf("Использование: go run -tags export . verify -key export.pub ВЫГРУЗКА.manifest.json...")
+	}
+	key, err := loadPublicKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	for _, path := range fs.Args() {
+		m, err := verifyManifest(path, key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: подпись верна, профиль %s, снимок %d, %s, файлов %d\n",
+			path, m.Profile, m.Snapshot, m.Generated.Format(time.RFC3339), len(m.Files))
+	}
+	return nil
+}
+
+// runKeygen создаёт пару ключей подписи манифестов.
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	prefix := fs.String("out", "export", "Префикс файлов ключей: <out>.key и <out>.pub")
+	fs.Parse(args)
+	if err := generateKeys(*prefix); err != nil {
+		return err
+	}
+	fmt.Printf("Ключи записаны: %s.key (закрытый, для signing.key), %s.pub (открытый, для сенсоров)\n", *prefix, *prefix)
+	return nil
+}
+
 func initLog() {
 	file, err := os.Create("parser.log")
 	if err != nil {
@@ -105,67 +578,441 @@ func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
 	return sql.Open("postgres", connStr)
 }
 
-func exportSignatures(db *sql.DB, format ExportFormat, outputFile string) error {
+// exportResult - итог выгрузки профиля для снимка.
+type exportResult struct {
+	Files     []string // записанные файлы выгрузки
+	Artifacts []string // отчёт и дельта: в манифест, но не в снимок
+	Rules     int
+	Sources   []string // источники выгруженных правил
+}
+
+func exportSignatures(db *sql.DB, p ExportProfile, result *exportResult) error {
+	format, outputFile, filter := ExportFormat(p.Format), p.Output, p.Filter
+	where, args := filter.where()
+	log.Printf("Фильтр экспорта: %s", filter)
+
+	// Метка выгрузки - время её начала: изменения, сделанные во время выгрузки, попадут в следующую дельту.
+	var until time.Time
+	if err := db.QueryRow(`SELECT LOCALTIMESTAMP`).Scan(&until); err != nil {
+		return fmt.Errorf("Ошибка чтения времени БД: %v", err)
+	}
+	since, baseSHA256, hasWatermark, err := loadWatermark(db, p.key())
+	if err != nil {
+		return err
+	}
+	var delta *DeltaManifest
+	if p.Delta && !deltaFormats[p.Format] {
+		log.Printf("%s: дельты не поддерживаются для формата %s", p.title(), p.Format)
+	} else if p.Delta && !hasWatermark {
+		log.Printf("%s: прошлая выгрузка не найдена, дельта будет создана после следующей выгрузки", p.title())
+	} else if p.Delta {
+		delta = &DeltaManifest{Profile: p.key(), Format: p.Format, Since: since, Until: until, BaseSHA256: baseSHA256,
+			Added: []DeltaRule{}, Changed: []DeltaRule{}, Removed: []DeltaRule{}}
+	}
+
 	rows, err := db.Query(`
-        SELECT type, proto, src_ip, dst_ip, sid, msg, filename 
+        SELECT type, proto, src_ip, src_port, dst_ip, dst_port, sid, msg, filename, flowbits,
+               COALESCE(direction, '->'), COALESCE(options, '[]'::JSONB), COALESCE(dialect, ''), COALESCE(source, ''),
+               COALESCE(created_at, 'epoch'), COALESCE(updated_at, created_at, 'epoch'), COALESCE(rule_text, '')
         FROM signatures
-        WHERE deleted_at IS NULL
-    `)
+        WHERE `+where+exportOrder, args...)
 	if err != nil {
 		return fmt.Errorf("Ошибка выполнения запроса: %v", err)
 	}
 	defer rows.Close()
 
-	var outputData []string
+	// Правила пишутся в файл по мере чтения; файл заменяет прошлую выгрузку только целиком.
+	header := exportHeader(p)
+	var out *atomicFile
+	var text io.Writer // файл выгрузки Suricata или Snort 3 и хэш его содержимого
+	hash := sha256.New()
+	var dionis *dionisWriter
+	if format == Dionis {
+		dionis = newDionisWriter(outputFile, config.Dionis.MaxRulesPerFile, header)
+		defer dionis.abort()
+	} else {
+		out, err = createAtomic(outputFile)
+		if err != nil {
+			return err
+		}
+		defer out.abort()
+		text = io.MultiWriter(out, hash)
+		io.WriteString(text, strings.Join(header, "\n")+"\n")
+	}
+	exported := 0
+	sources := map[string]bool{}
+	var skipped []string // правила, не переведённые в синтаксис Snort 3 или формат Dionis-NX
+	flowbits := newFlowbitGraph()
 
 	for rows.Next() {
 		var sig Signature
 		var msg sql.NullString
 		var filename sql.NullString
+		var bits, options []byte
+		var createdAt, updatedAt time.Time
 
-		if err := rows.Scan(&sig.Type, &sig.Proto, &sig.SrcIP, &sig.DstIP, &sig.SID, &msg, &filename); err != nil {
+		if err := rows.Scan(&sig.Type, &sig.Proto, &sig.SrcIP, &sig.SrcPort, &sig.DstIP, &sig.DstPort, &sig.SID, &msg, &filename, &bits,
+			&sig.Direction, &options, &sig.Dialect, &sig.Source, &createdAt, &updatedAt, &sig.Raw); err != nil {
 			return fmt.Errorf("Ошибка сканирования данных: %v", err)
 		}
-
-		// Обработка nullable полей
-		if msg.Valid {
-			sig.Msg = msg.String
-		} else {
-			sig.Msg = "N/A" // Значение по умолчанию для NULL
+		if err := json.Unmarshal(options, &sig.Options); err != nil {
+			return fmt.Errorf("Ошибка разбора опций (SID: %s): %v", sig.SID, err)
 		}
-
-		if filename.Valid {
-			sig.Filename = filename.String
-		} else {
-			sig.Filename = "N/A" // Значение по умолчанию для NULL
+		if len(bits) > 0 {
+			if err := json.Unmarshal(bits, &sig.Flowbits); err != nil {
+				return fmt.Errorf("Ошибка разбора flowbits (SID: %s): %v", sig.SID, err)
+			}
 		}
 
+		// NULL остаётся пустой строкой: пустые поля не попадают в записи Dionis-NX.
+		sig.Msg = msg.String
+		sig.Filename = filename.String
+
+		// Переменные заголовка заменяются значениями профиля.
+		substituteVars(&sig, p.Variables)
+
+		// Правило, изменённое с прошлой выгрузки, попадает в дельту; если теперь его
+		// нельзя выгрузить, оно считается удалённым.
+		var rule string
+		changed := delta != nil && updatedAt.After(since)
+		deltaRule := DeltaRule{GID: ruleGID(sig.rule()), SID: sig.SID}
+
 		switch format {
 		case Suricata:
-			outputData = append(outputData, fmt.Sprintf(
-				"\nalert %s %s %s -> %s any (msg:\"%s\"; sid:%s;);",
-				sig.Type, sig.Proto, sig.SrcIP, sig.DstIP, sig.Msg, sig.SID,
-			))
+			rule, err = suricataText(sig)
+			if err != nil {
+				log.Printf("%s: SID %s (%s) не выгружено: %v", outputFile, sig.SID, sig.Filename, err)
+				if changed {
+					delta.Removed = append(delta.Removed, deltaRule)
+				}
+				continue
+			}
+			io.WriteString(text, rule+"\n")
 		case Dionis:
-			outputData = append(outputData, fmt.Sprintf(
-				"\ntype:%s;proto:%s;src_ip:%s;dst_ip:%s;sid:%s;msg:%s;filename:%s;",
-				sig.Type, sig.Proto, sig.SrcIP, sig.DstIP, sig.SID, sig.Msg, sig.Filename,
-			))
+			record, problems := dionisRecord(sig)
+			if len(problems) > 0 {
+				skipped = append(skipped, fmt.Sprintf("SID %s (%s): %s", sig.SID, sig.Filename, strings.Join(problems, ", ")))
+				continue
+			}
+			if err := dionis.write(record); err != nil {
+				return err
+			}
+		case Snort3:
+			var problems []string
+			rule, problems = snort3Text(sig)
+			if len(problems) > 0 {
+				skipped = append(skipped, fmt.Sprintf("SID %s (%s): %s", sig.SID, sig.Filename, strings.Join(problems, "; ")))
+				if changed {
+					delta.Removed = append(delta.Removed, deltaRule)
+				}
+				continue
+			}
+			io.WriteString(text, rule+"\n")
 		default:
 			return fmt.Errorf("Неподдерживаемый формат экспорта: %v", format)
 		}
+		exported++
+		sources[sig.Source] = true
+		// Установщиками и проверками flowbits считаются только записанные в выгрузку правила.
+		flowbits.add(sig.SID, true, sig.Flowbits)
+
+		if changed {
+			deltaRule.Rule = rule
+			if createdAt.After(since) {
+				delta.Added = append(delta.Added, deltaRule)
+			} else {
+				delta.Changed = append(delta.Changed, deltaRule)
+			}
+		}
 	}
 
 	if err := rows.Err(); err != nil {
 		return fmt.Errorf("Ошибка при чтении строк: %v", err)
 	}
 
-	// Запись результата в файл.
-	err = os.WriteFile(outputFile, []byte(strings.Join(outputData, "")), 0644) // Убираем лишние разделители между строками
+	reportFlowbits(flowbits, outputFile)
+	result.Rules = exported
+	for source := range sources {
+		result.Sources = append(result.Sources, source)
+	}
+
+	// Отчёт о правилах, которые не удалось перевести в Snort 3 или Dionis-NX.
+	if format == Snort3 || format == Dionis {
+		reportFile := outputFile + ".report"
+		if err := writeFileAtomic(reportFile, []byte(strings.Join(skipped, "\n")+"\n")); err != nil {
+			return err
+		}
+		log.Printf("%s: выгружено правил %d, не переведено %d (см. %s)", format, exported, len(skipped), reportFile)
+		result.Artifacts = append(result.Artifacts, reportFile)
+	}
+
+	// Записи Dionis-NX делятся на файлы по лимиту правил устройства.
+	if format == Dionis {
+		files, err := dionis.close()
+		if err != nil {
+			return err
+		}
+		log.Printf("Экспорт завершён. Данные сохранены в файлы: %s", strings.Join(files, ", "))
+		result.Files = files
+		return saveWatermark(db, p.key(), until, "")
+	}
+
+	if delta != nil {
+		if err := deltaRemoved(db, delta, where, args); err != nil {
+			return err
+		}
+	}
+
+	if err := out.commit(); err != nil {
+		return err
+	}
+	log.Printf("Экспорт завершён. Правил: %d, данные сохранены в файл: %s", exported, outputFile)
+	result.Files = []string{outputFile}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if delta != nil {
+		delta.SHA256 = sum
+		delta.Header = header
+		if err := writeDelta(delta, outputFile); err != nil {
+			return err
+		}
+		result.Artifacts = append(result.Artifacts, outputFile+".delta", outputFile+".delta.json")
+	}
+	return saveWatermark(db, p.key(), until, sum)
+}
+
+// deltaRemoved добавляет в дельту правила, удалённые с прошлой выгрузки или
+// изменённые так, что больше не проходят фильтр (например, отключённые). Правила,
+// созданные после прошлой выгрузки, в неё не попали и пропускаются. Прошло ли правило
+// фильтр в момент прошлой выгрузки, в БД не хранится, поэтому в removed могут оказаться
+// и правила, которых в прошлой выгрузке не было: applyDelta такие sid пропускает.
+func deltaRemoved(db *sql.DB, delta *DeltaManifest, where string, args []interface{}) error {
+	args = append(args, delta.Since)
+	rows, err := db.Query(fmt.Sprintf(`
+        SELECT sid, %s FROM signatures
+        WHERE (deleted_at > $%[2]d OR updated_at > $%[2]d) AND COALESCE(created_at, 'epoch') <= $%[2]d
+          AND (%[3]s) IS NOT TRUE`+exportOrder,
+		gidExpr, len(args), where), args...)
 	if err != nil {
-		return fmt.Errorf("Ошибка записи в файл %s: %v", outputFile, err)
+		return fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r DeltaRule
+		if err := rows.Scan(&r.SID, &r.GID); err != nil {
+			return fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		delta.Removed = append(delta.Removed, r)
 	}
+	return rows.Err()
+}
 
-	log.Printf("Экспорт завершён. Данные сохранены в файл: %s", outputFile)
+// writeDelta записывает дельту рядом с выгрузкой: файл правил <выгрузка>.delta
+// и манифест <выгрузка>.delta.json, который принимает apply-delta.
+func writeDelta(delta *DeltaManifest, outputFile string) error {
+	manifest, err := json.MarshalIndent(delta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации дельты: %v", err)
+	}
+	if err := writeFileAtomic(outputFile+".delta.json", append(manifest, '\n')); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	writeDeltaRules(&b, delta)
+	if err := writeFileAtomic(outputFile+".delta", []byte(b.String())); err != nil {
+		return err
+	}
+	log.Printf("Дельта %s: добавлено %d, изменено %d, удалено %d (%s.delta, %s.delta.json)",
+		delta.Profile, len(delta.Added), len(delta.Changed), len(delta.Removed), outputFile, outputFile)
 	return nil
-}
\ No newline at end of file
+}
+
+// runApplyDelta собирает полную выгрузку из прошлой выгрузки и дельты. Работает без БД:
+// команда выполняется на стороне сенсора. Дельта применяется, только если она указана
+// в подписанном манифесте выгрузки и совпадает с ним.
+func runApplyDelta(args []string) error {
+	fs := flag.NewFlagSet("apply-delta", flag.ExitOnError)
+	deltaFile := fs.String("delta", "", "Манифест дельты (.delta.json)")
+	keyFile := fs.String("key", "", "Открытый ключ Ed25519 (PEM) для проверки манифеста выгрузки")
+	manifestFile := fs.String("manifest", "", "Манифест выгрузки (по умолчанию <выгрузка>.manifest.json рядом с дельтой)")
+	baseFile := fs.String("base", "", "Файл прошлой выгрузки")
+	outFile := fs.String("out", "", "Файл результата (по умолчанию заменяется файл -base)")
+	force := fs.Bool("force", false, "Не проверять SHA-256 базы и результата")
+	fs.Parse(args)
+	if *deltaFile == "" || *baseFile == "" || *keyFile == "" {
+		return fmt.Errorf("Использование: go run -tags export . apply-delta -key export.pub -delta ФАЙЛ.delta.json -base ФАЙЛ [-out ФАЙЛ]")
+	}
+	if *outFile == "" {
+		*outFile = *baseFile
+	}
+	if *manifestFile == "" {
+		*manifestFile = manifestPath(strings.TrimSuffix(*deltaFile, ".delta.json"))
+	}
+	key, err := loadPublicKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*deltaFile)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения файла %s: %v", *deltaFile, err)
+	}
+	if err := verifyArtifact(*manifestFile, *deltaFile, data, key); err != nil {
+		return err
+	}
+	delta, err := readDeltaManifest(data)
+	if err != nil {
+		return err
+	}
+	base, err := os.ReadFile(*baseFile)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения файла %s: %v", *baseFile, err)
+	}
+	result, err := applyDelta(base, delta, *force)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(*outFile, result); err != nil {
+		return err
+	}
+	log.Printf("Дельта %s применена: добавлено %d, изменено %d, удалено %d, файл %s",
+		delta.Profile, len(delta.Added), len(delta.Changed), len(delta.Removed), *outFile)
+	return nil
+}
+
+// exportCatalog выгружает сигнатуры как данные: NDJSON со всеми полями, CSV
+// с выбранными столбцами, автономный файл SQLite или пользовательский формат по шаблону.
+func exportCatalog(db *sql.DB, p ExportProfile, result *exportResult) error {
+	format, outputFile, filter := ExportFormat(p.Format), p.Output, p.Filter
+	where, args := filter.where()
+	log.Printf("Фильтр экспорта: %s", filter)
+
+	// Записи пишутся по мере чтения; файл заменяет прошлую выгрузку только целиком.
+	// Первая строка NDJSON - объект с параметрами выгрузки, в CSV - строки комментария.
+	var w catalogWriter
+	var out *atomicFile
+	if format == SQLite {
+		// Время выгрузки хранится только в export_info: в файлах правил его нет,
+		// чтобы одинаковый набор правил давал одинаковый файл.
+		info := append(exportInfo(p), [2]string{"created", time.Now().Format(time.RFC3339)})
+		sw, err := newSQLiteWriter(outputFile, info)
+		if err != nil {
+			return err
+		}
+		defer sw.abort()
+		w = sw
+	} else {
+		var err error
+		if out, err = createAtomic(outputFile); err != nil {
+			return err
+		}
+		defer out.abort()
+		if format == NDJSON {
+			info := map[string]string{}
+			for _, kv := range exportInfo(p) {
+				info[kv[0]] = kv[1]
+			}
+			header, _ := json.Marshal(map[string]interface{}{"export": info})
+			out.Write(append(header, '\n'))
+			w = newNDJSONWriter(out)
+		} else if p.template != nil {
+			if w, err = newTemplateWriter(out, p.template, templateExport(p)); err != nil {
+				return err
+			}
+		} else {
+			out.WriteString(strings.Join(exportHeader(p), "\n") + "\n")
+			if w, err = newCSVWriter(out, p.Columns); err != nil {
+				return err
+			}
+		}
+	}
+
+	count := 0
+	sources := map[string]bool{}
+	err := querySignatures(db, where+exportOrder, args, func(sig Signature) error {
+		count++
+		sources[sig.Source] = true
+		if p.template != nil {
+			substituteVars(&sig, p.Variables)
+		}
+		return w.write(catalogEntry(sig))
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if out != nil {
+		if err := out.commit(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Экспорт завершён. Сигнатур: %d, файл: %s", count, outputFile)
+	result.Files, result.Rules = []string{outputFile}, count
+	for source := range sources {
+		result.Sources = append(result.Sources, source)
+	}
+	return nil
+}
+
+// exportInfo - параметры выгрузки, которые записываются в её заголовок. Времени выгрузки
+// среди них нет: содержимое файла зависит только от правил и профиля, поэтому SHA снимка
+// и ETag раздачи не меняются между выгрузками одного набора правил. Время записывается
+// в манифест и в таблицу export_info SQLite.
+func exportInfo(p ExportProfile) [][2]string {
+	info := [][2]string{{"format", p.Format}}
+	if p.Name != "" {
+		info = append(info, [2]string{"profile", p.Name})
+	}
+	info = append(info, [2]string{"filter", p.Filter.String()})
+	if p.Header != "" {
+		info = append(info, [2]string{"comment", strings.TrimSpace(p.Header)})
+	}
+	return info
+}
+
+// templateExport - параметры выгрузки для блоков header и footer шаблона.
+func templateExport(p ExportProfile) TemplateExport {
+	info := TemplateExport{Format: p.Format, Profile: p.Name, Created: time.Now().Format(time.RFC3339), Header: exportHeader(p)}
+	for _, kv := range exportInfo(p) {
+		switch kv[0] {
+		case "filter":
+			info.Filter = kv[1]
+		case "comment":
+			info.Comment = kv[1]
+		}
+	}
+	return info
+}
+
+// exportHeader - строки комментария с параметрами выгрузки и комментарием профиля:
+// # export format:suricata; profile:dmz; filter:source=ET severity=Major
+// # Сенсоры DMZ
+func exportHeader(p ExportProfile) []string {
+	var fields []string
+	var comment []string
+	for _, kv := range exportInfo(p) {
+		if kv[0] == "comment" {
+			for _, line := range strings.Split(kv[1], "\n") {
+				comment = append(comment, strings.TrimSpace("# "+line))
+			}
+			continue
+		}
+		fields = append(fields, kv[0]+":"+kv[1])
+	}
+	return append([]string{"# export " + strings.Join(fields, "; ")}, comment...)
+}
+
+// reportFlowbits записывает в лог проверки isset без установщика и правила
+// с noalert, чьи биты никто не проверяет, среди экспортированных правил.
+func reportFlowbits(graph *flowbitGraph, outputFile string) {
+	for _, issue := range graph.orphanedChecks() {
+		log.Printf("%s: SID %s проверяет flowbit %s, который не устанавливает ни одно экспортированное правило", outputFile, issue.SID, issue.Name)
+	}
+	for _, issue := range graph.unusedNoalert() {
+		log.Printf("%s: SID %s (noalert) устанавливает flowbit %s, который не проверяет ни одно экспортированное правило", outputFile, issue.SID, issue.Name)
+	}
+}
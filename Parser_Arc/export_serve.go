@@ -0,0 +1,347 @@
+//go:build export
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ServeConfig - раздача выгрузок сенсорам по HTTPS (go run -tags export . serve).
+type ServeConfig struct {
+	Listen  string         `mapstructure:"listen"`
+	Cert    string         `mapstructure:"cert"`
+	Key     string         `mapstructure:"key"`
+	Sensors []SensorConfig `mapstructure:"sensors"`
+}
+
+// SensorConfig - сенсор, получающий выгрузки, и его токен доступа.
+type SensorConfig struct {
+	Name     string   `mapstructure:"name"`
+	Token    string   `mapstructure:"token"`
+	Profiles []string `mapstructure:"profiles"` // пустой список - все профили
+}
+
+func (s SensorConfig) allowed(profile string) bool {
+	if len(s.Profiles) == 0 {
+		return true
+	}
+	for _, p := range s.Profiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// distributionServer отдаёт файлы последнего опубликованного снимка профиля:
+//
+//	GET /profiles/<профиль>                        файл выгрузки (или список файлов, если их несколько)
+//	GET /profiles/<профиль>/index.json             список файлов снимка
+//	GET /profiles/<профиль>/<файл>                 файл снимка, например часть выгрузки Dionis-NX
+//	GET /profiles/<профиль>/manifest.json[.sig]    манифест выгрузки и его подпись
+//
+// ETag файла - его SHA-256, поэтому сенсор с If-None-Match получает 304, пока снимок не сменится.
+type distributionServer struct {
+	db      *sql.DB
+	dir     string
+	sensors []SensorConfig
+}
+
+// runServe запускает раздачу выгрузок и работает до SIGINT или SIGTERM.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", "", "Адрес раздачи, по умолчанию serve.listen или :8443")
+	fs.Parse(args)
+
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("Ошибка загрузки конфигурации: %v", err)
+	}
+	cfg := config.Serve
+	if *listen != "" {
+		cfg.Listen = *listen
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8443"
+	}
+	if cfg.Cert == "" || cfg.Key == "" {
+		return fmt.Errorf("Для раздачи по HTTPS нужны serve.cert и serve.key")
+	}
+	tokens := map[string]bool{}
+	for _, s := range cfg.Sensors {
+		if s.Name == "" || s.Token == "" {
+			return fmt.Errorf("У сенсора в serve.sensors должны быть name и token")
+		}
+		if tokens[s.Token] {
+			return fmt.Errorf("Токен сенсора %s совпадает с токеном другого сенсора", s.Name)
+		}
+		tokens[s.Token] = true
+	}
+	if len(cfg.Sensors) == 0 {
+		return fmt.Errorf("В serve.sensors не задано ни одного сенсора")
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+	if err := initDB(db); err != nil {
+		return fmt.Errorf("Ошибка инициализации БД: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           &distributionServer{db: db, dir: config.Snapshots.dir(), sensors: cfg.Sensors},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	log.Printf("Раздача выгрузок запущена: https://%s/profiles/<профиль>, сенсоров: %d", cfg.Listen, len(cfg.Sensors))
+	fmt.Printf("Раздача выгрузок: https://%s/profiles/<профиль>\n", cfg.Listen)
+	if err := server.ListenAndServeTLS(cfg.Cert, cfg.Key); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("Ошибка раздачи: %v", err)
+	}
+	log.Println("Раздача выгрузок остановлена")
+	return nil
+}
+
+func (d *distributionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensor := d.authenticate(r)
+	if sensor == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="rules"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/profiles/") {
+		http.NotFound(w, r)
+		return
+	}
+	profile, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/profiles/"), "/")
+	if profile == "" || !sensor.allowed(profile) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	snapshot, err := publishedSnapshot(d.db, profile)
+	if err != nil {
+		log.Printf("Раздача: ошибка чтения снимка профиля %s: %v", profile, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if snapshot == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case file == "" && len(snapshot.Files) == 1:
+		file = filepath.Base(snapshot.Files[0].Path)
+	case file == "":
+		file = "index.json"
+	}
+	switch file {
+	case "index.json":
+		d.serveIndex(w, r, sensor, snapshot)
+	case "manifest.json", "manifest.json.sig":
+		d.serveManifest(w, r, sensor, snapshot, file)
+	default:
+		for _, f := range snapshot.Files {
+			if filepath.Base(f.Path) == file {
+				data, err := readSnapshotFile(d.dir, snapshot, f)
+				if err != nil {
+					log.Printf("Раздача: %v", err)
+					http.Error(w, "internal error", http.StatusInternalServerError)
+					return
+				}
+				d.serveData(w, r, sensor, snapshot, file, data, f.SHA256, "text/plain; charset=utf-8")
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// authenticate находит сенсор по токену из заголовка Authorization: Bearer.
+func (d *distributionServer) authenticate(r *http.Request) *SensorConfig {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header || token == "" {
+		return nil
+	}
+	for i := range d.sensors {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(d.sensors[i].Token)) == 1 {
+			return &d.sensors[i]
+		}
+	}
+	return nil
+}
+
+// serveIndex отдаёт список файлов снимка с хэшами и ссылками.
+func (d *distributionServer) serveIndex(w http.ResponseWriter, r *http.Request, sensor *SensorConfig, s *Snapshot) {
+	type indexFile struct {
+		Name   string `json:"name"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+		URL    string `json:"url"`
+	}
+	index := struct {
+		Profile   string      `json:"profile"`
+		Format    string      `json:"format"`
+		Snapshot  int         `json:"snapshot"`
+		CreatedAt time.Time   `json:"created_at"`
+		SHA256    string      `json:"sha256"`
+		Rules     int         `json:"rules"`
+		Files     []indexFile `json:"files"`
+	}{Profile: s.Profile, Format: s.Format, Snapshot: s.ID, CreatedAt: s.CreatedAt, SHA256: s.SHA256, Rules: s.Rules}
+	for _, f := range s.Files {
+		name := filepath.Base(f.Path)
+		index.Files = append(index.Files, indexFile{Name: name, SHA256: f.SHA256, Size: f.Size,
+			URL: "/profiles/" + s.Profile + "/" + name})
+	}
+	data, _ := json.MarshalIndent(index, "", "  ")
+	d.serveData(w, r, sensor, s, "index.json", append(data, '\n'), s.SHA256, "application/json")
+}
+
+// serveManifest отдаёт манифест выгрузки и подпись, если они относятся к отдаваемому снимку.
+func (d *distributionServer) serveManifest(w http.ResponseWriter, r *http.Request, sensor *SensorConfig, s *Snapshot, file string) {
+	path := manifestPath(s.Output)
+	manifest, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var m ExportManifest
+	if json.Unmarshal(manifest, &m) != nil || m.Snapshot != s.ID {
+		http.NotFound(w, r)
+		return
+	}
+	data := manifest
+	if file == "manifest.json.sig" {
+		if data, err = os.ReadFile(path + ".sig"); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	d.serveData(w, r, sensor, s, file, data, sha256Hex(data), "application/json")
+}
+
+// serveData отдаёт данные с ETag или 304, если у сенсора та же версия, и записывает получение.
+func (d *distributionServer) serveData(w http.ResponseWriter, r *http.Request, sensor *SensorConfig, s *Snapshot,
+	file string, data []byte, sum, contentType string) {
+	etag := `"` + sum + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Snapshot", strconv.Itoa(s.ID))
+
+	status := http.StatusOK
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		status = http.StatusNotModified
+		w.WriteHeader(status)
+	} else {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}
+	d.logFetch(r, sensor, s, file, status)
+}
+
+// etagMatch проверяет заголовок If-None-Match: список тегов через запятую или *.
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// logFetch записывает, какой снимок и когда получил сенсор.
+func (d *distributionServer) logFetch(r *http.Request, sensor *SensorConfig, s *Snapshot, file string, status int) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	log.Printf("Раздача: сенсор %s (%s) получил %s/%s, снимок %d, статус %d", sensor.Name, remote, s.Profile, file, s.ID, status)
+	_, err = d.db.Exec(`
+        INSERT INTO sensor_fetches (sensor, profile, snapshot_id, file, status, remote_addr)
+        VALUES ($1, $2, $3, $4, $5, $6)`, sensor.Name, s.Profile, s.ID, file, status, remote)
+	if err != nil {
+		log.Printf("Раздача: ошибка записи получения выгрузки: %v", err)
+	}
+}
+
+// runSensors показывает для каждого сенсора и профиля последний полученный снимок
+// и отстаёт ли он от опубликованного.
+func runSensors(args []string) error {
+	if err := loadConfig(); err != nil {
+		return fmt.Errorf("Ошибка загрузки конфигурации: %v", err)
+	}
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+	if err := initDB(db); err != nil {
+		return fmt.Errorf("Ошибка инициализации БД: %v", err)
+	}
+
+	rows, err := db.Query(`
+        SELECT DISTINCT ON (f.sensor, f.profile) f.sensor, f.profile, f.snapshot_id, f.fetched_at, COALESCE(f.remote_addr, ''),
+               (SELECT s.id FROM export_snapshots s WHERE s.profile = f.profile
+                ORDER BY s.published_at DESC NULLS LAST, s.id DESC LIMIT 1)
+        FROM sensor_fetches f
+        WHERE f.status IN (200, 304)
+        ORDER BY f.sensor, f.profile, f.fetched_at DESC, f.id DESC`)
+	if err != nil {
+		return fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sensor, profile, remote string
+		var snapshot int
+		var published sql.NullInt64
+		var fetched time.Time
+		if err := rows.Scan(&sensor, &profile, &snapshot, &fetched, &remote, &published); err != nil {
+			return fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		state := "актуален"
+		if published.Valid && int(published.Int64) != snapshot {
+			state = fmt.Sprintf("опубликован снимок %d", published.Int64)
+		}
+		fmt.Printf("%-20s %-20s снимок %-6d %s  %-15s %s\n",
+			sensor, profile, snapshot, fetched.Format("2006-01-02 15:04:05"), remote, state)
+	}
+	return rows.Err()
+}
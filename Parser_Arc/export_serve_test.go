@@ -0,0 +1,225 @@
+//go:build export
+
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sensorFetch - строка sensor_fetches тестовой БД.
+type sensorFetch struct {
+	Sensor, Profile string
+	Snapshot        int64
+	File            string
+	Status          int64
+}
+
+// serveFixture - каталог снимков с опубликованными снимками профилей dmz (один файл)
+// и dionis (две части) и тестовая БД с таблицами export_snapshots и sensor_fetches.
+type serveFixture struct {
+	server    *distributionServer
+	snapshots map[string]*Snapshot
+	fetches   []sensorFetch
+	dmzData   string
+}
+
+func newServeFixture(t *testing.T) *serveFixture {
+	t.Helper()
+	dir := t.TempDir()
+	f := &serveFixture{snapshots: map[string]*Snapshot{}, dmzData: "# export format:suricata\nalert tcp any any -> any any (msg:\"x\"; sid:1;)\n"}
+	// files - пары имя файла, содержимое.
+	snapshot := func(id int, profile, format, output string, files ...string) {
+		s := &Snapshot{ID: id, Profile: profile, Format: format, Output: filepath.Join(dir, output),
+			CreatedAt: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), SHA256: strings.Repeat("a", 64), Rules: len(files) / 2}
+		if err := os.MkdirAll(snapshotDir(dir, id), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(files); i += 2 {
+			name, data := files[i], files[i+1]
+			writeTestFile(t, filepath.Join(snapshotDir(dir, id), name), data)
+			writeTestFile(t, filepath.Join(dir, name), data)
+			s.Files = append(s.Files, SnapshotFile{Path: filepath.Join(dir, name), SHA256: sha256Hex([]byte(data)), Size: int64(len(data))})
+		}
+		f.snapshots[profile] = s
+	}
+	snapshot(7, "dmz", "suricata", "export_suricata.txt", "export_suricata.txt", f.dmzData)
+	snapshot(8, "dionis", "dionis", "export_dionis.txt", "export_dionis_001.txt", "# 1\n", "export_dionis_002.txt", "# 2\n")
+
+	// Манифест dmz относится к снимку 7, манифест dionis - к прошлому снимку.
+	dmz := f.snapshots["dmz"]
+	if err := writeManifest(manifestPath(dmz.Output), &ExportManifest{Profile: "dmz", Format: "suricata", Snapshot: 7},
+		[]string{dmz.Files[0].Path}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	dionis := f.snapshots["dionis"]
+	if err := writeManifest(manifestPath(dionis.Output), &ExportManifest{Profile: "dionis", Format: "dionis", Snapshot: 5},
+		nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	sensors := []SensorConfig{{Name: "edge", Token: "edge-token", Profiles: []string{"dmz"}}, {Name: "core", Token: "core-token"}}
+	f.server = &distributionServer{db: openFakeDB(t, f.query), dir: dir, sensors: sensors}
+	return f
+}
+
+func (f *serveFixture) query(query string, args []driver.Value) ([][]driver.Value, error) {
+	if strings.Contains(query, "INSERT INTO sensor_fetches") {
+		f.fetches = append(f.fetches, sensorFetch{args[0].(string), args[1].(string), args[2].(int64), args[3].(string), args[4].(int64)})
+		return nil, nil
+	}
+	s := f.snapshots[args[0].(string)]
+	if s == nil {
+		return nil, nil
+	}
+	files, _ := json.Marshal(s.Files)
+	return [][]driver.Value{{int64(s.ID), s.Profile, s.Format, s.Output, s.CreatedAt, s.SHA256, int64(s.Rules), s.Filter, files, []byte("[]")}}, nil
+}
+
+// serveRequest выполняет запрос сенсора с токеном и заголовками header.
+func serveRequest(f *serveFixture, method, path, token string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	f.server.ServeHTTP(w, r)
+	return w
+}
+
+func TestServeAccess(t *testing.T) {
+	f := newServeFixture(t)
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/profiles/dmz", "", http.StatusUnauthorized},
+		{"GET", "/profiles/dmz", "wrong-token", http.StatusUnauthorized},
+		{"POST", "/profiles/dmz", "edge-token", http.StatusMethodNotAllowed},
+		{"GET", "/profiles/dmz", "edge-token", http.StatusOK},
+		{"HEAD", "/profiles/dmz", "edge-token", http.StatusOK},
+		// Сенсору edge разрешён только профиль dmz.
+		{"GET", "/profiles/dionis", "edge-token", http.StatusForbidden},
+		{"GET", "/profiles/dionis/export_dionis_001.txt", "edge-token", http.StatusForbidden},
+		{"GET", "/profiles/dionis", "core-token", http.StatusOK},
+		{"GET", "/profiles/dionis/export_dionis_002.txt", "core-token", http.StatusOK},
+		{"GET", "/profiles/dmz/export_dionis_002.txt", "core-token", http.StatusNotFound},
+		{"GET", "/profiles/other", "core-token", http.StatusNotFound},
+		{"GET", "/profiles/", "core-token", http.StatusForbidden},
+		{"GET", "/other", "core-token", http.StatusNotFound},
+		{"GET", "/profiles/dmz/manifest.json", "edge-token", http.StatusOK},
+		// Подписи нет: ключ подписи не задан.
+		{"GET", "/profiles/dmz/manifest.json.sig", "edge-token", http.StatusNotFound},
+		// Манифест dionis записан для другого снимка.
+		{"GET", "/profiles/dionis/manifest.json", "core-token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := serveRequest(f, tt.method, tt.path, tt.token)
+		if w.Code != tt.want {
+			t.Errorf("%s %s (%s): код %d, ожидался %d", tt.method, tt.path, tt.token, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: нет заголовка WWW-Authenticate", tt.method, tt.path)
+		}
+	}
+
+	// Получения записываются только для отданных файлов, отказы - нет.
+	for _, fetch := range f.fetches {
+		if fetch.Status != http.StatusOK {
+			t.Errorf("записано получение %+v", fetch)
+		}
+	}
+	if len(f.fetches) != 5 {
+		t.Errorf("записано получений %d, ожидалось 5: %+v", len(f.fetches), f.fetches)
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	f := newServeFixture(t)
+	w := serveRequest(f, "GET", "/profiles/dmz", "edge-token")
+	etag := `"` + sha256Hex([]byte(f.dmzData)) + `"`
+	if w.Code != http.StatusOK || w.Body.String() != f.dmzData {
+		t.Fatalf("код %d, тело %q", w.Code, w.Body)
+	}
+	if w.Header().Get("ETag") != etag || w.Header().Get("X-Snapshot") != "7" {
+		t.Errorf("заголовки %v", w.Header())
+	}
+	if w := serveRequest(f, "HEAD", "/profiles/dmz", "edge-token"); w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("HEAD: тело %q, ETag %s", w.Body, w.Header().Get("ETag"))
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := serveRequest(f, "GET", "/profiles/dmz", "edge-token", "If-None-Match", tt.ifNoneMatch)
+		if w.Code != tt.want {
+			t.Errorf("If-None-Match %s: код %d, ожидался %d", tt.ifNoneMatch, w.Code, tt.want)
+		}
+		if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: тело ответа 304 %q", tt.ifNoneMatch, w.Body)
+		}
+	}
+
+	want := []sensorFetch{
+		{"edge", "dmz", 7, "export_suricata.txt", 200},
+		{"edge", "dmz", 7, "export_suricata.txt", 200},
+		{"edge", "dmz", 7, "export_suricata.txt", 304},
+		{"edge", "dmz", 7, "export_suricata.txt", 304},
+		{"edge", "dmz", 7, "export_suricata.txt", 304},
+		{"edge", "dmz", 7, "export_suricata.txt", 304},
+		{"edge", "dmz", 7, "export_suricata.txt", 200},
+	}
+	if !reflect.DeepEqual(f.fetches, want) {
+		t.Errorf("получения %+v, ожидалось %+v", f.fetches, want)
+	}
+}
+
+func TestServeIndex(t *testing.T) {
+	f := newServeFixture(t)
+	w := serveRequest(f, "GET", "/profiles/dionis", "core-token")
+	var index struct {
+		Profile  string `json:"profile"`
+		Snapshot int    `json:"snapshot"`
+		Files    []struct {
+			Name, SHA256, URL string
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &index); err != nil {
+		t.Fatalf("index.json: %v\n%s", err, w.Body)
+	}
+	if index.Profile != "dionis" || index.Snapshot != 8 || len(index.Files) != 2 ||
+		index.Files[1].URL != "/profiles/dionis/export_dionis_002.txt" || index.Files[1].SHA256 != sha256Hex([]byte("# 2\n")) {
+		t.Errorf("index.json %+v", index)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"`+f.snapshots["dionis"].SHA256+`"` {
+		t.Errorf("ETag index.json %s", etag)
+	}
+	if len(f.fetches) != 1 || f.fetches[0].File != "index.json" || f.fetches[0].Snapshot != 8 {
+		t.Errorf("получения %+v", f.fetches)
+	}
+
+	// Повреждённый файл снимка не отдаётся.
+	writeTestFile(t, filepath.Join(snapshotDir(f.server.dir, 8), "export_dionis_001.txt"), "# changed\n")
+	if w := serveRequest(f, "GET", "/profiles/dionis/export_dionis_001.txt", "core-token"); w.Code != http.StatusInternalServerError {
+		t.Errorf("повреждённый файл: код %d", w.Code)
+	}
+}
@@ -0,0 +1,206 @@
+//go:build export
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// Схема автономной выгрузки SQLite: сигнатуры, классы правил и ссылки.
+const sqliteSchema = `
+CREATE TABLE signatures (
+    sid TEXT PRIMARY KEY,
+    gid TEXT,
+    rev TEXT,
+    action TEXT,
+    proto TEXT,
+    src_ip TEXT,
+    src_port TEXT,
+    direction TEXT,
+    dst_ip TEXT,
+    dst_port TEXT,
+    msg TEXT,
+    classtype TEXT REFERENCES classifications (name),
+    priority TEXT,
+    severity TEXT,
+    policies TEXT,
+    mitre TEXT,
+    deployment TEXT,
+    created_at TEXT,
+    updated_at TEXT,
+    source TEXT,
+    filename TEXT,
+    local INTEGER,
+    enabled INTEGER,
+    dialect TEXT,
+    min_engine_version TEXT,
+    metadata TEXT,
+    flowbits TEXT,
+    rule TEXT
+);
+
+CREATE TABLE classifications (
+    name TEXT PRIMARY KEY,
+    description TEXT,
+    priority INTEGER
+);
+
+CREATE TABLE signature_references (
+    sid TEXT REFERENCES signatures (sid),
+    type TEXT,
+    value TEXT,
+    url TEXT
+);
+
+-- Параметры выгрузки: формат, время создания, фильтр.
+CREATE TABLE export_info (
+    key TEXT PRIMARY KEY,
+    value TEXT
+);
+
+CREATE INDEX signatures_classtype_idx ON signatures (classtype);
+CREATE INDEX signatures_severity_idx ON signatures (severity);
+CREATE INDEX signature_references_sid_idx ON signature_references (sid);
+CREATE INDEX signature_references_value_idx ON signature_references (type, value);
+`
+
+// sqliteWriter записывает каталог сигнатур в файл SQLite. Файл собирается во временном
+// файле в том же каталоге и заменяет существующий в flush.
+// Параметры выгрузки из info записываются в таблицу export_info.
+type sqliteWriter struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	path    string
+	tmp     string
+	known   map[string]bool // классы, уже записанные в classifications
+	flushed bool
+}
+
+func newSQLiteWriter(outputFile string, info [][2]string) (*sqliteWriter, error) {
+	file, err := os.CreateTemp(filepath.Dir(outputFile), "."+filepath.Base(outputFile)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка создания временного файла для %s: %v", outputFile, err)
+	}
+	file.Close()
+	w := &sqliteWriter{path: outputFile, tmp: file.Name(), known: map[string]bool{}}
+
+	if w.db, err = sql.Open("sqlite", w.tmp); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("Ошибка открытия SQLite: %v", err)
+	}
+	if _, err := w.db.Exec(sqliteSchema); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("Ошибка создания схемы SQLite: %v", err)
+	}
+	if w.tx, err = w.db.Begin(); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+
+	for _, kv := range info {
+		if _, err := w.tx.Exec(`INSERT INTO export_info (key, value) VALUES (?, ?)`, kv[0], kv[1]); err != nil {
+			w.abort()
+			return nil, fmt.Errorf("Ошибка записи параметров выгрузки: %v", err)
+		}
+	}
+
+	// В классы попадают стандартные классы и все классы, встреченные в правилах.
+	for _, c := range classifications {
+		w.known[c.Name] = true
+		if _, err := w.tx.Exec(`INSERT INTO classifications (name, description, priority) VALUES (?, ?, ?)`,
+			c.Name, c.Description, c.Priority); err != nil {
+			w.abort()
+			return nil, fmt.Errorf("Ошибка записи класса %s: %v", c.Name, err)
+		}
+	}
+	return w, nil
+}
+
+func (w *sqliteWriter) write(e CatalogEntry) error {
+	if e.Classtype != "" && !w.known[e.Classtype] {
+		w.known[e.Classtype] = true
+		if _, err := w.tx.Exec(`INSERT INTO classifications (name) VALUES (?)`, e.Classtype); err != nil {
+			return fmt.Errorf("Ошибка записи класса %s: %v", e.Classtype, err)
+		}
+	}
+
+	metadata, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации metadata (SID: %s): %v", e.SID, err)
+	}
+	flowbits, err := json.Marshal(e.Flowbits)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации flowbits (SID: %s): %v", e.SID, err)
+	}
+	_, err = w.tx.Exec(`
+        INSERT INTO signatures (sid, gid, rev, action, proto, src_ip, src_port, direction, dst_ip, dst_port,
+            msg, classtype, priority, severity, policies, mitre, deployment, created_at, updated_at,
+            source, filename, local, enabled, dialect, min_engine_version, metadata, flowbits, rule)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.SID, e.GID, e.Rev, e.Action, e.Proto, e.SrcIP, e.SrcPort, e.Direction, e.DstIP, e.DstPort,
+		e.Msg, nullIfEmpty(e.Classtype), e.Priority, e.Severity, strings.Join(e.Policies, ","),
+		strings.Join(e.Mitre, ","), strings.Join(e.Deployment, ","), nullIfEmpty(e.CreatedAt), nullIfEmpty(e.UpdatedAt),
+		e.Source, e.Filename, e.Local, e.Enabled, e.Dialect, nullIfEmpty(e.MinVersion),
+		string(metadata), string(flowbits), e.Rule)
+	if err != nil {
+		return fmt.Errorf("Ошибка записи сигнатуры (SID: %s): %v", e.SID, err)
+	}
+
+	for _, ref := range e.References {
+		if _, err := w.tx.Exec(`INSERT INTO signature_references (sid, type, value, url) VALUES (?, ?, ?, ?)`,
+			e.SID, ref.Type, ref.Value, nullIfEmpty(ref.URL())); err != nil {
+			return fmt.Errorf("Ошибка записи ссылки (SID: %s): %v", e.SID, err)
+		}
+	}
+	return nil
+}
+
+// flush фиксирует транзакцию, сбрасывает файл на диск и заменяет им outputFile.
+func (w *sqliteWriter) flush() error {
+	if err := w.tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	if err := w.db.Close(); err != nil {
+		return fmt.Errorf("Ошибка закрытия SQLite: %v", err)
+	}
+	w.db = nil
+
+	file, err := os.Open(w.tmp)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия файла %s: %v", w.tmp, err)
+	}
+	err = file.Sync()
+	file.Close()
+	if err == nil {
+		err = os.Chmod(w.tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(w.tmp, w.path)
+	}
+	if err != nil {
+		return fmt.Errorf("Ошибка записи в файл %s: %v", w.path, err)
+	}
+	w.flushed = true
+	return syncDir(filepath.Dir(w.path))
+}
+
+// abort закрывает базу и удаляет временный файл, если flush не был выполнен.
+func (w *sqliteWriter) abort() {
+	if w.flushed {
+		return
+	}
+	if w.db != nil {
+		w.db.Close()
+		w.db = nil
+	}
+	os.Remove(w.tmp)
+	os.Remove(w.tmp + "-journal")
+	w.flushed = true
+}
@@ -0,0 +1,163 @@
+//go:build export
+
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "catalog.sqlite")
+	w, err := newSQLiteWriter(path, [][2]string{{"format", "sqlite"}, {"filter", "source=ET"}})
+	if err != nil {
+		t.Fatalf("newSQLiteWriter: %v", err)
+	}
+	defer w.abort()
+	for _, e := range catalogEntries(t) {
+		if err := w.write(e); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	checkNoTempFiles(t, dir, "catalog.sqlite")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Схема: таблицы и столбцы signatures.
+	want := map[string]string{
+		"signatures":           "sid,gid,rev,action,proto,src_ip,src_port,direction,dst_ip,dst_port,msg,classtype,priority,severity,policies,mitre,deployment,created_at,updated_at,source,filename,local,enabled,dialect,min_engine_version,metadata,flowbits,rule",
+		"classifications":      "name,description,priority",
+		"signature_references": "sid,type,value,url",
+		"export_info":          "key,value",
+	}
+	for table, columns := range want {
+		if got := sqliteColumns(t, db, table); got != columns {
+			t.Errorf("столбцы %s: %s, ожидалось %s", table, got, columns)
+		}
+	}
+
+	var sid, msg, policies, severity, createdAt, metadata, flowbits string
+	var local, enabled bool
+	err = db.QueryRow(`SELECT sid, msg, policies, severity, created_at, metadata, flowbits, local, enabled FROM signatures WHERE gid = '1'`).
+		Scan(&sid, &msg, &policies, &severity, &createdAt, &metadata, &flowbits, &local, &enabled)
+	if err != nil {
+		t.Fatalf("чтение сигнатуры: %v", err)
+	}
+	got := []string{sid, msg, policies, severity, createdAt, flowbits}
+	if !reflect.DeepEqual(got, []string{"2000001", `ET MALWARE a, "b"`, "balanced-ips", "Major", "2021-12-10", `[{"cmd":"set","name":"mal"}]`}) ||
+		!strings.Contains(metadata, `"signature_severity":["Major"]`) || local || !enabled {
+		t.Errorf("сигнатура %q, metadata %s, local %v, enabled %v", got, metadata, local, enabled)
+	}
+
+	// Пустые необязательные значения записываются как NULL.
+	var classtype, created, minVersion sql.NullString
+	if err := db.QueryRow(`SELECT classtype, created_at, min_engine_version FROM signatures WHERE sid = '2000002'`).
+		Scan(&classtype, &created, &minVersion); err != nil {
+		t.Fatal(err)
+	}
+	if classtype.String != "custom-class" || created.Valid || minVersion.Valid {
+		t.Errorf("classtype %v, created_at %v, min_engine_version %v", classtype, created, minVersion)
+	}
+
+	// Нестандартный класс правила добавляется в classifications один раз.
+	var classes int
+	db.QueryRow(`SELECT COUNT(*) FROM classifications WHERE name IN ('custom-class', 'trojan-activity')`).Scan(&classes)
+	if classes != 2 {
+		t.Errorf("классов custom-class и trojan-activity: %d", classes)
+	}
+
+	var refs []string
+	rows, err := db.Query(`SELECT type, value, COALESCE(url, '') FROM signature_references WHERE sid = '2000001' ORDER BY type`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var kind, value, url string
+		rows.Scan(&kind, &value, &url)
+		refs = append(refs, kind+" "+value+" "+url)
+	}
+	rows.Close()
+	if len(refs) != 2 || !strings.HasPrefix(refs[0], "cve 2021-44228 ") || refs[1] != "url example.com/a http://example.com/a" {
+		t.Errorf("ссылки %q", refs)
+	}
+
+	var filter string
+	db.QueryRow(`SELECT value FROM export_info WHERE key = 'filter'`).Scan(&filter)
+	if filter != "source=ET" {
+		t.Errorf("export_info filter = %q", filter)
+	}
+}
+
+func TestSQLiteWriterAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "catalog.sqlite")
+	writeTestFile(t, path, "previous export")
+
+	w, err := newSQLiteWriter(path, nil)
+	if err != nil {
+		t.Fatalf("newSQLiteWriter: %v", err)
+	}
+	entries := catalogEntries(t)
+	if err := w.write(entries[0]); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	// Повторный sid нарушает первичный ключ: выгрузка прерывается.
+	if err := w.write(entries[0]); err == nil {
+		t.Fatal("ожидалась ошибка записи повторного sid")
+	}
+	w.abort()
+	w.abort()
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "previous export" {
+		t.Errorf("прошлая выгрузка изменена: %q, %v", data, err)
+	}
+	checkNoTempFiles(t, dir, "catalog.sqlite")
+}
+
+// sqliteColumns возвращает столбцы таблицы через запятую.
+func sqliteColumns(t *testing.T, db *sql.DB, table string) string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns = append(columns, name)
+	}
+	return strings.Join(columns, ",")
+}
+
+// checkNoTempFiles проверяет, что в каталоге остались только файлы names.
+func checkNoTempFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	if !reflect.DeepEqual(got, names) {
+		t.Errorf("в каталоге файлы %v, ожидалось %v", got, names)
+	}
+}
@@ -0,0 +1,45 @@
+//go:build export
+
+package main
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeltaRemoved(t *testing.T) {
+	since := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var query string
+	var args []driver.Value
+	db := openFakeDB(t, func(q string, a []driver.Value) ([][]driver.Value, error) {
+		query, args = q, a
+		return [][]driver.Value{{"100", "1"}, {"5", "3"}}, nil
+	})
+
+	delta := &DeltaManifest{Since: since}
+	f := ExportFilter{Sources: []string{"ET"}}
+	where, whereArgs := f.where()
+	if err := deltaRemoved(db, delta, where, whereArgs); err != nil {
+		t.Fatalf("deltaRemoved: %v", err)
+	}
+	if want := []DeltaRule{{GID: "1", SID: "100"}, {GID: "3", SID: "5"}}; !reflect.DeepEqual(delta.Removed, want) {
+		t.Errorf("removed %+v, ожидалось %+v", delta.Removed, want)
+	}
+
+	// Метка прошлой выгрузки - параметр после параметров фильтра; правила, созданные
+	// после неё, в прошлую выгрузку не попали.
+	for _, part := range []string{
+		"(deleted_at > $2 OR updated_at > $2) AND COALESCE(created_at, 'epoch') <= $2",
+		"AND (deleted_at IS NULL AND COALESCE(enabled_override, enabled, TRUE) AND signatures.source = ANY($1)) IS NOT TRUE",
+	} {
+		if !strings.Contains(query, part) {
+			t.Errorf("в запросе нет %q:\n%s", part, query)
+		}
+	}
+	if len(args) != 2 || args[1] != since {
+		t.Errorf("параметры %v", args)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeQuery отвечает на запрос к тестовой БД строками результата. Для Exec строки не нужны.
+type fakeQuery func(query string, args []driver.Value) ([][]driver.Value, error)
+
+// Обработчики запросов открытых тестовых БД по имени источника данных.
+var (
+	fakeDBOnce     sync.Once
+	fakeDBMu       sync.Mutex
+	fakeDBHandlers = map[string]fakeQuery{}
+)
+
+// openFakeDB открывает БД, запросы к которой выполняет handler: тесты HTTP-обработчиков
+// работают без PostgreSQL. Транзакции не изолируются, Commit и Rollback ничего не делают.
+func openFakeDB(t *testing.T, handler fakeQuery) *sql.DB {
+	t.Helper()
+	fakeDBOnce.Do(func() { sql.Register("fakedb", fakeDriver{}) })
+	fakeDBMu.Lock()
+	fakeDBHandlers[t.Name()] = handler
+	fakeDBMu.Unlock()
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBMu.Lock()
+		delete(fakeDBHandlers, t.Name())
+		fakeDBMu.Unlock()
+	})
+	return db
+}
+
+// signatureRow - значения столбцов signatureColumns для правила rule.
+func signatureRow(t *testing.T, rule, source string) []driver.Value {
+	t.Helper()
+	parsed, err := parseRule(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(parsed, source+".rules", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flowbits, _ := json.Marshal(sig.Flowbits)
+	options, _ := json.Marshal(sig.Options)
+	return []driver.Value{sig.Type, sig.Proto, sig.SrcIP, sig.SrcPort, sig.Direction, sig.DstIP, sig.DstPort,
+		sig.SID, sig.Msg, sig.Filename, sig.Source, sig.Local, sig.Enabled, flowbits, options, sig.Fingerprint,
+		sig.Dialect, sig.MinVersion, sig.Raw}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBMu.Lock()
+	handler := fakeDBHandlers[name]
+	fakeDBMu.Unlock()
+	if handler == nil {
+		return nil, fmt.Errorf("Нет обработчика тестовой БД %s", name)
+	}
+	return &fakeConn{handler: handler}, nil
+}
+
+type fakeConn struct {
+	mu      sync.Mutex
+	handler fakeQuery
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (c *fakeConn) run(query string, named []driver.NamedValue) ([][]driver.Value, error) {
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handler(query, args)
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+// Columns - безымянные столбцы по ширине первой строки.
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
@@ -0,0 +1,173 @@
+//go:build feeds
+
+package main
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB      DBConfig       `mapstructure:"db"`
+	Sources []SourceConfig `mapstructure:"sources"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+// SourceConfig - список адресов. url или path указывают на сам список,
+// categories - на файл категорий Suricata для источников iprep.
+type SourceConfig struct {
+	Name       string `mapstructure:"name"`
+	Type       string `mapstructure:"type"`
+	URL        string `mapstructure:"url"`
+	Path       string `mapstructure:"path"`
+	Category   string `mapstructure:"category"`
+	Categories string `mapstructure:"categories"`
+}
+
+var config Config
+
+func main() {
+	initLog()
+
+	log.Println("=== Старт загрузки списков IP-адресов ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	for _, source := range config.Sources {
+		if source.Type != FeedIPList && source.Type != FeedIPRep {
+			continue
+		}
+		log.Printf("Обработка источника: %s", source.Name)
+		if err := processFeed(db, source); err != nil {
+			log.Printf("Ошибка обработки списка для источника %s: %v", source.Name, err)
+		}
+	}
+
+	log.Println("=== Завершение загрузки списков IP-адресов ===")
+}
+
+func processFeed(db *sql.DB, source SourceConfig) error {
+	location := source.URL
+	if location == "" {
+		location = source.Path
+	}
+	content, err := fetchFeed(location)
+	if err != nil {
+		return err
+	}
+
+	var indicators []Indicator
+	var invalid int
+	switch source.Type {
+	case FeedIPList:
+		indicators, invalid = parseIPList(content, source.Category)
+	case FeedIPRep:
+		categories := map[string]string{}
+		if source.Categories != "" {
+			text, err := fetchFeed(source.Categories)
+			if err != nil {
+				return err
+			}
+			categories = parseIPRepCategories(text)
+		}
+		indicators, invalid = parseIPRep(content, categories)
+	}
+	if invalid > 0 {
+		log.Printf("Источник %s: пропущено некорректных строк %d", source.Name, invalid)
+	}
+
+	return saveIndicators(db, source.Name, indicators)
+}
+
+// fetchFeed загружает список по URL http(s) или читает локальный файл.
+func fetchFeed(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("Не указан url или path списка")
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		content, err := os.ReadFile(location)
+		if err != nil {
+			return "", fmt.Errorf("Ошибка чтения файла %s: %v", location, err)
+		}
+		return string(content), nil
+	}
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   30 * time.Second,
+	}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return "", fmt.Errorf("Ошибка загрузки файла по URL: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP ошибка: %s", resp.Status)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("Ошибка чтения ответа: %v", err)
+	}
+	log.Printf("Список загружен по URL: %s", location)
+	return string(content), nil
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало загрузки списков IP-адресов ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
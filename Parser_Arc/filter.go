@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ExportFilter - условия отбора сигнатур для экспорта.
+// Пустое поле означает отсутствие ограничения.
+type ExportFilter struct {
+	Sources    []string `mapstructure:"source"`
+	IDs        []string `mapstructure:"sid"` // диапазоны sid, см. parseIDRange
+	Classtypes []string `mapstructure:"classtype"`
+	Severities []string `mapstructure:"severity"`
+	Policies   []string `mapstructure:"policy"`
+	Protocols  []string `mapstructure:"proto"`
+	Filenames  []string `mapstructure:"filename"` // шаблоны имени файла правил: emerging-malware*
+	Tags       []string `mapstructure:"tag"`      // значения metadata tag или метки аналитиков
+	Metadata   []string `mapstructure:"metadata"` // пары metadata ключ=значение: deployment=Perimeter
+
+	// Только правила, созданные или обновлённые поставщиком начиная с даты (YYYY-MM-DD).
+	CreatedSince string `mapstructure:"created_since"`
+	UpdatedSince string `mapstructure:"updated_since"`
+
+	// CollapseDuplicates оставляет из правил с одинаковым отпечатком из разных
+	// источников одно: локальное, а среди правил поставщиков - загруженное первым.
+	CollapseDuplicates bool `mapstructure:"collapse_duplicates"`
+
+	// EngineVersion - версия Suricata, для которой выполняется экспорт: правила,
+	// требующие более новой версии, не выгружаются.
+	EngineVersion string `mapstructure:"engine_version"`
+}
+
+// IDRange - диапазон sid, при GID != 0 - только для указанного gid.
+type IDRange struct {
+	GID      int
+	Min, Max uint64
+}
+
+// gid правила хранится в опциях; правила без опции gid относятся к gid 1.
+const gidExpr = `COALESCE((SELECT o->>'value' FROM jsonb_array_elements(COALESCE(signatures.options, '[]'::JSONB)) o
+                 WHERE o->>'name' = 'gid' LIMIT 1), '1')`
+
+// exportOrder упорядочивает выгрузку по (gid, sid), чтобы последовательные выгрузки
+// отличались только изменёнными правилами.
+const exportOrder = ` ORDER BY substring(` + gidExpr + ` from '^[0-9]+$')::NUMERIC NULLS LAST,
+    substring(sid from '^[0-9]+$')::NUMERIC NULLS LAST, sid, id`
+
+// parseIDRange разбирает диапазон вида 2000000-2099999, 1:2000000-2099999 или 9000001.
+func parseIDRange(value string) (IDRange, error) {
+	var r IDRange
+	ids := value
+	if gid, rest, ok := strings.Cut(value, ":"); ok {
+		n, err := strconv.Atoi(gid)
+		if err != nil || n <= 0 {
+			return r, fmt.Errorf("Некорректный gid в диапазоне %s", value)
+		}
+		r.GID, ids = n, rest
+	}
+	min, max, isRange := strings.Cut(ids, "-")
+	if !isRange {
+		max = min
+	}
+	var err1, err2 error
+	r.Min, err1 = strconv.ParseUint(strings.TrimSpace(min), 10, 64)
+	r.Max, err2 = strconv.ParseUint(strings.TrimSpace(max), 10, 64)
+	if err1 != nil || err2 != nil || r.Min > r.Max {
+		return r, fmt.Errorf("Некорректный диапазон sid: %s", value)
+	}
+	return r, nil
+}
+
+// validate проверяет значения фильтра, которые задаются вручную.
+func (f ExportFilter) validate() error {
+	for _, id := range f.IDs {
+		if _, err := parseIDRange(id); err != nil {
+			return err
+		}
+	}
+	for _, pair := range f.Metadata {
+		if key, _, ok := strings.Cut(pair, "="); !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("Некорректное условие metadata %s, ожидается ключ=значение", pair)
+		}
+	}
+	for _, date := range []string{f.CreatedSince, f.UpdatedSince} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("Некорректная дата %s, ожидается YYYY-MM-DD", date)
+		}
+	}
+	if f.EngineVersion != "" && !validVersion(f.EngineVersion) {
+		return fmt.Errorf("Некорректная версия движка: %s", f.EngineVersion)
+	}
+	return nil
+}
+
+// where строит условие WHERE и аргументы запроса для фильтра: действующие включённые правила.
+// Фильтр должен быть проверен методом validate.
+func (f ExportFilter) where() (string, []interface{}) {
+	conds, args := f.conditions(nil)
+	conds = append([]string{"deleted_at IS NULL", "COALESCE(enabled_override, enabled, TRUE)"}, conds...)
+	return strings.Join(conds, " AND "), args
+}
+
+// conditions возвращает условия фильтра без отбора по состоянию правила; номера
+// параметров продолжают args.
+func (f ExportFilter) conditions(args []interface{}) ([]string, []interface{}) {
+	return f.tableConditions("signatures", args)
+}
+
+// tableConditions возвращает условия фильтра для строк signatures под именем table
+// (signatures или псевдоним во вложенном запросе).
+func (f ExportFilter) tableConditions(table string, args []interface{}) ([]string, []interface{}) {
+	var conds []string
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	col := func(name string) string {
+		return table + "." + name
+	}
+
+	if len(f.Sources) > 0 {
+		conds = append(conds, fmt.Sprintf("%s = ANY(%s)", col("source"), arg(pq.Array(f.Sources))))
+	}
+	if len(f.IDs) > 0 {
+		var ranges []string
+		for _, id := range f.IDs {
+			r, _ := parseIDRange(id)
+			cond := fmt.Sprintf("CASE WHEN %[1]s ~ '^[0-9]+$' THEN %[1]s::NUMERIC END BETWEEN %s AND %s", col("sid"), arg(r.Min), arg(r.Max))
+			if r.GID != 0 {
+				cond = fmt.Sprintf("%s = %s AND %s", tableGIDExpr(table), arg(strconv.Itoa(r.GID)), cond)
+			}
+			ranges = append(ranges, "("+cond+")")
+		}
+		conds = append(conds, "("+strings.Join(ranges, " OR ")+")")
+	}
+	if len(f.Classtypes) > 0 {
+		conds = append(conds, fmt.Sprintf(`EXISTS (
+            SELECT 1 FROM jsonb_array_elements(COALESCE(%s, '[]'::JSONB)) o
+            WHERE o->>'name' = 'classtype' AND o->>'value' = ANY(%s)
+        )`, col("options"), arg(pq.Array(f.Classtypes))))
+	}
+	if len(f.Severities) > 0 {
+		severities := make([]string, len(f.Severities))
+		for i, s := range f.Severities {
+			severities[i] = strings.ToLower(s)
+		}
+		conds = append(conds, fmt.Sprintf("lower(%s) = ANY(%s)", col("signature_severity"), arg(pq.Array(severities))))
+	}
+	if len(f.Policies) > 0 {
+		conds = append(conds, fmt.Sprintf("%s && %s::TEXT[]", col("policies"), arg(pq.Array(f.Policies))))
+	}
+	if len(f.Protocols) > 0 {
+		protocols := make([]string, len(f.Protocols))
+		for i, p := range f.Protocols {
+			protocols[i] = strings.ToLower(p)
+		}
+		conds = append(conds, fmt.Sprintf("lower(%s) = ANY(%s)", col("proto"), arg(pq.Array(protocols))))
+	}
+	if len(f.Filenames) > 0 {
+		patterns := make([]string, len(f.Filenames))
+		for i, p := range f.Filenames {
+			patterns[i] = likePattern(p)
+		}
+		conds = append(conds, fmt.Sprintf("%s LIKE ANY(%s)", col("filename"), arg(pq.Array(patterns))))
+	}
+	if len(f.Tags) > 0 {
+		tags := arg(pq.Array(f.Tags))
+		conds = append(conds, fmt.Sprintf("(COALESCE(%s->'tag', '[]'::JSONB) ?| %s::TEXT[] OR %s && %s::TEXT[])", col("metadata"), tags, col("tags"), tags))
+	}
+	if len(f.Metadata) > 0 {
+		var pairs []string
+		for _, pair := range f.Metadata {
+			key, value, _ := strings.Cut(pair, "=")
+			doc, _ := json.Marshal(map[string][]string{strings.ToLower(strings.TrimSpace(key)): {strings.TrimSpace(value)}})
+			pairs = append(pairs, fmt.Sprintf("%s @> %s::JSONB", col("metadata"), arg(string(doc))))
+		}
+		conds = append(conds, "("+strings.Join(pairs, " OR ")+")")
+	}
+	if f.CreatedSince != "" {
+		conds = append(conds, fmt.Sprintf("%s >= %s", col("rule_created_at"), arg(f.CreatedSince)))
+	}
+	if f.UpdatedSince != "" {
+		conds = append(conds, fmt.Sprintf("%s >= %s", col("rule_updated_at"), arg(f.UpdatedSince)))
+	}
+
+	if f.EngineVersion != "" {
+		conds = append(conds, fmt.Sprintf(
+			"(%[1]s IS NULL OR string_to_array(%[1]s, '.')::INT[] <= string_to_array(%[2]s, '.')::INT[])",
+			col("min_engine_version"), arg(normalizeVersion(f.EngineVersion))))
+	}
+
+	if f.CollapseDuplicates {
+		// Правило скрывается только дублем, который сам проходит фильтр: иначе при отборе
+		// по источнику правило пропало бы вместе с дублем из исключённого источника.
+		inner := f
+		inner.CollapseDuplicates = false
+		var dconds []string
+		dconds, args = inner.tableConditions("d", args)
+		dconds = append([]string{"d.deleted_at IS NULL", "COALESCE(d.enabled_override, d.enabled, TRUE)"}, dconds...)
+		conds = append(conds, fmt.Sprintf(`NOT EXISTS (
+            SELECT 1 FROM signatures d
+            WHERE d.fingerprint = %[1]s.fingerprint
+              AND d.source IS DISTINCT FROM %[1]s.source
+              AND (COALESCE(d.local, FALSE), -d.id) > (COALESCE(%[1]s.local, FALSE), -%[1]s.id)
+              AND %[2]s
+        )`, table, strings.Join(dconds, " AND ")))
+	}
+
+	return conds, args
+}
+
+// tableGIDExpr - выражение gidExpr для строк signatures под именем table.
+func tableGIDExpr(table string) string {
+	return strings.Replace(gidExpr, "signatures.options", table+".options", 1)
+}
+
+// likePattern переводит шаблон с * и ? в шаблон LIKE.
+func likePattern(glob string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `*`, `%`, `?`, `_`)
+	return r.Replace(glob)
+}
+
+// String описывает фильтр в виде ключ=значение, как он записывается в заголовок выгрузки.
+func (f ExportFilter) String() string {
+	var parts []string
+	list := func(key string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, key+"="+strings.Join(values, ","))
+		}
+	}
+	list("source", f.Sources)
+	list("sid", f.IDs)
+	list("classtype", f.Classtypes)
+	list("severity", f.Severities)
+	list("policy", f.Policies)
+	list("proto", f.Protocols)
+	list("filename", f.Filenames)
+	list("tag", f.Tags)
+	list("metadata", f.Metadata)
+	if f.CreatedSince != "" {
+		parts = append(parts, "created_since="+f.CreatedSince)
+	}
+	if f.UpdatedSince != "" {
+		parts = append(parts, "updated_since="+f.UpdatedSince)
+	}
+	if f.CollapseDuplicates {
+		parts = append(parts, "collapse_duplicates")
+	}
+	if f.EngineVersion != "" {
+		parts = append(parts, "engine_version="+f.EngineVersion)
+	}
+	if len(parts) == 0 {
+		return "все сигнатуры"
+	}
+	return strings.Join(parts, " ")
+}
+
+// splitList разбирает список значений через запятую из аргумента командной строки.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestParseIDRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    IDRange
+		wantErr bool
+	}{
+		{"9000001", IDRange{Min: 9000001, Max: 9000001}, false},
+		{"2000000-2099999", IDRange{Min: 2000000, Max: 2099999}, false},
+		{" 100 - 200 ", IDRange{Min: 100, Max: 200}, false},
+		{"1:2000000-2099999", IDRange{GID: 1, Min: 2000000, Max: 2099999}, false},
+		{"3:100", IDRange{GID: 3, Min: 100, Max: 100}, false},
+		{"200-100", IDRange{}, true},
+		{"abc", IDRange{}, true},
+		{"100-", IDRange{}, true},
+		{"0:100", IDRange{}, true},
+		{"x:100", IDRange{}, true},
+		{"-5", IDRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseIDRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIDRange(%q): ошибка %v", tt.value, err)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseIDRange(%q) = %+v, ожидалось %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExportFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  ExportFilter
+		wantErr string
+	}{
+		{"empty", ExportFilter{}, ""},
+		{"valid", ExportFilter{IDs: []string{"1:100-200", "9000001"}, Metadata: []string{"deployment=Perimeter", "tag="},
+			CreatedSince: "2024-01-31", UpdatedSince: "2024-02-29", EngineVersion: "7.0.3"}, ""},
+		{"bad sid range", ExportFilter{IDs: []string{"100", "200-100"}}, "Некорректный диапазон sid"},
+		{"bad gid", ExportFilter{IDs: []string{"0:100"}}, "Некорректный gid"},
+		{"metadata without =", ExportFilter{Metadata: []string{"deployment"}}, "Некорректное условие metadata"},
+		{"metadata without key", ExportFilter{Metadata: []string{" =Perimeter"}}, "Некорректное условие metadata"},
+		{"date format", ExportFilter{CreatedSince: "2024_01_31"}, "Некорректная дата 2024_01_31"},
+		{"impossible date", ExportFilter{UpdatedSince: "2023-02-29"}, "Некорректная дата 2023-02-29"},
+		{"engine version", ExportFilter{EngineVersion: "seven"}, "Некорректная версия движка"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.filter.validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ошибка %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExportFilterWhere(t *testing.T) {
+	f := ExportFilter{
+		IDs:          []string{"2000000-2099999", "3:100"},
+		Filenames:    []string{"emerging-*.rules", "local_?.rules"},
+		Metadata:     []string{"Deployment = Perimeter", "former_category=MALWARE"},
+		CreatedSince: "2024-01-31",
+	}
+	where, args := f.where()
+	for _, part := range []string{
+		"deleted_at IS NULL AND COALESCE(enabled_override, enabled, TRUE) AND ",
+		"(CASE WHEN signatures.sid ~ '^[0-9]+$' THEN signatures.sid::NUMERIC END BETWEEN $1 AND $2)",
+		"= $5 AND CASE WHEN signatures.sid ~ '^[0-9]+$' THEN signatures.sid::NUMERIC END BETWEEN $3 AND $4)",
+		"signatures.filename LIKE ANY($6)",
+		"(signatures.metadata @> $7::JSONB OR signatures.metadata @> $8::JSONB)",
+		"signatures.rule_created_at >= $9",
+	} {
+		if !strings.Contains(where, part) {
+			t.Errorf("в условии нет %q:\n%s", part, where)
+		}
+	}
+	if len(args) != 9 {
+		t.Fatalf("параметров %d, ожидалось 9: %v", len(args), args)
+	}
+	want := []interface{}{uint64(2000000), uint64(2099999), uint64(100), uint64(100), "3"}
+	if !reflect.DeepEqual(args[:5], want) {
+		t.Errorf("параметры диапазонов %#v, ожидалось %#v", args[:5], want)
+	}
+	if patterns := pq.Array([]string{"emerging-%.rules", `local\__.rules`}); !reflect.DeepEqual(args[5], patterns) {
+		t.Errorf("шаблоны имени файла %v", args[5])
+	}
+	// Ключ metadata приводится к нижнему регистру, пробелы вокруг = отбрасываются.
+	if args[6] != `{"deployment":["Perimeter"]}` || args[7] != `{"former_category":["MALWARE"]}` {
+		t.Errorf("условия metadata %v, %v", args[6], args[7])
+	}
+	if args[8] != "2024-01-31" {
+		t.Errorf("дата %v", args[8])
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := map[string]string{
+		"emerging-*.rules": "emerging-%.rules",
+		"local_?.rules":    `local\__.rules`,
+		"100%.rules":       `100\%.rules`,
+		`a\b`:              `a\\b`,
+	}
+	for glob, want := range tests {
+		if got := likePattern(glob); got != want {
+			t.Errorf("likePattern(%q) = %q, ожидалось %q", glob, got, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Опции, которые не влияют на логику обнаружения и не входят в отпечаток правила.
+var fingerprintIgnored = keywordSet(`msg sid rev gid metadata reference classtype priority`)
+
+// Модификаторы относятся к предыдущему content/pcre и сортируются внутри его группы.
+var contentModifiers = keywordSet(`nocase rawbytes depth offset distance within fast_pattern startswith endswith replace`)
+
+// Опции с полезной нагрузкой: их порядок значим, поэтому группы не переставляются.
+var payloadKeywords = keywordSet(`content uricontent pcre byte_test byte_jump byte_extract byte_math
+isdataat base64_decode base64_data file_data pkt_data`)
+
+// DuplicateGroup - набор сигнатур с одинаковым отпечатком.
+type DuplicateGroup struct {
+	Fingerprint string          `json:"fingerprint"`
+	Signatures  []DuplicateItem `json:"signatures"`
+}
+
+type DuplicateItem struct {
+	Source  string `json:"source"`
+	SID     string `json:"sid"`
+	Msg     string `json:"msg"`
+	Enabled bool   `json:"enabled"`
+}
+
+// ruleFingerprint возвращает SHA-256 канонической формы правила.
+func ruleFingerprint(rule *Rule) string {
+	sum := sha256.Sum256([]byte(normalizeRule(rule)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeRule приводит правило к канонической форме: без msg, sid, rev и metadata,
+// с отсортированными опциями вне полезной нагрузки и модификаторами внутри групп content.
+// Модификатор буфера Snort 2 (http_uri после content) входит в группу content, sticky-буфер
+// Snort 3 - нет.
+func normalizeRule(rule *Rule) string {
+	var general, payload, group []string
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		sort.Strings(group[1:])
+		payload = append(payload, strings.Join(group, "; "))
+		group = nil
+	}
+
+	for _, opt := range rule.Options {
+		if fingerprintIgnored[opt.Name] {
+			continue
+		}
+		text := normalizeOption(opt)
+		switch {
+		case rule.Dialect == DialectSnort3 && snort3StickyBuffers[opt.Name]:
+			// В Snort 3 HTTP-буфер стоит перед content и относится к следующим группам.
+			flush()
+			payload = append(payload, text)
+		case len(group) > 0 && (contentModifiers[opt.Name] || (strings.HasPrefix(opt.Name, "http_") && opt.Value == "")):
+			group = append(group, text)
+		case payloadKeywords[opt.Name] || strings.HasPrefix(opt.Name, "byte_") ||
+			(strings.Contains(opt.Name, ".") && !strings.HasPrefix(opt.Name, "flow.")):
+			flush()
+			group = []string{text}
+		default:
+			general = append(general, text)
+		}
+	}
+	flush()
+	sort.Strings(general)
+
+	header := strings.Join([]string{
+		rule.Action, strings.ToLower(rule.Proto),
+		normalizeAddress(rule.SrcIP), normalizeAddress(rule.SrcPort), rule.Direction,
+		normalizeAddress(rule.DstIP), normalizeAddress(rule.DstPort),
+	}, " ")
+	return header + " (" + strings.Join(payload, " | ") + " || " + strings.Join(general, "; ") + ")"
+}
+
+// normalizeOption убирает незначимые пробелы и приводит hex-последовательности content к нижнему регистру.
+func normalizeOption(opt RuleOption) string {
+	value := opt.Value
+	switch opt.Name {
+	case "content", "uricontent":
+		value = normalizeContent(value)
+	case "flow":
+		parts := strings.Split(value, ",")
+		for i := range parts {
+			parts[i] = strings.ToLower(strings.TrimSpace(parts[i]))
+		}
+		sort.Strings(parts)
+		value = strings.Join(parts, ",")
+	default:
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if value == "" {
+		return opt.Name
+	}
+	return opt.Name + ":" + value
+}
+
+func normalizeContent(value string) string {
+	var b strings.Builder
+	inHex := false
+	for _, r := range value {
+		if r == '|' {
+			inHex = !inHex
+		}
+		if inHex {
+			if r == ' ' {
+				continue
+			}
+			if r >= 'A' && r <= 'F' {
+				r += 'a' - 'A'
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// normalizeAddress убирает пробелы в списках адресов и портов и сортирует их элементы.
+func normalizeAddress(value string) string {
+	value = strings.Join(strings.Fields(value), "")
+	negated := strings.HasPrefix(value, "!")
+	inner := strings.TrimPrefix(value, "!")
+	if !strings.HasPrefix(inner, "[") || !strings.HasSuffix(inner, "]") {
+		return value
+	}
+
+	var items []string
+	depth, start := 0, 1
+	for i := 1; i < len(inner)-1; i++ {
+		switch inner[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, normalizeAddress(inner[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	items = append(items, normalizeAddress(inner[start:len(inner)-1]))
+	sort.Strings(items)
+
+	result := "[" + strings.Join(items, ",") + "]"
+	if negated {
+		result = "!" + result
+	}
+	return result
+}
+
+// findDuplicates возвращает группы неудалённых сигнатур с одинаковым отпечатком.
+// При crossSource в отчёт попадают только группы из нескольких источников.
+func findDuplicates(db *sql.DB, crossSource bool) ([]DuplicateGroup, error) {
+	having := "COUNT(*) > 1"
+	if crossSource {
+		having = "COUNT(DISTINCT COALESCE(source, '')) > 1"
+	}
+	rows, err := db.Query(`
+        SELECT fingerprint, COALESCE(source, ''), sid, COALESCE(msg, ''), COALESCE(enabled_override, enabled, TRUE)
+        FROM signatures
+        WHERE deleted_at IS NULL AND fingerprint IN (
+            SELECT fingerprint FROM signatures
+            WHERE deleted_at IS NULL AND fingerprint IS NOT NULL
+            GROUP BY fingerprint
+            HAVING ` + having + `
+        )
+        ORDER BY fingerprint, id
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var fingerprint string
+		var item DuplicateItem
+		if err := rows.Scan(&fingerprint, &item.Source, &item.SID, &item.Msg, &item.Enabled); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Fingerprint != fingerprint {
+			groups = append(groups, DuplicateGroup{Fingerprint: fingerprint})
+		}
+		last := &groups[len(groups)-1]
+		last.Signatures = append(last.Signatures, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return groups, nil
+}
+
+// refreshFingerprints пересчитывает отпечатки по сохранённым опциям, например после
+// изменения правил нормализации или для сигнатур, загруженных до появления отпечатков.
+func refreshFingerprints(db *sql.DB) (int, error) {
+	updates := map[string]string{}
+	err := querySignatures(db, "deleted_at IS NULL AND options != '[]'::JSONB", nil, func(sig Signature) error {
+		if fingerprint := ruleFingerprint(sig.rule()); fingerprint != sig.Fingerprint {
+			updates[sig.SID] = fingerprint
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for sid, fingerprint := range updates {
+		if _, err := db.Exec(`UPDATE signatures SET fingerprint = $1 WHERE sid = $2`, fingerprint, sid); err != nil {
+			return 0, fmt.Errorf("Ошибка обновления отпечатка (SID: %s): %v", sid, err)
+		}
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func fingerprintOf(t *testing.T, text, dialect string) string {
+	t.Helper()
+	rule, err := parseRule(text)
+	if err != nil {
+		t.Fatalf("parseRule(%s): %v", text, err)
+	}
+	rule.Dialect = dialect
+	return ruleFingerprint(rule)
+}
+
+func TestRuleFingerprintSame(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"ignored options",
+			`alert tcp any any -> any 80 (msg:"a"; content:"x"; sid:1; rev:1; classtype:trojan-activity; metadata:tag a;)`,
+			`alert tcp any any -> any 80 (msg:"b"; content:"x"; sid:2; rev:7; reference:url,example.com;)`},
+		{"general options order",
+			`alert tcp any any -> any any (flow:established,to_server; dsize:>10; content:"x"; sid:1;)`,
+			`alert tcp any any -> any any (dsize:>10; content:"x"; flow:to_server, established; sid:2;)`},
+		{"modifiers order",
+			`alert tcp any any -> any any (content:"x"; nocase; depth:4; sid:1;)`,
+			`alert tcp any any -> any any (content:"x"; depth:4; nocase; sid:2;)`},
+		{"hex case and spaces",
+			`alert tcp any any -> any any (content:"|0D 0A|Host"; sid:1;)`,
+			`alert tcp any any -> any any (content:"|0d0a|Host"; sid:2;)`},
+		{"address lists",
+			`alert tcp [10.0.0.0/8, 192.168.0.0/16] any -> any [443,80] (content:"x"; sid:1;)`,
+			`alert tcp [192.168.0.0/16,10.0.0.0/8] any -> any [80,443] (content:"x"; sid:2;)`},
+		{"proto case",
+			`alert TCP any any -> any any (content:"x"; sid:1;)`,
+			`alert tcp any any -> any any (content:"x"; sid:2;)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fingerprintOf(t, tt.a, DialectSuricata) != fingerprintOf(t, tt.b, DialectSuricata) {
+				t.Errorf("отпечатки различаются:\n%s\n%s", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestRuleFingerprintDifferent(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"content order",
+			`alert tcp any any -> any any (content:"a"; content:"b"; sid:1;)`,
+			`alert tcp any any -> any any (content:"b"; content:"a"; sid:1;)`},
+		{"modifier of other content",
+			`alert tcp any any -> any any (content:"a"; nocase; content:"b"; sid:1;)`,
+			`alert tcp any any -> any any (content:"a"; content:"b"; nocase; sid:1;)`},
+		{"action",
+			`alert tcp any any -> any any (content:"a"; sid:1;)`,
+			`drop tcp any any -> any any (content:"a"; sid:1;)`},
+		{"direction",
+			`alert tcp any any -> any any (content:"a"; sid:1;)`,
+			`alert tcp any any <> any any (content:"a"; sid:1;)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if fingerprintOf(t, tt.a, DialectSuricata) == fingerprintOf(t, tt.b, DialectSuricata) {
+				t.Errorf("отпечатки совпадают:\n%s\n%s", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestNormalizeRuleBuffers(t *testing.T) {
+	// Модификатор Snort 2 относится к предыдущему content.
+	rule, _ := parseRule(`alert tcp any any -> any 80 (content:"a"; http_uri; content:"b"; sid:1;)`)
+	rule.Dialect = DialectSnort2
+	if got := normalizeRule(rule); !strings.Contains(got, `content:"a"; http_uri | content:"b"`) {
+		t.Errorf("snort2: %s", got)
+	}
+
+	// Sticky-буфер Snort 3 относится к следующему content.
+	rule, _ = parseRule(`alert http (content:"a"; http_uri; content:"b"; sid:1;)`)
+	rule.Dialect = DialectSnort3
+	if got := normalizeRule(rule); !strings.Contains(got, `content:"a" | http_uri | content:"b"`) {
+		t.Errorf("snort3: %s", got)
+	}
+	rule, _ = parseRule(`alert http (http_header:field host; content:"a"; sid:1;)`)
+	rule.Dialect = DialectSnort3
+	if got := normalizeRule(rule); !strings.Contains(got, `(http_header:field host | content:"a" || )`) {
+		t.Errorf("snort3 с параметром: %s", got)
+	}
+}
+
+func TestCollapseDuplicatesUsesFilter(t *testing.T) {
+	f := ExportFilter{Sources: []string{"ET Open"}, Classtypes: []string{"trojan-activity"}, IDs: []string{"3:100-200"}, CollapseDuplicates: true}
+	conds, args := f.conditions(nil)
+	collapse := conds[len(conds)-1]
+	for _, part := range []string{
+		"d.fingerprint = signatures.fingerprint",
+		"d.source = ANY($6)",
+		"COALESCE(d.options, '[]'::JSONB)",
+		"jsonb_array_elements(COALESCE(d.options",
+		"d.deleted_at IS NULL",
+	} {
+		if !strings.Contains(collapse, part) {
+			t.Errorf("в условии дублей нет %q:\n%s", part, collapse)
+		}
+	}
+	if strings.Contains(collapse, "signatures.options") || strings.Contains(collapse, " source = ANY") {
+		t.Errorf("условие дублей ссылается на внешнюю строку:\n%s", collapse)
+	}
+	// Условия внешней строки и дубля используют свои параметры.
+	if len(args) != 10 {
+		t.Errorf("параметров %d, ожидалось 10", len(args))
+	}
+}
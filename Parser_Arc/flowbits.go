@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flowbit - одна операция опции flowbits: set, isset, noalert...
+type Flowbit struct {
+	Cmd  string `json:"cmd"`
+	Name string `json:"name,omitempty"`
+}
+
+// FlowbitIssue - проблема в зависимостях flowbits конкретного правила.
+type FlowbitIssue struct {
+	SID  string
+	Name string
+}
+
+// parseFlowbits извлекает операции flowbits правила. Группы вида a|b и a&b
+// раскладываются на отдельные имена.
+func parseFlowbits(rule *Rule) []Flowbit {
+	var bits []Flowbit
+	for _, value := range rule.Values("flowbits") {
+		parts := strings.Split(value, ",")
+		cmd := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) < 2 {
+			bits = append(bits, Flowbit{Cmd: cmd})
+			continue
+		}
+		names := strings.FieldsFunc(parts[1], func(r rune) bool { return r == '|' || r == '&' })
+		for _, name := range names {
+			bits = append(bits, Flowbit{Cmd: cmd, Name: strings.TrimSpace(name)})
+		}
+	}
+	return bits
+}
+
+func isFlowbitSetter(cmd string) bool {
+	return cmd == "set" || cmd == "setx" || cmd == "toggle"
+}
+
+// flowbitGraph - граф зависимостей между правилами через flowbits:
+// правило с isset,X зависит от всех правил с set,X.
+type flowbitGraph struct {
+	bits    map[string][]Flowbit
+	enabled map[string]bool
+	setters map[string][]string // имя бита -> sid правил, устанавливающих его
+	checks  map[string][]string // имя бита -> sid правил с isset
+}
+
+func newFlowbitGraph() *flowbitGraph {
+	return &flowbitGraph{
+		bits:    map[string][]Flowbit{},
+		enabled: map[string]bool{},
+		setters: map[string][]string{},
+		checks:  map[string][]string{},
+	}
+}
+
+func (g *flowbitGraph) add(sid string, enabled bool, bits []Flowbit) {
+	g.bits[sid] = bits
+	g.enabled[sid] = enabled
+	for _, bit := range bits {
+		switch {
+		case isFlowbitSetter(bit.Cmd):
+			g.setters[bit.Name] = append(g.setters[bit.Name], sid)
+		case bit.Cmd == "isset":
+			g.checks[bit.Name] = append(g.checks[bit.Name], sid)
+		}
+	}
+}
+
+// loadFlowbitGraph строит граф по всем неудалённым сигнатурам из БД.
+func loadFlowbitGraph(db *sql.DB) (*flowbitGraph, error) {
+	rows, err := db.Query(`
+        SELECT sid, COALESCE(enabled_override, enabled, TRUE), flowbits
+        FROM signatures
+        WHERE deleted_at IS NULL AND flowbits != '[]'::JSONB
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	g := newFlowbitGraph()
+	for rows.Next() {
+		var sid string
+		var enabled bool
+		var raw []byte
+		if err := rows.Scan(&sid, &enabled, &raw); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		var bits []Flowbit
+		if err := json.Unmarshal(raw, &bits); err != nil {
+			return nil, fmt.Errorf("Ошибка разбора flowbits (SID: %s): %v", sid, err)
+		}
+		g.add(sid, enabled, bits)
+	}
+	return g, rows.Err()
+}
+
+// hasEnabledSetter проверяет, устанавливает ли бит хотя бы одно включённое правило.
+func (g *flowbitGraph) hasEnabledSetter(name string, except map[string]bool) bool {
+	for _, sid := range g.setters[name] {
+		if g.enabled[sid] && !except[sid] {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredSetters возвращает отключённые правила, которые нужно включить,
+// чтобы сработали проверки isset правила sid, с учётом их собственных зависимостей.
+func (g *flowbitGraph) requiredSetters(sid string) []string {
+	required := map[string]bool{}
+	queue := []string{sid}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, bit := range g.bits[current] {
+			if bit.Cmd != "isset" || g.hasEnabledSetter(bit.Name, nil) {
+				continue
+			}
+			for _, setter := range g.setters[bit.Name] {
+				if !required[setter] && setter != sid {
+					required[setter] = true
+					queue = append(queue, setter)
+				}
+			}
+		}
+	}
+	return sortedKeys(required)
+}
+
+// brokenBy возвращает проверки isset включённых правил, которые останутся
+// без установщика после отключения правил disabled.
+func (g *flowbitGraph) brokenBy(disabled []string) []FlowbitIssue {
+	except := map[string]bool{}
+	for _, sid := range disabled {
+		except[sid] = true
+	}
+	var issues []FlowbitIssue
+	for name, checks := range g.checks {
+		if !g.hasEnabledSetter(name, nil) || g.hasEnabledSetter(name, except) {
+			continue
+		}
+		for _, sid := range checks {
+			if g.enabled[sid] && !except[sid] {
+				issues = append(issues, FlowbitIssue{SID: sid, Name: name})
+			}
+		}
+	}
+	sortIssues(issues)
+	return issues
+}
+
+// orphanedChecks возвращает включённые правила с isset на бит,
+// который не устанавливает ни одно включённое правило.
+func (g *flowbitGraph) orphanedChecks() []FlowbitIssue {
+	var issues []FlowbitIssue
+	for name, checks := range g.checks {
+		if g.hasEnabledSetter(name, nil) {
+			continue
+		}
+		for _, sid := range checks {
+			if g.enabled[sid] {
+				issues = append(issues, FlowbitIssue{SID: sid, Name: name})
+			}
+		}
+	}
+	sortIssues(issues)
+	return issues
+}
+
+// unusedNoalert возвращает включённые правила с noalert, биты которых
+// не проверяет ни одно включённое правило: такие правила только нагружают сенсор.
+func (g *flowbitGraph) unusedNoalert() []FlowbitIssue {
+	var issues []FlowbitIssue
+	for sid, bits := range g.bits {
+		if !g.enabled[sid] || !hasFlowbitCmd(bits, "noalert") {
+			continue
+		}
+		for _, bit := range bits {
+			if !isFlowbitSetter(bit.Cmd) || g.hasEnabledCheck(bit.Name) {
+				continue
+			}
+			issues = append(issues, FlowbitIssue{SID: sid, Name: bit.Name})
+		}
+	}
+	sortIssues(issues)
+	return issues
+}
+
+func (g *flowbitGraph) hasEnabledCheck(name string) bool {
+	for _, sid := range g.checks[name] {
+		if g.enabled[sid] {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFlowbitCmd(bits []Flowbit, cmd string) bool {
+	for _, bit := range bits {
+		if bit.Cmd == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func sortIssues(issues []FlowbitIssue) {
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].SID != issues[j].SID {
+			return issues[i].SID < issues[j].SID
+		}
+		return issues[i].Name < issues[j].Name
+	})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
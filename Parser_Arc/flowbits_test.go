@@ -0,0 +1,125 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testFlowbitGraph строит граф по правилам: sid, включено ли правило, опции flowbits.
+func testFlowbitGraph(t *testing.T, rules ...interface{}) *flowbitGraph {
+	t.Helper()
+	g := newFlowbitGraph()
+	for i := 0; i+2 < len(rules); i += 3 {
+		sid, enabled := rules[i].(string), rules[i+1].(bool)
+		rule, err := parseRule(`alert tcp any any -> any any (msg:"x"; ` + rules[i+2].(string) + ` sid:` + sid + `;)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.add(sid, enabled, parseFlowbits(rule))
+	}
+	return g
+}
+
+func TestParseFlowbits(t *testing.T) {
+	rule, err := parseRule(`alert tcp any any -> any any (msg:"x"; flowbits:isset,a|b; flowbits:set, c&d; flowbits:NoAlert; sid:1;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Flowbit{{"isset", "a"}, {"isset", "b"}, {"set", "c"}, {"set", "d"}, {"noalert", ""}}
+	if got := parseFlowbits(rule); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFlowbits = %+v, ожидалось %+v", got, want)
+	}
+}
+
+func TestRequiredSetters(t *testing.T) {
+	g := testFlowbitGraph(t,
+		"1", false, "flowbits:isset,login;",
+		"2", false, "flowbits:set,login; flowbits:isset,session;",
+		"3", false, "flowbits:set,session;",
+		"4", true, "flowbits:set,enabled_bit;",
+		"5", false, "flowbits:isset,enabled_bit;",
+		"6", false, "flowbits:setx,cycle; flowbits:isset,cycle;",
+		"7", false, "flowbits:isset,nobody_sets;",
+	)
+	tests := []struct {
+		sid  string
+		want []string
+	}{
+		// Зависимости установщиков тоже нужны.
+		{"1", []string{"2", "3"}},
+		{"3", []string{}},
+		// Бит уже устанавливает включённое правило.
+		{"5", []string{}},
+		// Правило не считается своей зависимостью.
+		{"6", []string{}},
+		{"7", []string{}},
+	}
+	for _, tt := range tests {
+		if got := g.requiredSetters(tt.sid); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requiredSetters(%s) = %v, ожидалось %v", tt.sid, got, tt.want)
+		}
+	}
+}
+
+func TestBrokenBy(t *testing.T) {
+	g := testFlowbitGraph(t,
+		"1", true, "flowbits:set,a;",
+		"2", true, "flowbits:set,a;",
+		"3", true, "flowbits:isset,a;",
+		"4", true, "flowbits:set,b;",
+		"5", true, "flowbits:isset,b;",
+		"6", false, "flowbits:isset,b;",
+		"7", true, "flowbits:set,c; flowbits:isset,c;",
+	)
+	tests := []struct {
+		disabled []string
+		want     []FlowbitIssue
+	}{
+		// Бит a остаётся у правила 2.
+		{[]string{"1"}, nil},
+		{[]string{"1", "2"}, []FlowbitIssue{{"3", "a"}}},
+		// Отключённое правило 6 не считается.
+		{[]string{"4"}, []FlowbitIssue{{"5", "b"}}},
+		// Само отключаемое правило не считается.
+		{[]string{"4", "5"}, nil},
+		{[]string{"7"}, nil},
+	}
+	for _, tt := range tests {
+		if got := g.brokenBy(tt.disabled); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("brokenBy(%v) = %+v, ожидалось %+v", tt.disabled, got, tt.want)
+		}
+	}
+}
+
+func TestOrphanedChecks(t *testing.T) {
+	g := testFlowbitGraph(t,
+		"1", true, "flowbits:isset,a;",
+		"2", false, "flowbits:set,a;",
+		"3", true, "flowbits:isset,b;",
+		"4", true, "flowbits:toggle,b;",
+		"5", false, "flowbits:isset,c;",
+		"6", true, "flowbits:isset,d|e;",
+		"7", true, "flowbits:set,d;",
+	)
+	want := []FlowbitIssue{{"1", "a"}, {"6", "e"}}
+	if got := g.orphanedChecks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("orphanedChecks = %+v, ожидалось %+v", got, want)
+	}
+}
+
+func TestUnusedNoalert(t *testing.T) {
+	g := testFlowbitGraph(t,
+		"1", true, "flowbits:set,a; flowbits:noalert;",
+		"2", false, "flowbits:isset,a;",
+		"3", true, "flowbits:set,b; flowbits:noalert;",
+		"4", true, "flowbits:isset,b;",
+		"5", false, "flowbits:set,c; flowbits:noalert;",
+		"6", true, "flowbits:set,d;",
+		"7", true, "flowbits:set,e; flowbits:set,f; flowbits:noalert;",
+		"8", true, "flowbits:isset,f;",
+	)
+	want := []FlowbitIssue{{"1", "a"}, {"7", "e"}}
+	if got := g.unusedNoalert(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unusedNoalert = %+v, ожидалось %+v", got, want)
+	}
+}
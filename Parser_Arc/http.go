@@ -1,16 +1,14 @@
+//go:build http
+
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"database/sql"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
-	"strings"
 	"time"
 	"crypto/tls"
 
@@ -37,18 +35,6 @@ type SourceConfig struct {
 	URL  string `mapstructure:"url"`
 }
 
-type Signature struct {
-	Type     string
-	Proto    string
-	SrcIP    string
-	SrcPort  string
-	DstIP    string
-	DstPort  string
-	SID      string
-	Msg      string
-	Filename string
-}
-
 var config Config
 
 func main() {
@@ -78,7 +64,7 @@ func main() {
 				continue
 			}
 
-			if err := processArchive(db, localFile, source.Name); err != nil {
+			if err := processArchive(db, localFile, source.Name, DialectSuricata, false); err != nil {
 				log.Printf("Ошибка обработки архива для источника %s: %v", source.Name, err)
 			}
 		}
@@ -113,28 +99,6 @@ func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
 	return sql.Open("postgres", connStr)
 }
 
-func initDB(db *sql.DB) error {
-	query := `
-CREATE TABLE IF NOT EXISTS signatures (
-    id SERIAL PRIMARY KEY,
-    type TEXT,
-    proto TEXT,
-    src_ip TEXT,
-    src_port TEXT,
-    dst_ip TEXT,
-    dst_port TEXT,
-    sid TEXT UNIQUE,
-    msg TEXT,
-    filename TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT NULL,
-    deleted_at TIMESTAMP DEFAULT NULL
-);
-`
-	_, err := db.Exec(query)
-	return err
-}
-
 func downloadFileFromHTTP(url, localFile string) error {
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
@@ -167,121 +131,3 @@ func downloadFileFromHTTP(url, localFile string) error {
 	log.Printf("Файл успешно загружен по URL: %s", localFile)
 	return nil
 }
-
-func processArchive(db *sql.DB, archive string, sourceName string) error {
-	file, err := os.Open(archive)
-	if err != nil {
-		return fmt.Errorf("Ошибка открытия архива: %v", err)
-	}
-	defer file.Close()
-
-	gzr, err := gzip.NewReader(file)
-	if err != nil {
-		return fmt.Errorf("Ошибка открытия GZIP: %v", err)
-	}
-	defer gzr.Close()
-
-	tarReader := tar.NewReader(gzr)
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Ошибка чтения TAR: %v", err)
-			continue
-		}
-
-		if header.Typeflag == tar.TypeReg {
-			log.Printf("Обработка файла: %s", header.Name)
-			if err := parseFile(db, tarReader, header.Name, sourceName); err != nil {
-				log.Printf("Ошибка обработки файла %s: %v", header.Name, err)
-			}
-		}
-	}
-	return nil
-}
-
-func parseFile(db *sql.DB, reader io.Reader, filename string, sourceName string) error {
-	re := regexp.MustCompile(`(?is)alert (\w+) (\[.*?\]|\S+) any -> (\[.*?\]|\S+) any .*?msg:"(.*?)";.*?sid:(\d+);`)
-	buf := new(strings.Builder)
-
-	_, err := io.Copy(buf, reader)
-	if err != nil {
-		return fmt.Errorf("Ошибка чтения содержимого файла: %v", err)
-	}
-
-	content := buf.String()
-	content = strings.ReplaceAll(content, "\n", " ") // Убираем переносы строк
-
-	matches := re.FindAllStringSubmatch(content, -1)
-	log.Printf("Найдено совпадений: %d", len(matches))
-	if len(matches) == 0 {
-		log.Printf("Файл %s не содержит подходящих сигнатур", filename)
-		return nil
-	}
-
-	for _, match := range matches {
-		if len(match) < 6 {
-			log.Printf("Некорректное совпадение: %v", match)
-			continue
-		}
-
-		// Убираем дублирующийся массив IP из сообщения
-		msg := match[4]
-		if strings.Contains(msg, "ip [") {
-			start := strings.Index(msg, "ip [")
-			end := strings.Index(msg[start:], "]") + start + 1
-			msg = msg[:start] + msg[end:]
-		}
-
-		// Создаём структуру сигнатуры.
-		sig := Signature{
-			Type:     match[1],
-			Proto:    match[2],
-			SrcIP:    match[3],
-			SrcPort:  "", // Пока не заполняем, так как это будет заполнено при импорте FTP
-			DstIP:    match[4],
-			DstPort:  "", // Пока не заполняем, так как это будет заполнено при импорте FTP
-			Msg:      msg,
-			SID:      match[5],
-			Filename: filename,
-		}
-
-		// Сохраняем в базу данных
-		if err := saveToDB(db, sig); err != nil {
-			log.Printf("Ошибка сохранения записи (SID: %s): %v", sig.SID, err)
-			continue
-		}
-	}
-	return nil
-}
-
-func saveToDB(db *sql.DB, sig Signature) error {
-	query := `
-INSERT INTO signatures (type, proto, src_ip, src_port, dst_ip, dst_port, sid, msg, filename, updated_at)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, CURRENT_TIMESTAMP)
-ON CONFLICT (sid) DO UPDATE SET 
-    type = EXCLUDED.type,
-    proto = EXCLUDED.proto,
-    src_ip = EXCLUDED.src_ip,
-    src_port = EXCLUDED.src_port,
-    dst_ip = EXCLUDED.dst_ip,
-    dst_port = EXCLUDED.dst_port,
-    msg = EXCLUDED.msg,
-    filename = EXCLUDED.filename,
-    updated_at = CURRENT_TIMESTAMP
-WHERE signatures.sid = EXCLUDED.sid AND (
-    signatures.type != EXCLUDED.type OR
-    signatures.proto != EXCLUDED.proto OR
-    signatures.src_ip != EXCLUDED.src_ip OR
-    signatures.src_port != EXCLUDED.src_port OR
-    signatures.dst_ip != EXCLUDED.dst_ip OR
-    signatures.dst_port != EXCLUDED.dst_port OR
-    signatures.msg != EXCLUDED.msg OR
-    signatures.filename != EXCLUDED.filename
-);
-`
-	_, err := db.Exec(query, sig.Type, sig.Proto, sig.SrcIP, sig.SrcPort, sig.DstIP, sig.DstPort, sig.SID, sig.Msg, sig.Filename)
-	return err
-}
\ No newline at end of file
@@ -0,0 +1,114 @@
+//go:build import
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB DBConfig `mapstructure:"db"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+var config Config
+
+func main() {
+	initLog()
+
+	source := flag.String("source", "", "Имя источника, под которым сохраняются правила")
+	dialect := flag.String("dialect", DialectDionis, "Формат файлов: dionis, suricata или snort2")
+	overwrite := flag.Bool("overwrite", false, "Заменять правила других источников с тем же sid")
+	flag.Parse()
+
+	if *source == "" || flag.NArg() == 0 {
+		fmt.Println("Использование: go run -tags import . -source ИМЯ [-dialect dionis] [-overwrite] ФАЙЛ...")
+		os.Exit(2)
+	}
+	switch *dialect {
+	case DialectDionis, DialectSuricata, DialectSnort2, DialectSnort3:
+	default:
+		log.Fatalf("Неизвестный формат файлов: %s", *dialect)
+	}
+
+	log.Println("=== Старт импорта правил ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	// Файлы .tar.gz обрабатываются как архивы, остальные - как отдельные файлы правил.
+	for _, path := range flag.Args() {
+		log.Printf("Обработка файла: %s", path)
+		if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+			err = processArchive(db, path, *source, *dialect, *overwrite)
+		} else {
+			err = importFile(db, path, *source, *dialect, *overwrite)
+		}
+		if err != nil {
+			log.Printf("Ошибка импорта файла %s: %v", path, err)
+		}
+	}
+
+	log.Println("=== Завершение импорта правил ===")
+}
+
+func importFile(db *sql.DB, path, source, dialect string, overwrite bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия файла: %v", err)
+	}
+	defer file.Close()
+	return parseFile(db, file, filepath.Base(path), source, dialect, overwrite)
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало импорта правил ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Форматы списков IP-адресов.
+const (
+	FeedIPList = "iplist" // по одному адресу или подсети в строке
+	FeedIPRep  = "iprep"  // файл репутации Suricata: адрес,категория,оценка
+)
+
+// Indicator - адрес или подсеть из списка блокировки или файла репутации.
+type Indicator struct {
+	Address  string // IP или CIDR
+	Category string
+	Score    int // -1, если в списке нет оценки
+}
+
+// parseIPList разбирает построчный список адресов. Комментарии начинаются с # или ;,
+// после адреса в строке может идти произвольный текст.
+func parseIPList(content, category string) ([]Indicator, int) {
+	var indicators []Indicator
+	invalid := 0
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := feedLine(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		if len(fields) == 0 {
+			invalid++
+			continue
+		}
+		address, ok := normalizeIP(fields[0])
+		if !ok {
+			invalid++
+			continue
+		}
+		indicators = append(indicators, Indicator{Address: address, Category: category, Score: -1})
+	}
+	return indicators, invalid
+}
+
+// parseIPRep разбирает файл репутации Suricata (строки адрес,категория,оценка).
+// categories сопоставляет номер категории с коротким именем из файла категорий.
+func parseIPRep(content string, categories map[string]string) ([]Indicator, int) {
+	var indicators []Indicator
+	invalid := 0
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := feedLine(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			invalid++
+			continue
+		}
+		address, ok := normalizeIP(strings.TrimSpace(fields[0]))
+		score, err := strconv.Atoi(strings.TrimSpace(fields[2]))
+		if !ok || err != nil || score < 0 || score > 127 {
+			invalid++
+			continue
+		}
+		category := strings.TrimSpace(fields[1])
+		if name, ok := categories[category]; ok {
+			category = name
+		}
+		indicators = append(indicators, Indicator{Address: address, Category: category, Score: score})
+	}
+	return indicators, invalid
+}
+
+// parseIPRepCategories разбирает файл категорий Suricata (строки номер,имя,описание).
+func parseIPRepCategories(content string) map[string]string {
+	categories := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.SplitN(feedLine(scanner.Text()), ",", 3)
+		if len(fields) >= 2 {
+			categories[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+		}
+	}
+	return categories
+}
+
+func feedLine(line string) string {
+	if i := strings.IndexAny(line, "#;"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// normalizeIP проверяет адрес или подсеть и приводит их к каноническому виду.
+func normalizeIP(value string) (string, bool) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return "", false
+		}
+		return network.String(), true
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// saveIndicators сохраняет индикаторы источника: новые добавляются, для известных
+// обновляется last_seen, а пропавшие из списка помечаются истёкшими.
+// Пустой список не обрабатывается, чтобы сбой загрузки не снял все блокировки.
+func saveIndicators(db *sql.DB, source string, indicators []Indicator) error {
+	if len(indicators) == 0 {
+		return fmt.Errorf("Список источника %s пуст, индикаторы не обновлены", source)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	// CURRENT_TIMESTAMP одинаков в пределах транзакции, поэтому все индикаторы
+	// загрузки получают один last_seen, а остальные считаются пропавшими.
+	stmt, err := tx.Prepare(`
+        INSERT INTO ip_indicators (source, address, category, score, first_seen, last_seen)
+        VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+        ON CONFLICT (source, address, category) DO UPDATE SET
+            score = EXCLUDED.score,
+            last_seen = CURRENT_TIMESTAMP,
+            expired_at = NULL
+    `)
+	if err != nil {
+		return fmt.Errorf("Ошибка подготовки запроса: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, ind := range indicators {
+		if _, err := stmt.Exec(source, ind.Address, ind.Category, nullIfNegative(ind.Score)); err != nil {
+			return fmt.Errorf("Ошибка сохранения индикатора %s: %v", ind.Address, err)
+		}
+	}
+
+	res, err := tx.Exec(`
+        UPDATE ip_indicators SET expired_at = CURRENT_TIMESTAMP
+        WHERE source = $1 AND expired_at IS NULL AND last_seen < CURRENT_TIMESTAMP
+    `, source)
+	if err != nil {
+		return fmt.Errorf("Ошибка пометки истёкших индикаторов: %v", err)
+	}
+	expired, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	log.Printf("Источник %s: индикаторов в списке %d, истекло %d", source, len(indicators), expired)
+	return nil
+}
+
+// nullIfNegative превращает отрицательное значение в NULL при записи в БД.
+func nullIfNegative(n int) interface{} {
+	if n < 0 {
+		return nil
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.17/24", "192.0.2.0/24", true},
+		{"10.0.0.0/8", "10.0.0.0/8", true},
+		{"2001:DB8::1", "2001:db8::1", true},
+		{"2001:db8:0:0::/32", "2001:db8::/32", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.256", "", false},
+		{"192.0.2.0/33", "", false},
+		{"$HOME_NET", "", false},
+		{"any", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeIP(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeIP(%q) = %q, %v, ожидалось %q, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseIPList(t *testing.T) {
+	content := "# blocklist\n192.0.2.1\n198.51.100.0/24 ; scanner\n203.0.113.5,extra\nnot-an-ip\n\n2001:db8::1 # v6\n"
+	indicators, invalid := parseIPList(content, "scanner")
+	want := []Indicator{
+		{Address: "192.0.2.1", Category: "scanner", Score: -1},
+		{Address: "198.51.100.0/24", Category: "scanner", Score: -1},
+		{Address: "203.0.113.5", Category: "scanner", Score: -1},
+		{Address: "2001:db8::1", Category: "scanner", Score: -1},
+	}
+	if !reflect.DeepEqual(indicators, want) || invalid != 1 {
+		t.Errorf("parseIPList = %+v, %d, ожидалось %+v, 1", indicators, invalid, want)
+	}
+}
+
+func TestParseIPListSeparatorsOnly(t *testing.T) {
+	indicators, invalid := parseIPList(",\n1.2.3.4\n , \t\n", "c")
+	want := []Indicator{{Address: "1.2.3.4", Category: "c", Score: -1}}
+	if !reflect.DeepEqual(indicators, want) || invalid != 2 {
+		t.Errorf("parseIPList = %+v, %d, ожидалось %+v, 2", indicators, invalid, want)
+	}
+}
+
+func TestParseIPRep(t *testing.T) {
+	categories := parseIPRepCategories("1,CnC,Command and control\n2,Scanner,Hosts scanning")
+	content := "192.0.2.1,1,100\n198.51.100.0/24, 2, 20\n203.0.113.5,3,50\n192.0.2.2,1,200\n192.0.2.3,1\n"
+	indicators, invalid := parseIPRep(content, categories)
+	want := []Indicator{
+		{Address: "192.0.2.1", Category: "CnC", Score: 100},
+		{Address: "198.51.100.0/24", Category: "Scanner", Score: 20},
+		{Address: "203.0.113.5", Category: "3", Score: 50},
+	}
+	if !reflect.DeepEqual(indicators, want) || invalid != 2 {
+		t.Errorf("parseIPRep = %+v, %d, ожидалось %+v, 2", indicators, invalid, want)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// processArchive загружает правила архива источника и записывает хэш архива.
+// dialect - диалект правил источника (см. applyDialect) или dionis для записей Dionis-NX,
+// overwrite - перезаписывать ли правила других источников с тем же sid (см. parseFile).
+func processArchive(db *sql.DB, archive string, sourceName string, dialect string, overwrite bool) error {
+	// Хэш архива нужен снимкам выгрузок; ошибка записи не мешает загрузке правил.
+	if err := recordArchive(db, archive, sourceName); err != nil {
+		log.Printf("Ошибка записи хэша архива %s: %v", archive, err)
+	}
+	return walkArchive(archive, func(name string, r io.Reader) error {
+		return parseFile(db, r, name, sourceName, dialect, overwrite)
+	})
+}
+
+// walkArchive вызывает fn для каждого обычного файла архива .tar.gz.
+// Ошибки fn записываются в лог и не прерывают обход.
+func walkArchive(archive string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия архива: %v", err)
+	}
+	defer file.Close()
+
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия GZIP: %v", err)
+	}
+	defer gzr.Close()
+
+	tarReader := tar.NewReader(gzr)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Ошибка чтения TAR: %v", err)
+			continue
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			log.Printf("Обработка файла: %s", header.Name)
+			if err := fn(header.Name, tarReader); err != nil {
+				log.Printf("Ошибка обработки файла %s: %v", header.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseFile загружает правила файла. Правило, sid которого занят действующим правилом
+// другого источника, без overwrite не сохраняется, с overwrite - заменяет его;
+// в обоих случаях совпадение записывается в лог.
+func parseFile(db *sql.DB, reader io.Reader, filename string, sourceName string, dialect string, overwrite bool) error {
+	buf := new(strings.Builder)
+
+	_, err := io.Copy(buf, reader)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения содержимого файла: %v", err)
+	}
+
+	var rules []*Rule
+	var errs []error
+	if dialect == DialectDionis {
+		rules, errs = parseDionisRules(buf.String())
+	} else {
+		rules, errs = parseRules(buf.String())
+		for _, rule := range rules {
+			applyDialect(rule, dialect)
+		}
+	}
+	for _, err := range errs {
+		log.Printf("Ошибка разбора правила в файле %s: %v", filename, err)
+	}
+	log.Printf("Найдено правил: %d", len(rules))
+	if len(rules) == 0 {
+		log.Printf("Файл %s не содержит подходящих сигнатур", filename)
+		return nil
+	}
+
+	foreign, err := foreignSIDs(db, rules, sourceName)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		sig, err := signatureFromRule(rule, filename, sourceName)
+		if err != nil {
+			log.Printf("Некорректное правило: %v", err)
+			continue
+		}
+
+		if owner, ok := foreign[sig.SID]; ok {
+			if !overwrite {
+				log.Printf("Правило %s (SID: %s) отклонено: sid занят правилом источника %q", filename, sig.SID, owner)
+				continue
+			}
+			log.Printf("Правило %s (SID: %s) заменяет правило источника %q", filename, sig.SID, owner)
+		}
+
+		if err := saveToDB(db, sig); err != nil {
+			log.Printf("Ошибка сохранения записи (SID: %s): %v", sig.SID, err)
+			continue
+		}
+	}
+	return nil
+}
+
+// foreignSIDs возвращает sid правил, которые заняты действующими правилами поставщиков
+// из других источников, и имена этих источников.
+func foreignSIDs(db *sql.DB, rules []*Rule, sourceName string) (map[string]string, error) {
+	var sids []string
+	for _, rule := range rules {
+		if sid, ok := rule.Option("sid"); ok {
+			sids = append(sids, sid)
+		}
+	}
+	rows, err := db.Query(`
+        SELECT sid, COALESCE(source, '') FROM signatures
+        WHERE sid = ANY($1) AND deleted_at IS NULL AND NOT COALESCE(local, FALSE)
+    `, pq.Array(sids))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	owners := map[string]string{}
+	for rows.Next() {
+		var sid, source string
+		if err := rows.Scan(&sid, &source); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		owners[sid] = source
+	}
+	return foreignOwners(owners, sourceName), rows.Err()
+}
+
+// foreignOwners оставляет из владельцев sid другие источники. Сигнатуры без источника,
+// загруженные до появления столбца source, ничьи: их обновляет любой источник.
+func foreignOwners(owners map[string]string, sourceName string) map[string]string {
+	foreign := map[string]string{}
+	for sid, source := range owners {
+		if source != "" && source != sourceName {
+			foreign[sid] = source
+		}
+	}
+	return foreign
+}
+
+// saveToDB добавляет или обновляет сигнатуру. Правила поставщиков не перезаписывают
+// локальные правила с тем же sid, и наоборот.
+func saveToDB(db *sql.DB, sig Signature) error {
+	metadata, err := json.Marshal(sig.Metadata.Pairs)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации metadata: %v", err)
+	}
+	flowbits := []byte("[]")
+	if len(sig.Flowbits) > 0 {
+		if flowbits, err = json.Marshal(sig.Flowbits); err != nil {
+			return fmt.Errorf("Ошибка сериализации flowbits: %v", err)
+		}
+	}
+	options, err := json.Marshal(sig.Options)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации опций: %v", err)
+	}
+
+	src, dst := parseHeaderNetworks(sig.SrcIP), parseHeaderNetworks(sig.DstIP)
+
+	// Добавленное или изменённое правило записывается в историю сигнатуры.
+	query := `
+WITH saved AS (
+INSERT INTO signatures (type, proto, src_ip, src_port, dst_ip, dst_port, sid, msg, filename,
+    signature_severity, policies, rule_created_at, rule_updated_at, mitre_techniques, deployment, metadata,
+    enabled, flowbits, direction, options, source, fingerprint, local, dialect, min_engine_version,
+    src_nets, src_nets_negated, dst_nets, dst_nets_negated, rule_text, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25,
+    $27::CIDR[], $28::CIDR[], $29::CIDR[], $30::CIDR[], $31, CURRENT_TIMESTAMP)
+ON CONFLICT (sid) DO UPDATE SET
+    type = EXCLUDED.type,
+    proto = EXCLUDED.proto,
+    src_ip = EXCLUDED.src_ip,
+    src_port = EXCLUDED.src_port,
+    dst_ip = EXCLUDED.dst_ip,
+    dst_port = EXCLUDED.dst_port,
+    msg = EXCLUDED.msg,
+    filename = EXCLUDED.filename,
+    signature_severity = EXCLUDED.signature_severity,
+    policies = EXCLUDED.policies,
+    rule_created_at = EXCLUDED.rule_created_at,
+    rule_updated_at = EXCLUDED.rule_updated_at,
+    mitre_techniques = EXCLUDED.mitre_techniques,
+    deployment = EXCLUDED.deployment,
+    metadata = EXCLUDED.metadata,
+    enabled = EXCLUDED.enabled,
+    flowbits = EXCLUDED.flowbits,
+    direction = EXCLUDED.direction,
+    options = EXCLUDED.options,
+    source = EXCLUDED.source,
+    fingerprint = EXCLUDED.fingerprint,
+    dialect = EXCLUDED.dialect,
+    min_engine_version = EXCLUDED.min_engine_version,
+    src_nets = EXCLUDED.src_nets,
+    src_nets_negated = EXCLUDED.src_nets_negated,
+    dst_nets = EXCLUDED.dst_nets,
+    dst_nets_negated = EXCLUDED.dst_nets_negated,
+    rule_text = EXCLUDED.rule_text,
+    deleted_at = NULL,
+    updated_at = CURRENT_TIMESTAMP
+WHERE signatures.sid = EXCLUDED.sid AND COALESCE(signatures.local, FALSE) = EXCLUDED.local AND (
+    signatures.deleted_at IS NOT NULL OR
+    signatures.type != EXCLUDED.type OR
+    signatures.proto != EXCLUDED.proto OR
+    signatures.src_ip != EXCLUDED.src_ip OR
+    signatures.src_port != EXCLUDED.src_port OR
+    signatures.dst_ip != EXCLUDED.dst_ip OR
+    signatures.dst_port != EXCLUDED.dst_port OR
+    signatures.msg != EXCLUDED.msg OR
+    signatures.filename != EXCLUDED.filename OR
+    signatures.metadata IS DISTINCT FROM EXCLUDED.metadata OR
+    signatures.enabled IS DISTINCT FROM EXCLUDED.enabled OR
+    signatures.flowbits IS DISTINCT FROM EXCLUDED.flowbits OR
+    signatures.direction IS DISTINCT FROM EXCLUDED.direction OR
+    signatures.options IS DISTINCT FROM EXCLUDED.options OR
+    signatures.source IS DISTINCT FROM EXCLUDED.source OR
+    signatures.fingerprint IS DISTINCT FROM EXCLUDED.fingerprint OR
+    signatures.dialect IS DISTINCT FROM EXCLUDED.dialect OR
+    signatures.min_engine_version IS DISTINCT FROM EXCLUDED.min_engine_version
+)
+RETURNING sid, xmax = 0 AS inserted
+)
+INSERT INTO signature_history (sid, event, actor, details)
+SELECT sid, CASE WHEN inserted THEN 'created' ELSE 'updated' END, $21, $26 FROM saved;
+`
+	res, err := db.Exec(query, sig.Type, sig.Proto, sig.SrcIP, sig.SrcPort, sig.DstIP, sig.DstPort, sig.SID, sig.Msg, sig.Filename,
+		nullIfEmpty(sig.Metadata.Severity), textArray(sig.Metadata.Policies),
+		nullIfEmpty(sig.Metadata.CreatedAt), nullIfEmpty(sig.Metadata.UpdatedAt),
+		textArray(sig.Metadata.Mitre), textArray(sig.Metadata.Deployment), string(metadata),
+		sig.Enabled, string(flowbits), sig.Direction, string(options),
+		sig.Source, sig.Fingerprint, sig.Local, nullIfEmpty(sig.Dialect), nullIfEmpty(sig.MinVersion), sig.rule().String(),
+		textArray(src.Nets), textArray(src.Negated), textArray(dst.Nets), textArray(dst.Negated), nullIfEmpty(sig.Raw))
+	if err != nil {
+		return err
+	}
+	// Строка истории добавляется только для сохранённого правила: тогда обновляются и его content и ссылки.
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if saved == 0 {
+		// Неизменённым правилам, загруженным до появления rule_text, сохраняется исходный текст.
+		_, err := db.Exec(`UPDATE signatures SET rule_text = $2 WHERE sid = $1 AND rule_text IS NULL AND COALESCE(local, FALSE) = $3`,
+			sig.SID, nullIfEmpty(sig.Raw), sig.Local)
+		return err
+	}
+	if err := saveRuleContents(db, sig.SID, ruleContents(sig.rule())); err != nil {
+		return err
+	}
+	return saveReferences(db, sig.SID, catalogEntry(sig).References)
+}
+
+// textArray передаёт срез строк в столбец TEXT[]; nil записывается как пустой массив.
+func textArray(list []string) interface{} {
+	if list == nil {
+		list = []string{}
+	}
+	return pq.Array(list)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForeignOwners(t *testing.T) {
+	owners := map[string]string{
+		"1": "ET Open", // то же правило этого источника
+		"2": "Snort",   // правило другого источника
+		"3": "",        // сигнатура, загруженная до появления столбца source
+	}
+	got := foreignOwners(owners, "ET Open")
+	want := map[string]string{"2": "Snort"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("foreignOwners = %v, ожидалось %v", got, want)
+	}
+	if got := foreignOwners(map[string]string{"3": ""}, ""); len(got) != 0 {
+		t.Errorf("сигнатура без источника занята: %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import "strings"
+
+// Ключевые слова правил, которые понимает каждый движок.
+// Используются линтером для поиска опций, которые движок не загрузит.
+
+var suricataKeywords = keywordSet(`
+msg sid rev gid classtype reference priority metadata target requires
+content uricontent nocase depth offset distance within startswith endswith rawbytes
+isdataat bsize dsize byte_test byte_math byte_jump byte_extract rpc replace pcre
+fast_pattern prefilter base64_decode base64_data lua luajit xor pcrexform
+to_md5 to_sha1 to_sha256 to_lowercase to_uppercase strip_whitespace compress_whitespace
+url_decode dotprefix header_lowercase strip_pseudo_headers from_base64 entropy
+flow flowbits flowint xbits hostbits stream_size flow.age flow.pkts flow.bytes
+ttl ipopts sameip ip_proto ipv4.hdr ipv6.hdr id geoip fragbits fragoffset tos
+flags tcp.flags seq ack window tcp.mss tcp.hdr udp.hdr
+itype icode icmp_id icmp_seq icmpv4.hdr icmpv6.hdr icmpv6.mtu
+threshold detection_filter noalert tag config pkt_data bypass iprep datarep dataset
+app-layer-protocol app-layer-event decode-event stream-event engine-event asn1
+file_data file.data filename file.name fileext filemagic file.magic filemd5 filesha1
+filesha256 filesize filestore
+http_uri http.uri http_raw_uri http.uri.raw http_method http.method
+http_header http.header http_raw_header http.header.raw http_cookie http.cookie
+http_user_agent http.user_agent http_host http.host http_raw_host http.host.raw
+http_accept http.accept http_accept_lang http.accept_lang http_accept_enc http.accept_enc
+http_referer http.referer http_connection http.connection
+http_content_type http.content_type http_content_len http.content_len
+http_start http.start http_protocol http.protocol http_header_names http.header_names
+http_request_line http.request_line http_response_line http.response_line
+http_server_body http.response_body http_client_body http.request_body
+http_stat_code http.stat_code http_stat_msg http.stat_msg http.location http.server
+http.request_header http.response_header urilen
+http2.frametype http2.errorcode http2.priority http2.window http2.size_update
+http2.settings http2.header_name http2.header
+dns_query dns.query dns.opcode dns.answer.name dns.query.name
+tls.sni tls_sni tls.cert_subject tls.cert_issuer tls.cert_serial tls.cert_fingerprint
+tls.certs tls.cert_chain_len tls_cert_notbefore tls_cert_notafter tls_cert_expired
+tls_cert_valid tls.version tls.subject tls.issuerdn tls.fingerprint tls.store
+tls.random tls.random_time tls.random_bytes ssl_state ssl_version
+ja3.hash ja3.string ja3s.hash ja3s.string ja3_hash ja3_string ja4.hash
+ssh.proto ssh_proto ssh.software ssh_software ssh.protoversion ssh.softwareversion
+ssh.hassh ssh.hassh.string ssh.hassh.server ssh.hassh.server.string
+smb.named_pipe smb.share smb.ntlmssp_user smb.ntlmssp_domain smb.version
+dcerpc.iface dcerpc.opnum dcerpc.stub_data dce_iface dce_opnum dce_stub_data
+krb5_msg_type krb5_cname krb5_sname krb5_err_code krb5.weak_encryption krb5.ticket_encryption
+snmp.version snmp.community snmp.pdu_type snmp.usm
+sip.method sip.uri sip.request_line sip.stat_code sip.stat_msg sip.response_line sip.protocol
+rfb.name rfb.sectype rfb.secresult
+mqtt.type mqtt.flags mqtt.qos mqtt.reason_code mqtt.connack.session_present
+mqtt.connect.clientid mqtt.connect.flags mqtt.connect.password mqtt.connect.username
+mqtt.connect.willmessage mqtt.connect.willtopic mqtt.protocol_version
+mqtt.publish.message mqtt.publish.topic mqtt.subscribe.topic mqtt.unsubscribe.topic
+modbus dnp3_func dnp3_ind dnp3_obj dnp3_data dnp3.func dnp3.ind dnp3.obj dnp3.data
+enip_command cip_service ftpdata_command ftpbounce
+ike.init_spi ike.resp_spi ike.chosen_sa_attribute ike.exchtype ike.vendor
+ike.key_exchange_payload ike.key_exchange_payload_length ike.nonce_payload ike.nonce_payload_length
+nfs_procedure nfs.version
+quic.cyu.hash quic.cyu.string quic.version quic.sni quic.ua
+frame nfq_set_mark
+`)
+
+var snortKeywords = keywordSet(`
+msg reference gid sid rev classtype priority metadata
+content protected_content hash length rawbytes uricontent urilen nocase depth offset
+distance within http_client_body http_cookie http_raw_cookie http_header http_raw_header
+http_method http_uri http_raw_uri http_stat_code http_stat_msg http_encode fast_pattern
+isdataat pcre pkt_data file_data base64_decode base64_data byte_test byte_jump
+byte_extract byte_math ftpbounce asn1 cvs dce_iface dce_opnum dce_stub_data
+sip_method sip_stat_code sip_header sip_body gtp_type gtp_info gtp_version
+ssl_version ssl_state fragoffset ttl tos id ipopts fragbits dsize flags flow flowbits
+seq ack window itype icode icmp_id icmp_seq rpc ip_proto sameip stream_reassemble
+stream_size logto session resp react tag activates activated_by count replace
+detection_filter threshold modbus_func modbus_unit modbus_data
+dnp3_func dnp3_ind dnp3_obj dnp3_data file_type sd_pattern appids
+`)
+
+// Snort 3: модификаторы content записываются внутри опции, но в общей модели
+// хранятся отдельными опциями, поэтому входят в набор.
+var snort3Keywords = keywordSet(`
+msg reference gid sid rev classtype priority metadata service rem tag
+content nocase depth offset distance within fast_pattern fast_pattern_offset fast_pattern_length
+pcre regex bufferlen isdataat byte_test byte_jump byte_extract byte_math base64_decode base64_data
+pkt_data raw_data file_data js_data vba_data sd_pattern hash md5 sha256 sha512 replace
+http_uri http_raw_uri http_header http_raw_header http_cookie http_raw_cookie
+http_client_body http_raw_body http_method http_stat_code http_stat_msg http_version
+http_raw_request http_raw_status http_trailer http_raw_trailer http_true_ip
+http_param http_version_match http_num_headers http_num_trailers http_num_cookies
+http_max_header_line http_max_trailer_line
+http2_frame_header script_data
+flow flowbits dsize ttl tos id ipopts fragbits fragoffset flags seq ack window
+itype icode icmp_id icmp_seq ip_proto sameip stream_size stream_reassemble rpc
+detection_filter asn1 cvs ber_data ber_skip
+dce_iface dce_opnum dce_stub_data sip_method sip_stat_code sip_header sip_body
+gtp_type gtp_info gtp_version ssl_version ssl_state modbus_func modbus_unit modbus_data
+dnp3_func dnp3_ind dnp3_obj dnp3_data cip_attribute cip_class cip_conn_path_class
+cip_instance cip_req cip_rsp cip_service cip_status enip_command enip_req enip_rsp
+iec104_apci_type iec104_asdu_func s7commplus_content s7commplus_func s7commplus_opcode
+mms_data mms_func ssl_alert ssl_client_hello
+file_type file_meta appids
+`)
+
+// engineKeywords возвращает набор ключевых слов движка или nil для неизвестного движка.
+func engineKeywords(engine string) map[string]bool {
+	switch engine {
+	case "suricata":
+		return suricataKeywords
+	case "snort":
+		return snortKeywords
+	case "snort3":
+		return snort3Keywords
+	}
+	return nil
+}
+
+func keywordSet(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, kw := range strings.Fields(list) {
+		set[kw] = true
+	}
+	return set
+}
@@ -0,0 +1,230 @@
+//go:build lint
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB   DBConfig   `mapstructure:"db"`
+	Lint LintConfig `mapstructure:"lint"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+type LintConfig struct {
+	Engine string   `mapstructure:"engine"`
+	Vars   []string `mapstructure:"vars"`
+	FailOn string   `mapstructure:"fail_on"`
+}
+
+var config Config
+
+func main() {
+	initLog()
+
+	log.Println("=== Старт проверки сигнатур ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	if config.Lint.Engine == "" {
+		config.Lint.Engine = "suricata"
+	}
+	if config.Lint.FailOn == "" {
+		config.Lint.FailOn = LintError
+	}
+
+	engine := flag.String("engine", config.Lint.Engine, "Целевой движок: suricata, snort или snort3")
+	format := flag.String("format", "text", "Формат отчёта: text или json")
+	failOn := flag.String("fail-on", config.Lint.FailOn, "Завершиться с ошибкой при замечаниях этого уровня и выше: info, warning, error")
+	flag.Parse()
+
+	if _, ok := lintLevels[*failOn]; !ok {
+		log.Fatalf("Неизвестный уровень: %s", *failOn)
+	}
+
+	l, err := newLinter(*engine, config.Lint.Vars)
+	if err != nil {
+		log.Fatalf("Ошибка: %v", err)
+	}
+
+	// Без аргументов проверяются сигнатуры из БД, иначе - указанные файлы .rules и архивы .tar.gz,
+	// sid которых сверяются с сигнатурами из БД.
+	if flag.NArg() == 0 {
+		err = lintStored(l)
+	} else {
+		loadStored(l)
+		err = lintFiles(l, flag.Args())
+	}
+	if err != nil {
+		log.Fatalf("Ошибка проверки: %v", err)
+	}
+
+	if err := printIssues(l, *format); err != nil {
+		log.Fatalf("Ошибка вывода отчёта: %v", err)
+	}
+
+	counts := l.counts()
+	log.Printf("Замечаний: error %d, warning %d, info %d", counts[LintError], counts[LintWarning], counts[LintInfo])
+	log.Println("=== Завершение проверки сигнатур ===")
+	if l.failed(*failOn) {
+		os.Exit(1)
+	}
+}
+
+func lintStored(l *linter) error {
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	checked, skipped, otherDialect := 0, 0, 0
+	err = querySignatures(db, "deleted_at IS NULL", nil, func(sig Signature) error {
+		// Сигнатуры, загруженные до появления столбца options, проверить нельзя.
+		if len(sig.Options) == 0 {
+			skipped++
+			return nil
+		}
+		// Правила другого движка (например, Snort 3 при проверке для Suricata) дали бы
+		// только ложные unknown-keyword.
+		if !l.matchesDialect(sig.Dialect) {
+			otherDialect++
+			return nil
+		}
+		l.lintRule(sig.rule(), sig.Filename)
+		checked++
+		return nil
+	})
+	log.Printf("Проверено сигнатур из БД: %d, без сохранённых опций: %d, другого диалекта: %d", checked, skipped, otherDialect)
+	return err
+}
+
+// loadStored загружает sid сигнатур из БД для проверки файлов. Без БД файлы
+// проверяются только между собой.
+func loadStored(l *linter) {
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Printf("Ошибка подключения к БД, sid не сверяются с БД: %v", err)
+		return
+	}
+	defer db.Close()
+
+	err = querySignatures(db, "deleted_at IS NULL", nil, func(sig Signature) error {
+		l.addStored(sig)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Ошибка чтения сигнатур, sid не сверяются с БД: %v", err)
+		return
+	}
+	log.Printf("Сигнатур в БД для сверки sid: %d", len(l.stored))
+}
+
+func lintFiles(l *linter, paths []string) error {
+	lintReader := func(name string, r io.Reader) error {
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("Ошибка чтения содержимого файла: %v", err)
+		}
+		rules, errs := parseRules(string(content))
+		for _, err := range errs {
+			l.parseError(name, err)
+		}
+		for _, rule := range rules {
+			applyDialect(rule, l.dialect())
+			l.lintFileRule(rule, name)
+		}
+		return nil
+	}
+
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+			if err := walkArchive(path, lintReader); err != nil {
+				return err
+			}
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Ошибка открытия файла: %v", err)
+		}
+		err = lintReader(path, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printIssues(l *linter, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		issues := l.Issues
+		if issues == nil {
+			issues = []LintIssue{}
+		}
+		return enc.Encode(map[string]interface{}{
+			"counts": l.counts(),
+			"issues": issues,
+		})
+	case "text":
+		for _, issue := range l.Issues {
+			location := issue.File
+			if issue.SID != "" {
+				location = fmt.Sprintf("%s sid:%s", location, issue.SID)
+			}
+			fmt.Printf("%-7s %-18s %s: %s\n", issue.Severity, issue.Check, strings.TrimSpace(location), issue.Message)
+		}
+		counts := l.counts()
+		fmt.Printf("Итого: error %d, warning %d, info %d\n", counts[LintError], counts[LintWarning], counts[LintInfo])
+		return nil
+	}
+	return fmt.Errorf("Неподдерживаемый формат отчёта: %s", format)
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало проверки сигнатур ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
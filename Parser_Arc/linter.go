@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Уровни важности замечаний линтера.
+const (
+	LintInfo    = "info"
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+var lintLevels = map[string]int{LintInfo: 0, LintWarning: 1, LintError: 2}
+
+// LintIssue - замечание линтера к правилу.
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	SID      string `json:"sid,omitempty"`
+	File     string `json:"file,omitempty"`
+	Message  string `json:"message"`
+}
+
+// defaultRuleVars - переменные, объявленные в стандартном suricata.yaml и snort.conf.
+var defaultRuleVars = []string{
+	"HOME_NET", "EXTERNAL_NET", "HTTP_SERVERS", "SMTP_SERVERS", "SQL_SERVERS",
+	"DNS_SERVERS", "TELNET_SERVERS", "AIM_SERVERS", "DC_SERVERS", "DNP3_SERVER",
+	"DNP3_CLIENT", "MODBUS_CLIENT", "MODBUS_SERVER", "ENIP_CLIENT", "ENIP_SERVER",
+	"SIP_SERVERS", "SNMP_SERVERS", "SSH_SERVERS", "FTP_SERVERS",
+	"HTTP_PORTS", "SHELLCODE_PORTS", "ORACLE_PORTS", "SSH_PORTS", "DNP3_PORTS",
+	"MODBUS_PORTS", "FILE_DATA_PORTS", "FTP_PORTS", "GENEVE_PORTS", "VXLAN_PORTS",
+	"TEREDO_PORTS", "SIP_PORTS", "GTP_PORTS",
+}
+
+var (
+	headerVarRe = regexp.MustCompile(`\$(\w+)`)
+	// Неограниченные повторения в pcre: .* .+ \S+ [^x]* и {n,}
+	unboundedPcreRe = regexp.MustCompile(`(\.|\\[SsWwDd]|\])[*+]|\{\d+,\}`)
+)
+
+// storedRule - сигнатура из БД, с которой сверяются sid проверяемых файлов.
+type storedRule struct {
+	Source      string
+	Filename    string
+	Fingerprint string
+}
+
+// linter проверяет правила и накапливает замечания.
+type linter struct {
+	engine   string
+	keywords map[string]bool
+	vars     map[string]bool
+	seen     map[string]string     // gid:sid -> файл первого вхождения
+	stored   map[string]storedRule // sid -> сигнатура из БД, см. addStored
+	Issues   []LintIssue
+}
+
+func newLinter(engine string, vars []string) (*linter, error) {
+	keywords := engineKeywords(engine)
+	if keywords == nil {
+		return nil, fmt.Errorf("Неизвестный движок: %s", engine)
+	}
+	if len(vars) == 0 {
+		vars = defaultRuleVars
+	}
+	defined := map[string]bool{}
+	for _, v := range vars {
+		defined[strings.TrimPrefix(v, "$")] = true
+	}
+	return &linter{engine: engine, keywords: keywords, vars: defined, seen: map[string]string{}, stored: map[string]storedRule{}}, nil
+}
+
+// addStored добавляет сигнатуру из БД для проверки файлов на совпадение sid.
+func (l *linter) addStored(sig Signature) {
+	l.stored[sig.SID] = storedRule{Source: sig.Source, Filename: sig.Filename, Fingerprint: sig.Fingerprint}
+}
+
+func (l *linter) report(severity, check, sid, file, format string, args ...interface{}) {
+	l.Issues = append(l.Issues, LintIssue{
+		Severity: severity,
+		Check:    check,
+		SID:      sid,
+		File:     file,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// parseError регистрирует правило, которое не удалось разобрать.
+func (l *linter) parseError(file string, err error) {
+	l.report(LintError, "malformed", "", file, "%v", err)
+}
+
+// lintRule выполняет проверки одного правила, не зависящие от других правил.
+// Так проверяются сигнатуры из БД, где sid уникален.
+func (l *linter) lintRule(rule *Rule, file string) {
+	sid, hasSID := rule.Option("sid")
+	switch {
+	case !hasSID || sid == "":
+		l.report(LintError, "missing-sid", "", file, "Правило без sid: %s", shorten(rule.Raw))
+	case !isNumber(sid):
+		l.report(LintError, "invalid-sid", sid, file, "Некорректный sid: %s", sid)
+	}
+
+	l.lintMsg(rule, sid, file)
+	l.lintKeywords(rule, sid, file)
+	l.lintContent(rule, sid, file)
+	l.lintVars(rule, sid, file)
+}
+
+// lintFileRule проверяет правило из файла: кроме проверок lintRule, sid сверяется с уже
+// проверенными файлами и с сигнатурами из БД.
+func (l *linter) lintFileRule(rule *Rule, file string) {
+	l.lintRule(rule, file)
+
+	sid, _ := rule.Option("sid")
+	if !isNumber(sid) {
+		return
+	}
+	gid, _ := rule.Option("gid")
+	if gid == "" {
+		gid = "1"
+	}
+	key := gid + ":" + sid
+	if first, ok := l.seen[key]; ok {
+		l.report(LintError, "duplicate-sid", sid, file, "sid %s уже встречался в %s", key, first)
+		return
+	}
+	l.seen[key] = file
+
+	// В БД sid уникален без учёта gid.
+	stored, ok := l.stored[sid]
+	switch {
+	case !ok:
+	case stored.Fingerprint == "":
+		l.report(LintInfo, "stored-sid", sid, file, "sid уже есть в БД (источник %s, файл %s)", stored.Source, stored.Filename)
+	case stored.Fingerprint == ruleFingerprint(rule):
+		l.report(LintInfo, "stored-sid", sid, file, "Правило уже загружено (источник %s, файл %s)", stored.Source, stored.Filename)
+	default:
+		l.report(LintWarning, "stored-sid", sid, file, "sid занят другим правилом в БД (источник %s, файл %s)", stored.Source, stored.Filename)
+	}
+}
+
+func (l *linter) lintMsg(rule *Rule, sid, file string) {
+	msg, ok := rule.Option("msg")
+	if !ok || msg == "" {
+		l.report(LintWarning, "missing-msg", sid, file, "Правило без msg")
+		return
+	}
+	if len(msg) < 2 || msg[0] != '"' || msg[len(msg)-1] != '"' {
+		l.report(LintError, "msg-escaping", sid, file, "msg не заключён в кавычки: %s", msg)
+		return
+	}
+	inner := msg[1 : len(msg)-1]
+	escaped := false
+	for _, r := range inner {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"' || r == ';':
+			l.report(LintError, "msg-escaping", sid, file, "Неэкранированный символ %q в msg: %s", r, msg)
+			return
+		}
+	}
+}
+
+func (l *linter) lintKeywords(rule *Rule, sid, file string) {
+	for _, opt := range rule.Options {
+		if !l.keywords[opt.Name] {
+			l.report(LintError, "unknown-keyword", sid, file, "Ключевое слово %s не поддерживается движком %s", opt.Name, l.engine)
+		}
+	}
+}
+
+func (l *linter) lintContent(rule *Rule, sid, file string) {
+	hasContent := len(rule.Values("content")) > 0 || len(rule.Values("uricontent")) > 0
+	if !hasContent {
+		l.report(LintWarning, "no-content", sid, file, "Правило без content проверяет каждый пакет")
+	}
+	for _, pcre := range rule.Values("pcre") {
+		switch {
+		case !hasContent:
+			l.report(LintError, "unbounded-pcre", sid, file, "pcre без content выполняется для каждого пакета: %s", pcre)
+		case unboundedPcreRe.MatchString(pcre):
+			l.report(LintWarning, "unbounded-pcre", sid, file, "pcre с неограниченным повторением: %s", pcre)
+		}
+	}
+}
+
+func (l *linter) lintVars(rule *Rule, sid, file string) {
+	for _, field := range []string{rule.SrcIP, rule.SrcPort, rule.DstIP, rule.DstPort} {
+		for _, m := range headerVarRe.FindAllStringSubmatch(field, -1) {
+			if !l.vars[m[1]] {
+				l.report(LintError, "undefined-variable", sid, file, "Переменная $%s не объявлена", m[1])
+			}
+		}
+	}
+}
+
+// dialect возвращает диалект правил, которые проверяются для движка линтера.
+func (l *linter) dialect() string {
+	switch l.engine {
+	case "snort", "snort3":
+		return DialectSnort2
+	}
+	return DialectSuricata
+}
+
+// matchesDialect сообщает, проверяются ли сигнатуры диалекта dialect по ключевым словам
+// движка линтера: Suricata - движком suricata, Snort 2 - движками snort и snort3 (экспорт
+// переводит их в Snort 3), Snort 3 - только движком snort3. Сигнатуры без диалекта,
+// загруженные до появления столбца dialect, проверяются любым движком.
+func (l *linter) matchesDialect(dialect string) bool {
+	switch dialect {
+	case "":
+		return true
+	case DialectSnort3:
+		return l.engine == "snort3"
+	}
+	return dialect == l.dialect()
+}
+
+// failed проверяет, есть ли замечания уровня threshold и выше.
+func (l *linter) failed(threshold string) bool {
+	for _, issue := range l.Issues {
+		if lintLevels[issue.Severity] >= lintLevels[threshold] {
+			return true
+		}
+	}
+	return false
+}
+
+// counts возвращает количество замечаний по уровням.
+func (l *linter) counts() map[string]int {
+	counts := map[string]int{LintInfo: 0, LintWarning: 0, LintError: 0}
+	for _, issue := range l.Issues {
+		counts[issue.Severity]++
+	}
+	return counts
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+func shorten(s string) string {
+	if r := []rune(s); len(r) > 80 {
+		return string(r[:80]) + "..."
+	}
+	return s
+}
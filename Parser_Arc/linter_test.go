@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// lintChecks возвращает замечания в виде "уровень check" в порядке сортировки.
+func lintChecks(l *linter) []string {
+	checks := []string{}
+	for _, issue := range l.Issues {
+		checks = append(checks, issue.Severity+" "+issue.Check)
+	}
+	sort.Strings(checks)
+	return checks
+}
+
+func TestLintRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want []string
+	}{
+		{"clean",
+			`alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"ok"; content:"a"; sid:1;)`, []string{}},
+		{"missing sid",
+			`alert tcp any any -> any any (msg:"x"; content:"a";)`, []string{"error missing-sid"}},
+		{"invalid sid",
+			`alert tcp any any -> any any (msg:"x"; content:"a"; sid:abc;)`, []string{"error invalid-sid"}},
+		{"missing msg",
+			`alert tcp any any -> any any (content:"a"; sid:1;)`, []string{"warning missing-msg"}},
+		{"unquoted msg",
+			`alert tcp any any -> any any (msg:abc; content:"a"; sid:1;)`, []string{"error msg-escaping"}},
+		{"unknown keyword",
+			`alert tcp any any -> any any (msg:"x"; content:"a"; no_such_keyword; sid:1;)`, []string{"error unknown-keyword"}},
+		{"no content",
+			`alert tcp any any -> any any (msg:"x"; flow:established; sid:1;)`, []string{"warning no-content"}},
+		{"pcre without content",
+			`alert tcp any any -> any any (msg:"x"; pcre:"/a/"; sid:1;)`, []string{"error unbounded-pcre", "warning no-content"}},
+		{"unbounded pcre",
+			`alert tcp any any -> any any (msg:"x"; content:"a"; pcre:"/a.*b/"; sid:1;)`, []string{"warning unbounded-pcre"}},
+		{"undefined variable",
+			`alert tcp $HOME_NET any -> $NO_SUCH_NET any (msg:"x"; content:"a"; sid:1;)`, []string{"error undefined-variable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newLinter("suricata", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.lintRule(rule, "test.rules")
+			if got := lintChecks(l); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("замечания %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesDialect(t *testing.T) {
+	tests := []struct {
+		engine  string
+		dialect string
+		want    bool
+	}{
+		{"suricata", DialectSuricata, true},
+		{"suricata", "", true},
+		{"suricata", DialectSnort2, false},
+		{"suricata", DialectSnort3, false},
+		{"suricata", DialectDionis, false},
+		{"snort", DialectSnort2, true},
+		{"snort", DialectSnort3, false},
+		{"snort", DialectSuricata, false},
+		{"snort3", DialectSnort2, true},
+		{"snort3", DialectSnort3, true},
+		{"snort3", DialectSuricata, false},
+	}
+	for _, tt := range tests {
+		l, err := newLinter(tt.engine, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.matchesDialect(tt.dialect); got != tt.want {
+			t.Errorf("matchesDialect(%q) для %s = %v, ожидалось %v", tt.dialect, tt.engine, got, tt.want)
+		}
+	}
+}
+
+func TestLintFileRuleSIDs(t *testing.T) {
+	const loaded = `alert tcp any any -> any any (msg:"x"; content:"a"; sid:10;)`
+	stored, err := parseRule(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(stored, "vendor.rules", "vendor")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rules []string
+		want  []string
+	}{
+		{"unique",
+			[]string{`alert tcp any any -> any any (msg:"x"; content:"a"; sid:1;)`,
+				`alert tcp any any -> any any (msg:"x"; content:"a"; sid:2;)`}, []string{}},
+		{"duplicate in files",
+			[]string{`alert tcp any any -> any any (msg:"x"; content:"a"; sid:1;)`,
+				`alert tcp any any -> any any (msg:"y"; content:"b"; sid:1;)`}, []string{"error duplicate-sid"}},
+		{"same sid with other gid",
+			[]string{`alert tcp any any -> any any (msg:"x"; content:"a"; sid:1;)`,
+				`alert tcp any any -> any any (msg:"x"; content:"a"; gid:3; sid:1;)`}, []string{}},
+		{"same rule in db",
+			[]string{`alert tcp any any -> any any (msg:"new msg"; content:"a"; sid:10; rev:2;)`}, []string{"info stored-sid"}},
+		{"other rule in db",
+			[]string{`alert tcp any any -> any any (msg:"x"; content:"b"; sid:10;)`}, []string{"warning stored-sid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newLinter("suricata", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.addStored(sig)
+			for i, text := range tt.rules {
+				rule, err := parseRule(text)
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.lintFileRule(rule, []string{"a.rules", "b.rules"}[i%2])
+			}
+			if got := lintChecks(l); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("замечания %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+//go:build local
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB      DBConfig       `mapstructure:"db"`
+	Sources []SourceConfig `mapstructure:"sources"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+type SourceConfig struct {
+	Name    string `mapstructure:"name"`
+	Type    string `mapstructure:"type"`
+	Path    string `mapstructure:"path"`
+	SIDMin  uint64 `mapstructure:"sid_min"`
+	SIDMax  uint64 `mapstructure:"sid_max"`
+	Dialect string `mapstructure:"dialect"`
+}
+
+var config Config
+
+func main() {
+	initLog()
+
+	log.Println("=== Старт загрузки локальных правил ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	for _, source := range config.Sources {
+		if source.Type == "local" {
+			log.Printf("Обработка источника: %s", source.Name)
+			// Локальные правила пишутся в синтаксисе Suricata, если не указано иное.
+			if source.Dialect == "" {
+				source.Dialect = DialectSuricata
+			}
+			err := processLocalSource(db, LocalSource{
+				Name:    source.Name,
+				Dir:     source.Path,
+				SIDMin:  source.SIDMin,
+				SIDMax:  source.SIDMax,
+				Dialect: source.Dialect,
+			})
+			if err != nil {
+				log.Printf("Ошибка обработки локального источника %s: %v", source.Name, err)
+			}
+		}
+	}
+
+	log.Println("=== Завершение загрузки локальных правил ===")
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало загрузки локальных правил ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// LocalSource - источник собственных правил: каталог .rules файлов и строки
+// таблицы local_rules с тем же именем источника.
+type LocalSource struct {
+	Name    string
+	Dir     string
+	SIDMin  uint64
+	SIDMax  uint64
+	Dialect string
+}
+
+// localRule - правило локального источника и место, где оно хранится.
+type localRule struct {
+	rule   *Rule
+	origin string // имя файла или local_rules:<id>
+
+	file  *localFile // файл правила, nil для строки local_rules
+	line  int        // последняя строка правила в файле
+	rowID int        // id строки local_rules
+
+	hasRev bool // в правиле была опция rev; заполняется при назначении sid
+}
+
+type localFile struct {
+	path    string
+	lines   []string
+	changed bool
+}
+
+// processLocalSource загружает локальные правила: назначает sid правилам без него,
+// проверяет диапазон и пересечения с правилами поставщиков, сохраняет правила
+// и помечает удалёнными исчезнувшие.
+func processLocalSource(db *sql.DB, src LocalSource) error {
+	if src.SIDMin == 0 || src.SIDMax < src.SIDMin {
+		return fmt.Errorf("Некорректный диапазон sid источника %s: %d-%d", src.Name, src.SIDMin, src.SIDMax)
+	}
+
+	var rules []*localRule
+	var files []*localFile
+	if src.Dir != "" {
+		var err error
+		files, rules, err = readLocalDir(src.Dir)
+		if err != nil {
+			return err
+		}
+	}
+	rows, err := readLocalTable(db, src.Name)
+	if err != nil {
+		return err
+	}
+	rules = append(rules, rows...)
+
+	if err := allocateLocalSIDs(db, src, rules); err != nil {
+		return err
+	}
+	if err := writeLocalFiles(files); err != nil {
+		return err
+	}
+
+	vendor, err := vendorSIDs(db, rules)
+	if err != nil {
+		return err
+	}
+
+	saved := map[string]bool{}
+	failed := 0
+	for _, sig := range acceptLocalRules(src, rules, vendor) {
+		if err := saveToDB(db, sig); err != nil {
+			log.Printf("Ошибка сохранения записи (SID: %s): %v", sig.SID, err)
+			failed++
+			continue
+		}
+		saved[sig.SID] = true
+	}
+	// Несохранённое правило не должно помечаться удалённым: пометка откладывается до следующей загрузки.
+	if failed > 0 {
+		return fmt.Errorf("Источник %s: не сохранено правил %d, удалённые правила не помечены", src.Name, failed)
+	}
+
+	// Правила, которых больше нет в файлах и таблице, помечаются удалёнными.
+	res, err := db.Exec(`
+        WITH deleted AS (
+            UPDATE signatures SET deleted_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+            WHERE local AND source = $1 AND deleted_at IS NULL AND NOT (sid = ANY($2))
+            RETURNING sid
+        )
+        INSERT INTO signature_history (sid, event, actor) SELECT sid, 'deleted', $1 FROM deleted
+    `, src.Name, pq.Array(sortedKeys(saved)))
+	if err != nil {
+		return fmt.Errorf("Ошибка пометки удалённых правил: %v", err)
+	}
+	deleted, _ := res.RowsAffected()
+	log.Printf("Источник %s: загружено правил %d, удалено %d", src.Name, len(saved), deleted)
+	return nil
+}
+
+// acceptLocalRules отбирает правила для сохранения: sid в диапазоне источника, не занят
+// правилом поставщика и не повторяется. Остальные правила отклоняются с записью в лог.
+func acceptLocalRules(src LocalSource, rules []*localRule, vendor map[string]bool) []Signature {
+	var sigs []Signature
+	seen := map[string]bool{}
+	for _, lr := range rules {
+		sid, _ := lr.rule.Option("sid")
+		n, _ := strconv.ParseUint(sid, 10, 64)
+		switch {
+		case n < src.SIDMin || n > src.SIDMax:
+			log.Printf("Правило %s (SID: %s) отклонено: sid вне диапазона %d-%d", lr.origin, sid, src.SIDMin, src.SIDMax)
+			continue
+		case vendor[sid]:
+			log.Printf("Правило %s (SID: %s) отклонено: sid уже занят правилом поставщика", lr.origin, sid)
+			continue
+		case seen[sid]:
+			log.Printf("Правило %s (SID: %s) отклонено: sid повторяется в локальных правилах", lr.origin, sid)
+			continue
+		}
+
+		applyDialect(lr.rule, src.Dialect)
+		sig, err := signatureFromRule(lr.rule, lr.origin, src.Name)
+		if err != nil {
+			log.Printf("Некорректное правило %s: %v", lr.origin, err)
+			continue
+		}
+		sig.Local = true
+		seen[sid] = true
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// writeLocalFiles записывает обратно файлы, в которые вставлены назначенные sid.
+func writeLocalFiles(files []*localFile) error {
+	for _, file := range files {
+		if !file.changed {
+			continue
+		}
+		if err := os.WriteFile(file.path, []byte(strings.Join(file.lines, "\n")), 0644); err != nil {
+			return fmt.Errorf("Ошибка записи файла %s: %v", file.path, err)
+		}
+		log.Printf("В файл %s записаны назначенные sid", file.path)
+	}
+	return nil
+}
+
+// readLocalDir читает все .rules файлы каталога.
+func readLocalDir(dir string) ([]*localFile, []*localRule, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.rules"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения каталога %s: %v", dir, err)
+	}
+
+	var files []*localFile
+	var rules []*localRule
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Ошибка чтения файла %s: %v", path, err)
+		}
+		file := &localFile{path: path, lines: strings.Split(string(content), "\n")}
+		files = append(files, file)
+
+		for _, line := range splitRuleLines(string(content)) {
+			rule, err := parseRule(line.Text)
+			if err != nil {
+				if !line.Disabled {
+					log.Printf("Ошибка разбора правила в файле %s, строка %d: %v", path, line.Line+1, err)
+				}
+				continue
+			}
+			rule.Enabled = !line.Disabled
+			rules = append(rules, &localRule{rule: rule, origin: filepath.Base(path), file: file, line: line.Line})
+		}
+	}
+	return files, rules, nil
+}
+
+// readLocalTable читает правила источника из таблицы local_rules.
+func readLocalTable(db *sql.DB, source string) ([]*localRule, error) {
+	rows, err := db.Query(`SELECT id, rule FROM local_rules WHERE source = $1 ORDER BY id`, source)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	var rules []*localRule
+	for rows.Next() {
+		var id int
+		var text string
+		if err := rows.Scan(&id, &text); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		lines := splitRuleLines(text)
+		if len(lines) != 1 {
+			log.Printf("Строка local_rules %d должна содержать ровно одно правило", id)
+			continue
+		}
+		rule, err := parseRule(lines[0].Text)
+		if err != nil {
+			log.Printf("Ошибка разбора правила local_rules %d: %v", id, err)
+			continue
+		}
+		rule.Enabled = !lines[0].Disabled
+		rules = append(rules, &localRule{rule: rule, origin: fmt.Sprintf("local_rules:%d", id), rowID: id})
+	}
+	return rules, rows.Err()
+}
+
+// allocateLocalSIDs назначает свободные sid из диапазона правилам без sid
+// и записывает их обратно в файл или в таблицу local_rules.
+func allocateLocalSIDs(db *sql.DB, src LocalSource, rules []*localRule) error {
+	used, err := usedSIDs(db, src.SIDMin, src.SIDMax)
+	if err != nil {
+		return err
+	}
+	assigned, err := assignLocalSIDs(src, rules, used)
+	if err != nil {
+		return err
+	}
+	for _, lr := range assigned {
+		if lr.file != nil {
+			continue
+		}
+		sid, _ := lr.rule.Option("sid")
+		var text string
+		if err := db.QueryRow(`SELECT rule FROM local_rules WHERE id = $1`, lr.rowID).Scan(&text); err != nil {
+			return fmt.Errorf("Ошибка чтения local_rules %d: %v", lr.rowID, err)
+		}
+		_, err := db.Exec(`UPDATE local_rules SET rule = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
+			insertSID(strings.TrimRight(text, " \r\n"), sid, lr.hasRev), lr.rowID)
+		if err != nil {
+			return fmt.Errorf("Ошибка записи sid в local_rules %d: %v", lr.rowID, err)
+		}
+	}
+	return nil
+}
+
+// assignLocalSIDs назначает правилам без sid свободные sid диапазона (занятые - в used)
+// и вставляет их в правило и в строки его файла. Возвращает правила с назначенными sid.
+func assignLocalSIDs(src LocalSource, rules []*localRule, used map[uint64]bool) ([]*localRule, error) {
+	var assigned []*localRule
+	for _, lr := range rules {
+		if sid, ok := lr.rule.Option("sid"); ok {
+			if n, err := strconv.ParseUint(sid, 10, 64); err == nil {
+				used[n] = true
+			}
+		}
+	}
+
+	next := src.SIDMin
+	for _, lr := range rules {
+		if _, ok := lr.rule.Option("sid"); ok {
+			continue
+		}
+		for used[next] && next <= src.SIDMax {
+			next++
+		}
+		if next > src.SIDMax {
+			return nil, fmt.Errorf("Диапазон sid источника %s исчерпан", src.Name)
+		}
+		used[next] = true
+		sid := strconv.FormatUint(next, 10)
+
+		_, lr.hasRev = lr.rule.Option("rev")
+		lr.rule.Options = append(lr.rule.Options, RuleOption{Name: "sid", Value: sid})
+		lr.rule.Raw = insertSID(lr.rule.Raw, sid, lr.hasRev)
+		if !lr.hasRev {
+			lr.rule.Options = append(lr.rule.Options, RuleOption{Name: "rev", Value: "1"})
+		}
+		if lr.file != nil {
+			lr.file.lines[lr.line] = insertSID(lr.file.lines[lr.line], sid, lr.hasRev)
+			lr.file.changed = true
+		}
+		assigned = append(assigned, lr)
+		log.Printf("Правилу %s назначен sid %s", lr.origin, sid)
+	}
+	return assigned, nil
+}
+
+// insertSID дописывает sid (и rev:1, если в правиле нет rev) перед закрывающей скобкой правила.
+// Наличие rev берётся из разобранных опций: текст "rev:" может встречаться в msg или content.
+func insertSID(line, sid string, hasRev bool) string {
+	end := strings.LastIndex(line, ")")
+	if end < 0 {
+		return line
+	}
+	head := strings.TrimRight(line[:end], " \t")
+	if !strings.HasSuffix(head, ";") && !strings.HasSuffix(head, "(") {
+		head += ";"
+	}
+	head += " sid:" + sid + ";"
+	if !hasRev {
+		head += " rev:1;"
+	}
+	return head + line[end:]
+}
+
+// usedSIDs возвращает занятые sid диапазона, включая удалённые: их не назначаем повторно.
+func usedSIDs(db *sql.DB, min, max uint64) (map[uint64]bool, error) {
+	rows, err := db.Query(`
+        SELECT sid::BIGINT FROM signatures
+        WHERE sid ~ '^[0-9]+$' AND sid::NUMERIC BETWEEN $1 AND $2
+    `, min, max)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	used := map[uint64]bool{}
+	for rows.Next() {
+		var sid uint64
+		if err := rows.Scan(&sid); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		used[sid] = true
+	}
+	return used, rows.Err()
+}
+
+// vendorSIDs возвращает sid локальных правил, которые уже заняты правилами поставщиков.
+func vendorSIDs(db *sql.DB, rules []*localRule) (map[string]bool, error) {
+	var sids []string
+	for _, lr := range rules {
+		if sid, ok := lr.rule.Option("sid"); ok {
+			sids = append(sids, sid)
+		}
+	}
+	rows, err := db.Query(`
+        SELECT sid FROM signatures
+        WHERE sid = ANY($1) AND NOT COALESCE(local, FALSE)
+    `, pq.Array(sids))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	vendor := map[string]bool{}
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		vendor[sid] = true
+	}
+	return vendor, rows.Err()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssignLocalSIDs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local.rules")
+	writeTestFile(t, path, strings.Join([]string{
+		`alert tcp any any -> any any (msg:"has sid"; content:"a"; sid:9000001; rev:1;)`,
+		`alert tcp any any -> any any (msg:"no sid"; content:"b";)`,
+		`# alert tcp any any -> any any (msg:"disabled, rev:3 in msg"; content:"c")`,
+		`alert tcp any any -> any any (msg:"own rev"; content:"d"; rev:4;)`,
+	}, "\n"))
+
+	files, rules, err := readLocalDir(dir)
+	if err != nil {
+		t.Fatalf("readLocalDir: %v", err)
+	}
+	src := LocalSource{Name: "local", SIDMin: 9000001, SIDMax: 9000010}
+	// 9000002 занят в БД (в том числе удалённым правилом) и не назначается.
+	assigned, err := assignLocalSIDs(src, rules, map[uint64]bool{9000002: true})
+	if err != nil {
+		t.Fatalf("assignLocalSIDs: %v", err)
+	}
+	if len(assigned) != 3 {
+		t.Fatalf("назначено sid %d, ожидалось 3", len(assigned))
+	}
+	if err := writeLocalFiles(files); err != nil {
+		t.Fatalf("writeLocalFiles: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		`alert tcp any any -> any any (msg:"has sid"; content:"a"; sid:9000001; rev:1;)`,
+		`alert tcp any any -> any any (msg:"no sid"; content:"b"; sid:9000003; rev:1;)`,
+		// rev в тексте msg не считается опцией rev.
+		`# alert tcp any any -> any any (msg:"disabled, rev:3 in msg"; content:"c"; sid:9000004; rev:1;)`,
+		`alert tcp any any -> any any (msg:"own rev"; content:"d"; rev:4; sid:9000005;)`,
+	}, "\n")
+	if string(data) != want {
+		t.Errorf("файл после записи sid:\n%s\nожидалось:\n%s", data, want)
+	}
+	if rev, _ := rules[3].rule.Option("rev"); rev != "4" || !strings.HasSuffix(rules[3].rule.Raw, "rev:4; sid:9000005;)") {
+		t.Errorf("правило с rev: %+v", rules[3].rule)
+	}
+
+	// Исчерпанный диапазон - ошибка.
+	_, rules, _ = readLocalDir(writeRulesDir(t, `alert tcp any any -> any any (msg:"x"; content:"a";)`))
+	if _, err := assignLocalSIDs(LocalSource{Name: "local", SIDMin: 1, SIDMax: 1}, rules, map[uint64]bool{1: true}); err == nil {
+		t.Error("ожидалась ошибка исчерпанного диапазона")
+	}
+}
+
+func TestAcceptLocalRules(t *testing.T) {
+	_, rules, err := readLocalDir(writeRulesDir(t, strings.Join([]string{
+		`alert tcp any any -> any any (msg:"ok"; content:"a"; sid:9000001; rev:1;)`,
+		`alert tcp any any -> any any (msg:"vendor"; content:"b"; sid:9000002; rev:1;)`,
+		`alert tcp any any -> any any (msg:"out of range"; content:"c"; sid:2000001; rev:1;)`,
+		`alert tcp any any -> any any (msg:"duplicate"; content:"d"; sid:9000001; rev:1;)`,
+		`alert tcp any any -> any any (msg:"ok 2"; content:"e"; sid:9000003; rev:1;)`,
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := LocalSource{Name: "local", SIDMin: 9000001, SIDMax: 9000010, Dialect: DialectSuricata}
+	sigs := acceptLocalRules(src, rules, map[string]bool{"9000002": true})
+
+	var got []string
+	for _, sig := range sigs {
+		if !sig.Local || sig.Source != "local" {
+			t.Errorf("сигнатура %s: local %v, source %q", sig.SID, sig.Local, sig.Source)
+		}
+		got = append(got, sig.SID+" "+sig.Msg)
+	}
+	if want := []string{"9000001 ok", "9000003 ok 2"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("приняты %v, ожидалось %v", got, want)
+	}
+}
+
+// writeRulesDir создаёт каталог с файлом local.rules.
+func writeRulesDir(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "local.rules"), content)
+	return dir
+}
@@ -0,0 +1,317 @@
+//go:build manage
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	DB DBConfig `mapstructure:"db"`
+}
+
+type DBConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+}
+
+var config Config
+
+const usage = `Использование:
+  manage enable [-deps] [-comment текст] SID...   включить правила
+  manage disable [-comment текст] SID...          отключить правила
+  manage search [-content | -data строка] [-buffer буфер] [-q текст] ...   найти правила
+  manage lookup [-side src|dst] АДРЕС|ПОДСЕТЬ      найти правила по адресу в заголовке
+  manage coverage [-file файл] [-format json] CVE...   покрытие CVE правилами по источникам
+  manage reindex                                  пересчитать content, сети заголовка и ссылки для поиска`
+
+func main() {
+	initLog()
+
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	log.Println("=== Старт управления сигнатурами ===")
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+
+	db, err := connectToDB(config.DB)
+	if err != nil {
+		log.Fatalf("Ошибка подключения к БД: %v", err)
+	}
+	defer db.Close()
+
+	if err := initDB(db); err != nil {
+		log.Fatalf("Ошибка инициализации БД: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "enable":
+		err = runEnable(db, os.Args[2:])
+	case "disable":
+		err = runDisable(db, os.Args[2:])
+	case "search":
+		err = runSearch(db, os.Args[2:])
+	case "lookup":
+		err = runLookup(db, os.Args[2:])
+	case "coverage":
+		err = runCoverage(db, os.Args[2:])
+	case "reindex":
+		err = runReindex(db)
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Printf("Ошибка: %v", err)
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	log.Println("=== Завершение управления сигнатурами ===")
+}
+
+func runEnable(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("enable", flag.ExitOnError)
+	withDeps := fs.Bool("deps", false, "Включить также правила, устанавливающие нужные flowbits")
+	comment := fs.String("comment", "", "Причина включения для истории сигнатуры")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("Не указаны SID правил")
+	}
+
+	deps, err := enableRules(db, fs.Args(), *withDeps, changeNote(*comment))
+	if err != nil {
+		return err
+	}
+	for _, sid := range deps {
+		if *withDeps {
+			report("Включено правило-установщик flowbits: SID %s", sid)
+		} else {
+			report("Предупреждение: правило-установщик flowbits SID %s отключено, используйте -deps", sid)
+		}
+	}
+	report("Включено правил: %d", fs.NArg())
+	return nil
+}
+
+func runDisable(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("disable", flag.ExitOnError)
+	comment := fs.String("comment", "", "Причина отключения для истории сигнатуры")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("Не указаны SID правил")
+	}
+
+	broken, err := disableRules(db, fs.Args(), changeNote(*comment))
+	if err != nil {
+		return err
+	}
+	for _, issue := range broken {
+		report("Предупреждение: SID %s проверяет flowbit %s, который больше никто не устанавливает", issue.SID, issue.Name)
+	}
+	report("Отключено правил: %d", fs.NArg())
+	return nil
+}
+
+func runSearch(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var search SignatureSearch
+	fs.StringVar(&search.Content, "content", "", `Подстрока content в синтаксисе content, например "cmd.exe|00|"`)
+	fs.StringVar(&search.Data, "data", "", "Данные, в которых находятся все content правила (depth, offset и др. не учитываются)")
+	fs.StringVar(&search.Buffer, "buffer", "", "Буфер content: http_uri, http.uri, dns_query, file_data...")
+	fs.StringVar(&search.Text, "q", "", "Полнотекстовый поиск по msg")
+	sids := fs.String("sid", "", "Диапазоны sid через запятую")
+	sources := fs.String("source", "", "Источники через запятую")
+	fs.IntVar(&search.Limit, "limit", defaultSearchLimit, "Число правил в выводе")
+	fs.Parse(args)
+	search.Filter.IDs = splitList(*sids)
+	search.Filter.Sources = splitList(*sources)
+	if search.Content == "" && search.Data == "" && search.Text == "" {
+		return fmt.Errorf("Не задана строка поиска: -content, -data или -q")
+	}
+	if err := search.validate(); err != nil {
+		return err
+	}
+
+	records, total, err := searchSignatures(db, search)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		state := ""
+		if !r.Enabled {
+			state = " (отключено)"
+		}
+		fmt.Printf("%s [%s] %s%s\n", r.SID, r.Source, r.Msg, state)
+		for _, c := range r.Contents {
+			if c.Matched {
+				fmt.Printf("    %s: content:%s\n", c.Buffer, c.Value)
+			}
+		}
+	}
+	report("Найдено правил: %d, показано: %d", total, len(records))
+	return nil
+}
+
+func runLookup(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	var search SignatureSearch
+	fs.StringVar(&search.IPSide, "side", "", "Искать только в src_ip (src) или dst_ip (dst)")
+	sources := fs.String("source", "", "Источники через запятую")
+	enabled := fs.Bool("enabled", false, "Только включённые правила")
+	fs.IntVar(&search.Limit, "limit", defaultSearchLimit, "Число правил в выводе")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("Укажите один адрес или подсеть")
+	}
+	search.IP = fs.Arg(0)
+	search.Filter.Sources = splitList(*sources)
+	if *enabled {
+		search.Enabled = enabled
+	}
+	if err := search.validate(); err != nil {
+		return err
+	}
+
+	records, total, err := searchSignatures(db, search)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		state := ""
+		if !r.Enabled {
+			state = " (отключено)"
+		}
+		fmt.Printf("%s [%s] %s %s %s %s%s\n", r.SID, r.Source, r.SrcIP, r.Direction, r.DstIP, r.Msg, state)
+	}
+	report("Найдено правил: %d, показано: %d", total, len(records))
+	return nil
+}
+
+func runCoverage(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	file := fs.String("file", "", "Файл со списком CVE, по одному в строке (# - комментарий)")
+	sources := fs.String("source", "", "Источники через запятую")
+	format := fs.String("format", "text", "Формат отчёта: text или json")
+	fs.Parse(args)
+
+	list := fs.Args()
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("Ошибка чтения файла %s: %v", *file, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			list = append(list, strings.Fields(line)...)
+		}
+	}
+	cves, err := normalizeCVEList(list)
+	if err != nil {
+		return err
+	}
+	coverage, err := cveCoverage(db, cves, splitList(*sources))
+	if err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, c := range coverage {
+		counts[c.Status]++
+	}
+	log.Printf("Покрытие CVE: %d, active %d, disabled %d, none %d", len(coverage),
+		counts[CoverageActive], counts[CoverageDisabled], counts[CoverageNone])
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(coverage)
+	case "text":
+		for _, c := range coverage {
+			var details []string
+			for _, sc := range c.Sources {
+				details = append(details, fmt.Sprintf("%s: включено %d, отключено %d", sc.Source, len(sc.Active), len(sc.Disabled)))
+			}
+			fmt.Printf("%-18s %-8s %s\n", c.CVE, c.Status, strings.Join(details, "; "))
+		}
+		fmt.Printf("Итого: active %d, disabled %d, none %d\n", counts[CoverageActive], counts[CoverageDisabled], counts[CoverageNone])
+		return nil
+	}
+	return fmt.Errorf("Неподдерживаемый формат отчёта: %s", *format)
+}
+
+func runReindex(db *sql.DB) error {
+	count, err := refreshContents(db)
+	if err != nil {
+		return err
+	}
+	report("Пересчитаны content сигнатур: %d", count)
+	if count, err = refreshNetworks(db); err != nil {
+		return err
+	}
+	report("Пересчитаны сети заголовка сигнатур: %d", count)
+	if count, err = refreshReferences(db); err != nil {
+		return err
+	}
+	report("Пересчитаны ссылки reference сигнатур: %d", count)
+	return nil
+}
+
+// changeNote - запись для истории сигнатуры: пользователь ОС и комментарий.
+func changeNote(comment string) ChangeNote {
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "manage"
+	}
+	return ChangeNote{Actor: actor, Comment: comment}
+}
+
+// report выводит сообщение пользователю и дублирует его в лог.
+func report(format string, args ...interface{}) {
+	log.Printf(format, args...)
+	fmt.Printf(format+"\n", args...)
+}
+
+func initLog() {
+	file, err := os.Create("parser.log")
+	if err != nil {
+		fmt.Printf("Ошибка создания лог-файла: %v\n", err)
+		os.Exit(1)
+	}
+	log.SetOutput(file)
+	log.Println("=== Начало управления сигнатурами ===")
+}
+
+func loadConfig() error {
+	viper.SetConfigName("locals")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Ошибка чтения файла конфигурации: %v", err)
+	}
+	return viper.Unmarshal(&config)
+}
+
+func connectToDB(dbConfig DBConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		dbConfig.Host, dbConfig.Port, dbConfig.User, dbConfig.Password, dbConfig.Name)
+	return sql.Open("postgres", connStr)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportManifest - список файлов выгрузки с хэшами. Манифест подписывается ключом
+// Ed25519, подпись лежит рядом в файле <манифест>.sig (base64).
+type ExportManifest struct {
+	Profile   string         `json:"profile"`
+	Format    string         `json:"format"`
+	Snapshot  int            `json:"snapshot,omitempty"`
+	Generated time.Time      `json:"generated"`
+	Files     []ManifestFile `json:"files"`
+	// Сопутствующие файлы выгрузки: отчёт о непереведённых правилах и дельта.
+	// Сенсор может их не загружать, поэтому проверяются только имеющиеся.
+	Artifacts []ManifestFile `json:"artifacts,omitempty"`
+}
+
+// ManifestFile - файл выгрузки; имя указывается относительно каталога манифеста.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// manifestPath - путь манифеста выгрузки outputFile.
+func manifestPath(outputFile string) string {
+	return outputFile + ".manifest.json"
+}
+
+// writeManifest записывает манифест файлов выгрузки и сопутствующих файлов и, если задан ключ, его подпись.
+func writeManifest(path string, m *ExportManifest, files, artifacts []string, key ed25519.PrivateKey) error {
+	var err error
+	if m.Files, err = manifestFiles(path, files); err != nil {
+		return err
+	}
+	if m.Artifacts, err = manifestFiles(path, artifacts); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации манифеста: %v", err)
+	}
+	data = append(data, '\n')
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	if key == nil {
+		return nil
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return writeFileAtomic(path+".sig", []byte(signature+"\n"))
+}
+
+// manifestFiles хэширует файлы и записывает их имена относительно каталога манифеста path.
+func manifestFiles(path string, files []string) ([]ManifestFile, error) {
+	var result []ManifestFile
+	for _, file := range files {
+		f, err := hashFile(file)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(filepath.Dir(path), file)
+		if err != nil {
+			rel = filepath.Base(file)
+		}
+		f.Name = filepath.ToSlash(rel)
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// verifyManifest проверяет подпись манифеста открытым ключом и хэши перечисленных в нём файлов;
+// сопутствующие файлы проверяются, если они есть рядом с манифестом.
+func verifyManifest(path string, key ed25519.PublicKey) (*ExportManifest, error) {
+	m, err := readSignedManifest(path, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, want := range m.Files {
+		if err := checkManifestFile(path, want); err != nil {
+			return nil, err
+		}
+	}
+	for _, want := range m.Artifacts {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), filepath.FromSlash(want.Name))); os.IsNotExist(err) {
+			continue
+		}
+		if err := checkManifestFile(path, want); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// verifyArtifact проверяет подпись манифеста и содержимое data сопутствующего файла file,
+// который должен быть перечислен в манифесте.
+func verifyArtifact(path, file string, data []byte, key ed25519.PublicKey) error {
+	m, err := readSignedManifest(path, key)
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(filepath.Dir(path), file)
+	if err != nil {
+		name = file
+	}
+	for _, want := range m.Artifacts {
+		if want.Name != filepath.ToSlash(name) {
+			continue
+		}
+		if got := sha256Hex(data); got != want.SHA256 || int64(len(data)) != want.Size {
+			return fmt.Errorf("Файл %s не совпадает с манифестом: SHA-256 %s, ожидается %s", want.Name, got, want.SHA256)
+		}
+		return nil
+	}
+	return fmt.Errorf("Файл %s не указан в манифесте %s", file, path)
+}
+
+// readSignedManifest читает манифест и проверяет его подпись открытым ключом.
+func readSignedManifest(path string, key ed25519.PublicKey) (*ExportManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения манифеста %s: %v", path, err)
+	}
+	sigText, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения подписи %s.sig: %v", path, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil {
+		return nil, fmt.Errorf("Некорректная подпись %s.sig: %v", path, err)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return nil, fmt.Errorf("Подпись манифеста %s недействительна", path)
+	}
+
+	var m ExportManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("Ошибка разбора манифеста %s: %v", path, err)
+	}
+	return &m, nil
+}
+
+// checkManifestFile сравнивает файл рядом с манифестом path с его записью в манифесте.
+func checkManifestFile(path string, want ManifestFile) error {
+	if filepath.IsAbs(want.Name) || strings.HasPrefix(filepath.Clean(want.Name), "..") {
+		return fmt.Errorf("Недопустимое имя файла в манифесте: %s", want.Name)
+	}
+	got, err := hashFile(filepath.Join(filepath.Dir(path), filepath.FromSlash(want.Name)))
+	if err != nil {
+		return err
+	}
+	if got.SHA256 != want.SHA256 || got.Size != want.Size {
+		return fmt.Errorf("Файл %s не совпадает с манифестом: SHA-256 %s, ожидается %s", want.Name, got.SHA256, want.SHA256)
+	}
+	return nil
+}
+
+func hashFile(path string) (ManifestFile, error) {
+	f := ManifestFile{Name: path}
+	file, err := os.Open(path)
+	if err != nil {
+		return f, fmt.Errorf("Ошибка открытия файла %s: %v", path, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if f.Size, err = io.Copy(hash, file); err != nil {
+		return f, fmt.Errorf("Ошибка чтения файла %s: %v", path, err)
+	}
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return f, nil
+}
+
+// loadPrivateKey читает закрытый ключ Ed25519 в PEM (PKCS #8), например созданный
+// командой keygen или openssl genpkey -algorithm ed25519.
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка разбора ключа %s: %v", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Ключ %s не является ключом Ed25519", path)
+	}
+	return ed, nil
+}
+
+// loadPublicKey читает открытый ключ Ed25519 в PEM (PKIX).
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка разбора ключа %s: %v", path, err)
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Ключ %s не является ключом Ed25519", path)
+	}
+	return ed, nil
+}
+
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения ключа %s: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("В файле %s нет блока PEM %s", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// generateKeys создаёт пару ключей Ed25519: закрытый <prefix>.key и открытый <prefix>.pub.
+func generateKeys(prefix string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Ошибка создания ключа: %v", err)
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации ключа: %v", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("Ошибка сериализации ключа: %v", err)
+	}
+	if _, err := os.Stat(prefix + ".key"); err == nil {
+		return fmt.Errorf("Файл %s.key уже существует", prefix)
+	}
+	if err := os.WriteFile(prefix+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return fmt.Errorf("Ошибка записи в файл %s.key: %v", prefix, err)
+	}
+	if err := os.WriteFile(prefix+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return fmt.Errorf("Ошибка записи в файл %s.pub: %v", prefix, err)
+	}
+	return nil
+}
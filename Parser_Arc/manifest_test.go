@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedExport записывает выгрузку из двух файлов с отчётом и подписанный манифест.
+func signedExport(t *testing.T) (string, ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "export")
+	if err := generateKeys(prefix); err != nil {
+		t.Fatalf("generateKeys: %v", err)
+	}
+	priv, err := loadPrivateKey(prefix + ".key")
+	if err != nil {
+		t.Fatalf("loadPrivateKey: %v", err)
+	}
+	pub, err := loadPublicKey(prefix + ".pub")
+	if err != nil {
+		t.Fatalf("loadPublicKey: %v", err)
+	}
+
+	var files []string
+	for _, name := range []string{"export_dionis_001.txt", "export_dionis_002.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("# "+name+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	report := filepath.Join(dir, "export_dionis.txt.report")
+	writeTestFile(t, report, "sid:1 not converted\n")
+	path := manifestPath(filepath.Join(dir, "export_dionis.txt"))
+	m := &ExportManifest{Profile: "default", Format: "dionis", Snapshot: 7, Generated: time.Now().UTC()}
+	if err := writeManifest(path, m, files, []string{report}, priv); err != nil {
+		t.Fatalf("writeManifest: %v", err)
+	}
+	return path, pub, priv
+}
+
+func TestVerifyManifest(t *testing.T) {
+	path, pub, _ := signedExport(t)
+	m, err := verifyManifest(path, pub)
+	if err != nil {
+		t.Fatalf("verifyManifest: %v", err)
+	}
+	if m.Snapshot != 7 || len(m.Files) != 2 || m.Files[0].Name != "export_dionis_001.txt" ||
+		len(m.Artifacts) != 1 || m.Artifacts[0].Name != "export_dionis.txt.report" {
+		t.Errorf("манифест %+v", m)
+	}
+
+	// Сенсор может не загружать сопутствующие файлы.
+	os.Remove(filepath.Join(filepath.Dir(path), "export_dionis.txt.report"))
+	if _, err := verifyManifest(path, pub); err != nil {
+		t.Errorf("verifyManifest без отчёта: %v", err)
+	}
+}
+
+func TestVerifyManifestRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(t *testing.T, path string, priv ed25519.PrivateKey)
+		key     func(pub ed25519.PublicKey) ed25519.PublicKey
+		wantErr string
+	}{
+		{name: "changed file", wantErr: "не совпадает с манифестом",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				writeTestFile(t, filepath.Join(filepath.Dir(path), "export_dionis_002.txt"), "# changed\n")
+			}},
+		{name: "changed artifact", wantErr: "не совпадает с манифестом",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				writeTestFile(t, filepath.Join(filepath.Dir(path), "export_dionis.txt.report"), "changed\n")
+			}},
+		{name: "missing file", wantErr: "Ошибка открытия файла",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				os.Remove(filepath.Join(filepath.Dir(path), "export_dionis_001.txt"))
+			}},
+		{name: "changed manifest", wantErr: "недействительна",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				data, _ := os.ReadFile(path)
+				writeTestFile(t, path, strings.Replace(string(data), `"snapshot": 7`, `"snapshot": 8`, 1))
+			}},
+		{name: "missing signature", wantErr: "Ошибка чтения подписи",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				os.Remove(path + ".sig")
+			}},
+		{name: "malformed signature", wantErr: "Некорректная подпись",
+			tamper: func(t *testing.T, path string, _ ed25519.PrivateKey) {
+				writeTestFile(t, path+".sig", "not base64!\n")
+			}},
+		{name: "other key", wantErr: "недействительна",
+			key: func(ed25519.PublicKey) ed25519.PublicKey {
+				other, _, _ := ed25519.GenerateKey(rand.Reader)
+				return other
+			}},
+		{name: "path outside manifest dir", wantErr: "Недопустимое имя файла",
+			tamper: func(t *testing.T, path string, priv ed25519.PrivateKey) {
+				// Подписанный манифест с файлом вне каталога выгрузки.
+				data, _ := os.ReadFile(path)
+				data = []byte(strings.Replace(string(data), `"export_dionis_001.txt"`, `"../secret.txt"`, 1))
+				writeTestFile(t, path, string(data))
+				writeTestFile(t, path+".sig", base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))+"\n")
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, pub, priv := signedExport(t)
+			if tt.tamper != nil {
+				tt.tamper(t, path, priv)
+			}
+			if tt.key != nil {
+				pub = tt.key(pub)
+			}
+			_, err := verifyManifest(path, pub)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	path, pub, _ := signedExport(t)
+	report := filepath.Join(filepath.Dir(path), "export_dionis.txt.report")
+	data, _ := os.ReadFile(report)
+	if err := verifyArtifact(path, report, data, pub); err != nil {
+		t.Fatalf("verifyArtifact: %v", err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{"changed", report, "changed\n", "не совпадает с манифестом"},
+		{"not listed", filepath.Join(filepath.Dir(path), "export_dionis.txt.delta.json"), string(data), "не указан в манифесте"},
+	}
+	for _, tt := range tests {
+		if err := verifyArtifact(path, tt.file, []byte(tt.data), pub); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: ошибка %v, ожидалась %q", tt.name, err, tt.wantErr)
+		}
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := verifyArtifact(path, report, data, other); err == nil || !strings.Contains(err.Error(), "недействительна") {
+		t.Errorf("ошибка %v для другого ключа", err)
+	}
+}
+
+func TestLoadKeysRejectWrongType(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "export")
+	if err := generateKeys(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadPublicKey(prefix + ".key"); err == nil {
+		t.Error("закрытый ключ прочитан как открытый")
+	}
+	if _, err := loadPrivateKey(prefix + ".pub"); err == nil {
+		t.Error("открытый ключ прочитан как закрытый")
+	}
+	if err := generateKeys(prefix); err == nil {
+		t.Error("существующий ключ перезаписан")
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// RuleMetadata - поля опции metadata, вынесенные в отдельные столбцы.
+// Все пары ключ/значение дополнительно сохраняются в Pairs.
+type RuleMetadata struct {
+	Severity   string
+	Policies   []string
+	CreatedAt  string // дата в формате 2006-01-02, пустая строка если не указана
+	UpdatedAt  string
+	Mitre      []string
+	Deployment []string
+	Pairs      map[string][]string
+}
+
+// parseMetadata собирает пары из всех опций metadata правила.
+// Формат опции: "metadata: key value, key value;".
+func parseMetadata(rule *Rule) RuleMetadata {
+	meta := RuleMetadata{Pairs: map[string][]string{}}
+	for _, value := range rule.Values("metadata") {
+		for _, pair := range strings.Split(value, ",") {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			key, val, _ := strings.Cut(pair, " ")
+			key = strings.ToLower(key)
+			val = strings.TrimSpace(val)
+			meta.Pairs[key] = append(meta.Pairs[key], val)
+
+			switch key {
+			case "signature_severity":
+				meta.Severity = val
+			case "policy":
+				// "policy balanced-ips drop" - сохраняем только название политики.
+				meta.Policies = appendUnique(meta.Policies, firstWord(val))
+			case "created_at":
+				meta.CreatedAt = metadataDate(val)
+			case "updated_at":
+				meta.UpdatedAt = metadataDate(val)
+			case "mitre_technique_id":
+				meta.Mitre = appendUnique(meta.Mitre, val)
+			case "deployment":
+				meta.Deployment = appendUnique(meta.Deployment, val)
+			}
+		}
+	}
+	return meta
+}
+
+// metadataDate приводит дату вида 2010_07_30 к 2010-07-30.
+func metadataDate(value string) string {
+	for _, layout := range []string{"2006_01_02", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+func appendUnique(list []string, value string) []string {
+	if value == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		want     RuleMetadata
+	}{
+		{"empty", ``, RuleMetadata{Pairs: map[string][]string{}}},
+		{"severity", `metadata:signature_severity Major;`, RuleMetadata{
+			Severity: "Major",
+			Pairs:    map[string][]string{"signature_severity": {"Major"}},
+		}},
+		{"policies", `metadata:policy balanced-ips drop, policy security-ips drop, Policy balanced-ips alert;`, RuleMetadata{
+			Policies: []string{"balanced-ips", "security-ips"},
+			Pairs:    map[string][]string{"policy": {"balanced-ips drop", "security-ips drop", "balanced-ips alert"}},
+		}},
+		{"dates with underscores", `metadata:created_at 2010_07_30, updated_at 2019_09_28;`, RuleMetadata{
+			CreatedAt: "2010-07-30",
+			UpdatedAt: "2019-09-28",
+			Pairs:     map[string][]string{"created_at": {"2010_07_30"}, "updated_at": {"2019_09_28"}},
+		}},
+		{"dates with dashes", `metadata:created_at 2021-03-01, updated_at 2021-13-01;`, RuleMetadata{
+			CreatedAt: "2021-03-01",
+			Pairs:     map[string][]string{"created_at": {"2021-03-01"}, "updated_at": {"2021-13-01"}},
+		}},
+		{"mitre and deployment in several options", `metadata:mitre_technique_id T1190, deployment Perimeter; metadata:mitre_technique_id T1059, mitre_technique_id T1190, deployment Internal;`, RuleMetadata{
+			Mitre:      []string{"T1190", "T1059"},
+			Deployment: []string{"Perimeter", "Internal"},
+			Pairs: map[string][]string{
+				"mitre_technique_id": {"T1190", "T1059", "T1190"},
+				"deployment":         {"Perimeter", "Internal"},
+			},
+		}},
+		{"key without value and empty pairs", `metadata:former_category MALWARE, , attack_target;`, RuleMetadata{
+			Pairs: map[string][]string{"former_category": {"MALWARE"}, "attack_target": {""}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRule(`alert tcp any any -> any any (msg:"x"; ` + tt.metadata + ` sid:1;)`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parseMetadata(rule); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMetadata = %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// HeaderNetworks - сети, явно указанные в адресе заголовка правила. Переменные ($HOME_NET)
+// и any не раскрываются; вложенное отрицание ![a,![b]] учитывается: b - разрешённая сеть.
+type HeaderNetworks struct {
+	Nets    []string // адреса и подсети, которые проверяет правило
+	Negated []string // исключённые адреса и подсети
+}
+
+// parseHeaderNetworks разбирает адрес заголовка: 1.2.3.4, 5.6.7.0/24, [..,..], !адрес, ![..].
+func parseHeaderNetworks(spec string) HeaderNetworks {
+	var h HeaderNetworks
+	h.collect(strings.Join(strings.Fields(spec), ""), false)
+	return h
+}
+
+func (h *HeaderNetworks) collect(spec string, negated bool) {
+	for strings.HasPrefix(spec, "!") {
+		negated = !negated
+		spec = spec[1:]
+	}
+	if strings.HasPrefix(spec, "[") && strings.HasSuffix(spec, "]") {
+		for _, item := range splitAddressList(spec[1 : len(spec)-1]) {
+			h.collect(item, negated)
+		}
+		return
+	}
+	network, ok := normalizeIP(spec)
+	switch {
+	case !ok:
+	case negated:
+		h.Negated = append(h.Negated, network)
+	default:
+		h.Nets = append(h.Nets, network)
+	}
+}
+
+// splitAddressList разбивает список адресов по запятым вне вложенных [...].
+func splitAddressList(list string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, list[start:])
+}
+
+// refreshNetworks заново заполняет сети заголовка по сохранённым адресам, например для
+// сигнатур, загруженных до появления поиска по адресам.
+func refreshNetworks(db *sql.DB) (int, error) {
+	type addresses struct{ src, dst HeaderNetworks }
+	updates := map[string]addresses{}
+	err := querySignatures(db, "deleted_at IS NULL", nil, func(sig Signature) error {
+		updates[sig.SID] = addresses{parseHeaderNetworks(sig.SrcIP), parseHeaderNetworks(sig.DstIP)}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for sid, a := range updates {
+		_, err := db.Exec(`
+            UPDATE signatures SET src_nets = $2::CIDR[], src_nets_negated = $3::CIDR[], dst_nets = $4::CIDR[], dst_nets_negated = $5::CIDR[]
+            WHERE sid = $1`, sid, textArray(a.src.Nets), textArray(a.src.Negated), textArray(a.dst.Nets), textArray(a.dst.Negated))
+		if err != nil {
+			return 0, fmt.Errorf("Ошибка обновления сетей заголовка (SID: %s): %v", sid, err)
+		}
+	}
+	return len(updates), nil
+}
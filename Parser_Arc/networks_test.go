@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHeaderNetworks(t *testing.T) {
+	tests := []struct {
+		spec    string
+		nets    []string
+		negated []string
+	}{
+		{"any", nil, nil},
+		{"$HOME_NET", nil, nil},
+		{"192.0.2.1", []string{"192.0.2.1"}, nil},
+		{"192.0.2.17/24", []string{"192.0.2.0/24"}, nil},
+		{"!192.0.2.1", nil, []string{"192.0.2.1"}},
+		{"[10.0.0.0/8, !10.1.0.0/16, $DNS_SERVERS]", []string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}},
+		{"![192.0.2.1,198.51.100.0/24]", nil, []string{"192.0.2.1", "198.51.100.0/24"}},
+		// Вложенное отрицание: 10.1.0.0/16 исключено из исключения и снова проверяется.
+		{"![10.0.0.0/8,![10.1.0.0/16]]", []string{"10.1.0.0/16"}, []string{"10.0.0.0/8"}},
+		{"[2001:db8::/32,!2001:db8::1]", []string{"2001:db8::/32"}, []string{"2001:db8::1"}},
+	}
+	for _, tt := range tests {
+		got := parseHeaderNetworks(tt.spec)
+		if !reflect.DeepEqual(got.Nets, tt.nets) || !reflect.DeepEqual(got.Negated, tt.negated) {
+			t.Errorf("parseHeaderNetworks(%s) = %+v, ожидалось {Nets:%v Negated:%v}", tt.spec, got, tt.nets, tt.negated)
+		}
+	}
+}
+
+func TestSplitAddressList(t *testing.T) {
+	got := splitAddressList("1.1.1.1,[2.2.2.2,3.3.3.3],!4.4.4.4")
+	want := []string{"1.1.1.1", "[2.2.2.2,3.3.3.3]", "!4.4.4.4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitAddressList = %q, ожидалось %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Состояние покрытия CVE правилами.
+const (
+	CoverageActive   = "active"   // есть включённые правила
+	CoverageDisabled = "disabled" // правила есть, но все отключены
+	CoverageNone     = "none"     // правил нет
+)
+
+// CVECoverage - покрытие CVE правилами: общее состояние и правила по источникам.
+type CVECoverage struct {
+	CVE     string           `json:"cve"`
+	Status  string           `json:"status"`
+	Sources []SourceCoverage `json:"sources"`
+}
+
+// SourceCoverage - правила одного источника со ссылкой на CVE.
+type SourceCoverage struct {
+	Source   string   `json:"source"`
+	Status   string   `json:"status"`
+	Active   []string `json:"active"`   // sid включённых правил
+	Disabled []string `json:"disabled"` // sid отключённых правил
+}
+
+// Максимальное число CVE в одном запросе покрытия.
+const maxCoverageCVEs = 1000
+
+// referenceKey приводит значение reference к виду для поиска: CVE-2021-44228 для cve,
+// адрес без схемы и завершающего "/" в нижнем регистре для url, значение в нижнем
+// регистре для остальных типов (bugtraq, md5...).
+func referenceKey(ref Reference) string {
+	value := strings.TrimSpace(ref.Value)
+	switch strings.ToLower(strings.TrimSpace(ref.Type)) {
+	case "cve":
+		return "CVE-" + normalizeCVE(value)
+	case "url":
+		value = strings.ToLower(value)
+		value = strings.TrimPrefix(strings.TrimPrefix(value, "https://"), "http://")
+		return strings.TrimSuffix(value, "/")
+	}
+	return strings.ToLower(value)
+}
+
+// saveReferences заменяет сохранённые ссылки reference сигнатуры.
+func saveReferences(db *sql.DB, sid string, refs []Reference) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM signature_references WHERE sid = $1`, sid); err != nil {
+		return fmt.Errorf("Ошибка удаления ссылок (SID: %s): %v", sid, err)
+	}
+	for _, ref := range refs {
+		_, err := tx.Exec(`INSERT INTO signature_references (sid, type, value, key, url) VALUES ($1, $2, $3, $4, $5)`,
+			sid, strings.ToLower(ref.Type), ref.Value, referenceKey(ref), nullIfEmpty(ref.URL()))
+		if err != nil {
+			return fmt.Errorf("Ошибка записи ссылки (SID: %s): %v", sid, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return nil
+}
+
+// refreshReferences заново заполняет signature_references по сохранённым опциям, например
+// для сигнатур, загруженных до появления таблицы ссылок.
+func refreshReferences(db *sql.DB) (int, error) {
+	refs := map[string][]Reference{}
+	err := querySignatures(db, "deleted_at IS NULL AND options != '[]'::JSONB", nil, func(sig Signature) error {
+		refs[sig.SID] = catalogEntry(sig).References
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := db.Exec(`DELETE FROM signature_references WHERE sid NOT IN (SELECT sid FROM signatures WHERE deleted_at IS NULL)`); err != nil {
+		return 0, fmt.Errorf("Ошибка удаления ссылок удалённых сигнатур: %v", err)
+	}
+	for sid, list := range refs {
+		if err := saveReferences(db, sid, list); err != nil {
+			return 0, err
+		}
+	}
+	return len(refs), nil
+}
+
+// normalizeCVEList проверяет идентификаторы CVE и приводит их к виду CVE-2021-44228
+// без повторов, сохраняя порядок.
+func normalizeCVEList(list []string) ([]string, error) {
+	var cves []string
+	seen := map[string]bool{}
+	for _, cve := range list {
+		if strings.TrimSpace(cve) == "" {
+			continue
+		}
+		id := normalizeCVE(cve)
+		if !cveRe.MatchString(id) {
+			return nil, fmt.Errorf("Некорректный идентификатор CVE: %s", cve)
+		}
+		if key := "CVE-" + id; !seen[key] {
+			seen[key] = true
+			cves = append(cves, key)
+		}
+	}
+	if len(cves) == 0 {
+		return nil, fmt.Errorf("Не указаны CVE")
+	}
+	if len(cves) > maxCoverageCVEs {
+		return nil, fmt.Errorf("Слишком много CVE в запросе: %d, допустимо %d", len(cves), maxCoverageCVEs)
+	}
+	return cves, nil
+}
+
+// cveCoverage сообщает для каждого CVE, есть ли действующие правила со ссылкой на него:
+// включённые, только отключённые или никаких, с разбивкой по источникам. sources
+// ограничивает источники; CVE передаются в виде normalizeCVEList.
+func cveCoverage(db *sql.DB, cves []string, sources []string) ([]CVECoverage, error) {
+	query := `
+        SELECT r.key, COALESCE(s.source, ''), s.sid, COALESCE(s.enabled_override, s.enabled, TRUE)
+        FROM signature_references r
+        JOIN signatures s ON s.sid = r.sid
+        WHERE r.type = 'cve' AND r.key = ANY($1) AND s.deleted_at IS NULL`
+	args := []interface{}{pq.Array(cves)}
+	if len(sources) > 0 {
+		query += ` AND s.source = ANY($2)`
+		args = append(args, pq.Array(sources))
+	}
+	query += ` ORDER BY substring(s.sid from '^[0-9]+$')::NUMERIC NULLS LAST, s.sid`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	found := map[string]map[string]*SourceCoverage{}
+	for rows.Next() {
+		var cve, source, sid string
+		var enabled bool
+		if err := rows.Scan(&cve, &source, &sid, &enabled); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		if found[cve] == nil {
+			found[cve] = map[string]*SourceCoverage{}
+		}
+		sc := found[cve][source]
+		if sc == nil {
+			sc = &SourceCoverage{Source: source, Active: []string{}, Disabled: []string{}}
+			found[cve][source] = sc
+		}
+		if enabled {
+			sc.Active = append(sc.Active, sid)
+		} else {
+			sc.Disabled = append(sc.Disabled, sid)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+
+	coverage := make([]CVECoverage, 0, len(cves))
+	for _, cve := range cves {
+		c := CVECoverage{CVE: cve, Status: CoverageNone, Sources: []SourceCoverage{}}
+		for _, sc := range found[cve] {
+			sc.Status = coverageStatus(len(sc.Active), len(sc.Disabled))
+			c.Sources = append(c.Sources, *sc)
+			switch {
+			case sc.Status == CoverageActive:
+				c.Status = CoverageActive
+			case c.Status == CoverageNone:
+				c.Status = CoverageDisabled
+			}
+		}
+		sort.Slice(c.Sources, func(i, j int) bool { return c.Sources[i].Source < c.Sources[j].Source })
+		coverage = append(coverage, c)
+	}
+	return coverage, nil
+}
+
+func coverageStatus(active, disabled int) string {
+	switch {
+	case active > 0:
+		return CoverageActive
+	case disabled > 0:
+		return CoverageDisabled
+	}
+	return CoverageNone
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestReferenceKey(t *testing.T) {
+	tests := []struct {
+		ref  Reference
+		want string
+	}{
+		{Reference{"cve", "2021-44228"}, "CVE-2021-44228"},
+		{Reference{"CVE", " cve-2021-44228 "}, "CVE-2021-44228"},
+		{Reference{"cve", "CVE-2014-0160"}, "CVE-2014-0160"},
+		{Reference{"url", "https://Example.com/Path/"}, "example.com/path"},
+		{Reference{"url", "http://example.com/a?b=1"}, "example.com/a?b=1"},
+		{Reference{"url", "www.example.com"}, "www.example.com"},
+		{Reference{"md5", "D41D8CD98F00B204E9800998ECF8427E"}, "d41d8cd98f00b204e9800998ecf8427e"},
+		{Reference{"bugtraq", " 12345 "}, "12345"},
+	}
+	for _, tt := range tests {
+		if got := referenceKey(tt.ref); got != tt.want {
+			t.Errorf("referenceKey(%+v) = %s, ожидалось %s", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCVEList(t *testing.T) {
+	got, err := normalizeCVEList([]string{"CVE-2021-44228", "cve-2021-44228", " 2014-0160 ", "", "CVE-2023-123456"})
+	if err != nil {
+		t.Fatalf("normalizeCVEList: %v", err)
+	}
+	want := []string{"CVE-2021-44228", "CVE-2014-0160", "CVE-2023-123456"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeCVEList = %v, ожидалось %v", got, want)
+	}
+
+	for _, list := range [][]string{
+		nil,
+		{"", " "},
+		{"CVE-2021-44228", "CVE-21-1"},
+		{"log4shell"},
+	} {
+		if _, err := normalizeCVEList(list); err == nil {
+			t.Errorf("ожидалась ошибка для %q", list)
+		}
+	}
+
+	many := make([]string, maxCoverageCVEs+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("CVE-2024-%05d", i)
+	}
+	if _, err := normalizeCVEList(many); err == nil {
+		t.Errorf("ожидалась ошибка для %d CVE", len(many))
+	}
+	if _, err := normalizeCVEList(many[:maxCoverageCVEs]); err != nil {
+		t.Errorf("normalizeCVEList(%d CVE): %v", maxCoverageCVEs, err)
+	}
+}
+
+func TestCoverageStatus(t *testing.T) {
+	tests := []struct {
+		active, disabled int
+		want             string
+	}{
+		{0, 0, CoverageNone},
+		{0, 2, CoverageDisabled},
+		{1, 0, CoverageActive},
+		{1, 3, CoverageActive},
+	}
+	for _, tt := range tests {
+		if got := coverageStatus(tt.active, tt.disabled); got != tt.want {
+			t.Errorf("coverageStatus(%d, %d) = %s, ожидалось %s", tt.active, tt.disabled, got, tt.want)
+		}
+	}
+}
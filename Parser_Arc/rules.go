@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rule - разобранное правило Snort/Suricata.
+type Rule struct {
+	Action    string
+	Proto     string
+	SrcIP     string
+	SrcPort   string
+	Direction string
+	DstIP     string
+	DstPort   string
+	Options   []RuleOption
+	Enabled   bool   // false для закомментированных правил
+	Dialect   string // snort2, snort3 или suricata, см. applyDialect
+	Raw       string // исходный текст правила без символа комментария
+}
+
+// RuleOption - опция правила в виде "name:value;" или "name;".
+type RuleOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+var ruleActions = map[string]bool{
+	"alert":      true,
+	"drop":       true,
+	"pass":       true,
+	"reject":     true,
+	"rejectsrc":  true,
+	"rejectdst":  true,
+	"rejectboth": true,
+	"sdrop":      true,
+	"log":        true,
+	"activate":   true,
+	"dynamic":    true,
+	"block":      true,
+	"react":      true,
+	"rewrite":    true,
+}
+
+// ruleLine - текст одного правила в файле.
+type ruleLine struct {
+	Text     string
+	Disabled bool // правило закомментировано
+	Line     int  // номер последней строки правила в файле, начиная с 0
+}
+
+// splitRuleLines разбивает содержимое .rules файла на тексты отдельных правил.
+// Строки с "\" в конце склеиваются со следующей, закомментированные правила
+// возвращаются с флагом Disabled, обычные комментарии и пустые строки пропускаются.
+func splitRuleLines(content string) []ruleLine {
+	var lines []ruleLine
+	var current strings.Builder
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		current.WriteString(line)
+		text := strings.TrimSpace(current.String())
+		current.Reset()
+
+		off := false
+		if strings.HasPrefix(text, "#") {
+			text = strings.TrimSpace(strings.TrimLeft(text, "#"))
+			off = true
+		}
+		if text == "" || !ruleActions[firstWord(text)] {
+			continue
+		}
+		lines = append(lines, ruleLine{Text: text, Disabled: off, Line: i})
+	}
+	return lines
+}
+
+// parseRules разбирает все правила файла. Ошибки разбора закомментированных
+// строк не возвращаются: это обычный текст, похожий на правило.
+func parseRules(content string) ([]*Rule, []error) {
+	var rules []*Rule
+	var errs []error
+	for _, line := range splitRuleLines(content) {
+		rule, err := parseRule(line.Text)
+		if err != nil {
+			if !line.Disabled {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		rule.Enabled = !line.Disabled
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+// parseRule разбирает одно правило вида
+// action proto src_ip src_port direction dst_ip dst_port (options)
+// или правило сервиса Snort 3 вида action service (options).
+func parseRule(text string) (*Rule, error) {
+	open := strings.Index(text, "(")
+	end := strings.LastIndex(text, ")")
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("Не найден блок опций в правиле: %s", text)
+	}
+
+	header := splitHeader(text[:open])
+	if len(header) != 7 && len(header) != 2 {
+		return nil, fmt.Errorf("Некорректный заголовок правила: %s", strings.TrimSpace(text[:open]))
+	}
+	if !ruleActions[header[0]] {
+		return nil, fmt.Errorf("Неизвестное действие правила: %s", header[0])
+	}
+	if len(header) == 7 && header[4] != "->" && header[4] != "<>" {
+		return nil, fmt.Errorf("Некорректное направление правила: %s", header[4])
+	}
+
+	options, err := splitOptions(text[open+1 : end])
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &Rule{
+		Action:  header[0],
+		Proto:   header[1],
+		Options: options,
+		Enabled: true,
+		Raw:     text,
+	}
+	if len(header) == 7 {
+		rule.SrcIP, rule.SrcPort, rule.Direction = header[2], header[3], header[4]
+		rule.DstIP, rule.DstPort = header[5], header[6]
+	}
+	return rule, nil
+}
+
+// String собирает текст правила из заголовка и опций.
+func (r *Rule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Action + " " + r.Proto)
+	if r.Direction != "" {
+		fmt.Fprintf(&b, " %s %s %s %s %s", r.SrcIP, r.SrcPort, r.Direction, r.DstIP, r.DstPort)
+	}
+	b.WriteString(" (")
+	for i, opt := range r.Options {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(opt.Name)
+		if opt.Value != "" {
+			b.WriteString(":" + opt.Value)
+		}
+		b.WriteString(";")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// splitHeader разбивает заголовок по пробелам, не разрывая списки в [...].
+func splitHeader(header string) []string {
+	var fields []string
+	var current strings.Builder
+	depth := 0
+	for _, r := range header {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case (r == ' ' || r == '\t') && depth == 0:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// splitOptions разбивает блок опций по ";" с учётом кавычек и экранирования.
+func splitOptions(body string) ([]RuleOption, error) {
+	var options []RuleOption
+	var current strings.Builder
+	inQuote, escaped := false, false
+
+	flush := func() {
+		opt := strings.TrimSpace(current.String())
+		current.Reset()
+		if opt == "" {
+			return
+		}
+		name, value, _ := strings.Cut(opt, ":")
+		options = append(options, RuleOption{
+			Name:  strings.ToLower(strings.TrimSpace(name)),
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	for _, r := range body {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case r == ';' && !inQuote:
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if inQuote {
+		return nil, fmt.Errorf("Незакрытая кавычка в опциях правила")
+	}
+	flush()
+	return options, nil
+}
+
+// Option возвращает значение первой опции с указанным именем.
+func (r *Rule) Option(name string) (string, bool) {
+	for _, opt := range r.Options {
+		if opt.Name == name {
+			return opt.Value, true
+		}
+	}
+	return "", false
+}
+
+// Values возвращает значения всех опций с указанным именем.
+func (r *Rule) Values(name string) []string {
+	var values []string
+	for _, opt := range r.Options {
+		if opt.Name == name {
+			values = append(values, opt.Value)
+		}
+	}
+	return values
+}
+
+// unquote снимает кавычки со значения опции и раскрывает экранирование \" \; \\.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		if escaped && r != '"' && r != ';' && r != '\\' {
+			b.WriteRune('\\')
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	if escaped {
+		b.WriteRune('\\')
+	}
+	return b.String()
+}
+
+// quote заключает строку в кавычки и экранирует ", ; и \ - обратное преобразование к unquote.
+func quote(value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `;`, `\;`)
+	return `"` + r.Replace(value) + `"`
+}
+
+// equalRules сравнивает правила по заголовку и опциям без учёта форматирования текста.
+func equalRules(a, b *Rule) bool {
+	if a.Action != b.Action || a.Proto != b.Proto || a.SrcIP != b.SrcIP || a.SrcPort != b.SrcPort ||
+		a.Direction != b.Direction || a.DstIP != b.DstIP || a.DstPort != b.DstPort ||
+		len(a.Options) != len(b.Options) {
+		return false
+	}
+	for i := range a.Options {
+		if a.Options[i].Name != b.Options[i].Name ||
+			strings.TrimSpace(a.Options[i].Value) != strings.TrimSpace(b.Options[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func firstWord(s string) string {
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := parseRule(`alert tcp [10.0.0.0/8, !10.1.0.0/16] any -> $HOME_NET [80,443] (msg:"a \"b\"\; c"; content:"x;y"; nocase; sid:1; rev:2;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []string{rule.Action, rule.Proto, rule.SrcIP, rule.SrcPort, rule.Direction, rule.DstIP, rule.DstPort}
+	want := []string{"alert", "tcp", "[10.0.0.0/8, !10.1.0.0/16]", "any", "->", "$HOME_NET", "[80,443]"}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("заголовок %q, ожидалось %q", header, want)
+	}
+	options := []RuleOption{
+		{Name: "msg", Value: `"a \"b\"\; c"`},
+		{Name: "content", Value: `"x;y"`},
+		{Name: "nocase"},
+		{Name: "sid", Value: "1"},
+		{Name: "rev", Value: "2"},
+	}
+	if !reflect.DeepEqual(rule.Options, options) {
+		t.Errorf("опции %+v, ожидалось %+v", rule.Options, options)
+	}
+	if !rule.Enabled {
+		t.Error("правило должно быть включено")
+	}
+
+	// Правило сервиса Snort 3: только действие и сервис.
+	service, err := parseRule(`alert http (msg:"x"; sid:2;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Proto != "http" || service.Direction != "" {
+		t.Errorf("правило сервиса разобрано как %+v", service)
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, text := range []string{
+		`alert tcp any any -> any any msg:"x"; sid:1;`,
+		`alert tcp any any any any (sid:1;)`,
+		`unknown tcp any any -> any any (sid:1;)`,
+		`alert tcp any any => any any (sid:1;)`,
+		`alert tcp any any -> any any (msg:"x; sid:1;)`,
+	} {
+		if _, err := parseRule(text); err == nil {
+			t.Errorf("ожидалась ошибка для %s", text)
+		}
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	tests := []struct {
+		body string
+		want []RuleOption
+	}{
+		{`msg:"x"; sid:1;`, []RuleOption{{"msg", `"x"`}, {"sid", "1"}}},
+		{` Content : "a" ;NOCASE;`, []RuleOption{{"content", `"a"`}, {"nocase", ""}}},
+		{`content:"a\;b"; pcre:"/a\;b/";`, []RuleOption{{"content", `"a\;b"`}, {"pcre", `"/a\;b/"`}}},
+		{`content:"a;b"`, []RuleOption{{"content", `"a;b"`}}},
+		{`content:"a\"; b"; sid:1;`, []RuleOption{{"content", `"a\"; b"`}, {"sid", "1"}}},
+		{`metadata:created_at 2024_01_01, tag a:b;`, []RuleOption{{"metadata", "created_at 2024_01_01, tag a:b"}}},
+		{``, nil},
+	}
+	for _, tt := range tests {
+		got, err := splitOptions(tt.body)
+		if err != nil {
+			t.Errorf("splitOptions(%s): %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitOptions(%s) = %+v, ожидалось %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	content := "# comment\n" +
+		"alert tcp any any -> any any (msg:\"a\"; \\\n  sid:1;)\n" +
+		"# alert tcp any any -> any any (msg:\"b\"; sid:2;)\n" +
+		"#alert tcp any any -> any any (msg:\"broken; sid:3;)\n" +
+		"alert tcp any any -> any any (msg:\"broken; sid:4;)\n"
+	rules, errs := parseRules(content)
+	if len(rules) != 2 {
+		t.Fatalf("разобрано правил %d, ожидалось 2", len(rules))
+	}
+	if sid, _ := rules[0].Option("sid"); sid != "1" || !rules[0].Enabled {
+		t.Errorf("первое правило %+v", rules[0])
+	}
+	if sid, _ := rules[1].Option("sid"); sid != "2" || rules[1].Enabled {
+		t.Errorf("закомментированное правило %+v", rules[1])
+	}
+	// Ошибка разбора закомментированного правила не возвращается.
+	if len(errs) != 1 {
+		t.Errorf("ошибок %d, ожидалась 1: %v", len(errs), errs)
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	tests := []struct {
+		quoted, plain string
+	}{
+		{`"abc"`, "abc"},
+		{`"a \"b\"\; c"`, `a "b"; c`},
+		{`"C:\\Windows"`, `C:\Windows`},
+	}
+	for _, tt := range tests {
+		if got := unquote(tt.quoted); got != tt.plain {
+			t.Errorf("unquote(%s) = %s, ожидалось %s", tt.quoted, got, tt.plain)
+		}
+		if got := quote(tt.plain); got != tt.quoted {
+			t.Errorf("quote(%s) = %s, ожидалось %s", tt.plain, got, tt.quoted)
+		}
+	}
+	// Прочие последовательности \x остаются как есть.
+	if got := unquote(`"a\x41\"`); got != `a\x41\` {
+		t.Errorf("unquote = %s", got)
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SignatureSearch - параметры поиска сигнатур. Filter задаёт sid, classtype, source,
+// proto, tag и другие условия фильтра экспорта; поиск находит и отключённые правила.
+type SignatureSearch struct {
+	Filter  ExportFilter
+	Text    string // полнотекстовый поиск по msg
+	CVE     string // CVE из опций reference: CVE-2021-44228 или 2021-44228
+	IP      string // адрес или подсеть: правила, сети заголовка которых их содержат или пересекают
+	IPSide  string // src, dst или пусто - любая сторона
+	Enabled *bool  // nil - независимо от состояния
+	Limit   int
+	Offset  int
+
+	// Поиск по content. Строки задаются в синтаксисе content: "abc|0d 0a|".
+	Content string // подстрока значения content
+	Data    string // данные, в которых находятся все content правила (без учёта depth, offset...)
+	Buffer  string // буфер content: http_uri, http.uri, dns_query...
+
+	content, data []byte
+}
+
+// SignatureRecord - сигнатура в ответах API: запись каталога, метки аналитиков и
+// время добавления, изменения и удаления в БД.
+type SignatureRecord struct {
+	CatalogEntry
+	Tags       []string       `json:"tags"`
+	StoredAt   time.Time      `json:"stored_at"`
+	ModifiedAt *time.Time     `json:"modified_at,omitempty"`
+	DeletedAt  *time.Time     `json:"deleted_at,omitempty"`
+	Contents   []ContentMatch `json:"contents,omitempty"`
+}
+
+// ContentMatch - content сигнатуры; Matched отмечает content, найденные поиском по content.
+type ContentMatch struct {
+	RuleContent
+	Matched bool `json:"matched,omitempty"`
+}
+
+// HistoryEntry - событие истории сигнатуры.
+type HistoryEntry struct {
+	Event   string    `json:"event"`
+	Actor   string    `json:"actor,omitempty"`
+	Comment string    `json:"comment,omitempty"`
+	Details string    `json:"details,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// Ограничения размера страницы поиска.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// validate проверяет параметры поиска и подставляет размер страницы по умолчанию.
+func (s *SignatureSearch) validate() error {
+	if err := s.Filter.validate(); err != nil {
+		return err
+	}
+	if s.IP != "" {
+		network, ok := normalizeIP(strings.TrimSpace(s.IP))
+		if !ok {
+			return fmt.Errorf("Некорректный IP-адрес или подсеть: %s", s.IP)
+		}
+		s.IP = network
+	}
+	if s.IPSide != "" && s.IPSide != "src" && s.IPSide != "dst" {
+		return fmt.Errorf("Сторона адреса должна быть src или dst: %s", s.IPSide)
+	}
+	if s.CVE != "" && !cveRe.MatchString(normalizeCVE(s.CVE)) {
+		return fmt.Errorf("Некорректный идентификатор CVE: %s", s.CVE)
+	}
+	if s.Limit <= 0 {
+		s.Limit = defaultSearchLimit
+	}
+	if s.Limit > maxSearchLimit {
+		s.Limit = maxSearchLimit
+	}
+	if s.Offset < 0 {
+		return fmt.Errorf("Некорректное смещение: %d", s.Offset)
+	}
+	var err error
+	if s.Content != "" {
+		if s.content, _, err = decodeContent(s.Content); err != nil {
+			return err
+		}
+	}
+	if s.Data != "" {
+		if s.data, _, err = decodeContent(s.Data); err != nil {
+			return err
+		}
+	}
+	if s.Buffer != "" {
+		if s.content == nil && s.data == nil {
+			return fmt.Errorf("Буфер задаётся вместе с поиском по content")
+		}
+		s.Buffer = normalizeBuffer(s.Buffer)
+	}
+	return nil
+}
+
+// matchContent сообщает, найден ли content поиском: content содержит искомую подстроку
+// или (без отрицания) встречается в данных. nocase учитывается как в движках.
+func (s SignatureSearch) matchContent(c RuleContent) bool {
+	if s.Buffer != "" && c.Buffer != s.Buffer {
+		return false
+	}
+	contains := func(data, sub []byte) bool {
+		return bytes.Contains(data, sub) || c.Nocase && bytes.Contains(asciiLower(data), asciiLower(sub))
+	}
+	return s.content != nil && contains(c.Pattern, s.content) ||
+		s.data != nil && !c.Negated && contains(s.data, c.Pattern)
+}
+
+// contentMatches возвращает content сигнатуры с отметками найденных поиском.
+func (s SignatureSearch) contentMatches(entry CatalogEntry) []ContentMatch {
+	var matches []ContentMatch
+	for _, c := range ruleContents(&Rule{Options: entry.Options, Dialect: entry.Dialect}) {
+		matches = append(matches, ContentMatch{RuleContent: c, Matched: s.matchContent(c)})
+	}
+	return matches
+}
+
+var (
+	cveRe = regexp.MustCompile(`^[0-9]{4}-[0-9]{4,}$`)
+	tagRe = regexp.MustCompile(`^[\p{L}\p{N}_.:+-]{1,64}$`)
+)
+
+// normalizeCVE приводит CVE-2021-44228, cve-2021-44228 и 2021-44228 к виду 2021-44228.
+func normalizeCVE(cve string) string {
+	cve = strings.ToUpper(strings.TrimSpace(cve))
+	return strings.TrimPrefix(cve, "CVE-")
+}
+
+// where строит условие поиска по действующим правилам.
+func (s SignatureSearch) where() (string, []interface{}) {
+	conds, args := s.Filter.conditions(nil)
+	conds = append([]string{"deleted_at IS NULL"}, conds...)
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if s.Enabled != nil {
+		conds = append(conds, fmt.Sprintf("COALESCE(enabled_override, enabled, TRUE) = %s", arg(*s.Enabled)))
+	}
+	if s.Text != "" {
+		conds = append(conds, fmt.Sprintf("to_tsvector('simple', COALESCE(msg, '')) @@ plainto_tsquery('simple', %s)", arg(s.Text)))
+	}
+	if s.CVE != "" {
+		conds = append(conds, fmt.Sprintf(`sid IN (SELECT r.sid FROM signature_references r WHERE r.type = 'cve' AND r.key = %s)`,
+			arg(referenceKey(Reference{Type: "cve", Value: s.CVE}))))
+	}
+	if s.IP != "" {
+		// Сторона подходит, если одна из её сетей пересекается с искомой и искомая не попадает
+		// целиком в исключённую (!) сеть, внутри которой нет более узкой разрешённой сети
+		// (![10.0.0.0/8,![10.1.0.0/16]]). Переменные и any не раскрываются.
+		ip := arg(s.IP)
+		var sides []string
+		for _, side := range []string{"src", "dst"} {
+			if s.IPSide == "" || s.IPSide == side {
+				sides = append(sides, fmt.Sprintf(`(
+            EXISTS (SELECT 1 FROM unnest(%[1]s_nets) n WHERE n && %[2]s::INET) AND
+            NOT EXISTS (
+                SELECT 1 FROM unnest(%[1]s_nets_negated) x WHERE %[2]s::INET <<= x AND
+                NOT EXISTS (SELECT 1 FROM unnest(%[1]s_nets) n WHERE %[2]s::INET <<= n AND n << x)
+            )
+        )`, side, ip))
+			}
+		}
+		conds = append(conds, "("+strings.Join(sides, " OR ")+")")
+	}
+	if s.content != nil || s.data != nil {
+		buffer := "TRUE"
+		if s.Buffer != "" {
+			buffer = "c.buffer = " + arg(s.Buffer)
+		}
+		if s.content != nil {
+			conds = append(conds, fmt.Sprintf(`sid IN (
+            SELECT c.sid FROM signature_contents c
+            WHERE %s AND (position(%s IN c.pattern) > 0 OR c.nocase AND position(%s IN c.pattern_lower) > 0)
+        )`, buffer, arg(s.content), arg(asciiLower(s.content))))
+		}
+		if s.data != nil {
+			// Правило подходит, если все его content без отрицания есть в данных, а content
+			// с отрицанием - нет. При заданном буфере учитываются только content этого буфера.
+			data, lower := arg(s.data), arg(asciiLower(s.data))
+			conds = append(conds, fmt.Sprintf(`sid IN (
+            SELECT c.sid FROM signature_contents c
+            WHERE %s
+            GROUP BY c.sid
+            HAVING bool_or(NOT c.negated) AND bool_and(
+                (position(c.pattern IN %s) > 0 OR c.nocase AND position(c.pattern_lower IN %s) > 0) != c.negated
+            )
+        )`, buffer, data, lower))
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// searchSignatures возвращает страницу найденных сигнатур в порядке (gid, sid) и их общее число.
+func searchSignatures(db *sql.DB, s SignatureSearch) ([]SignatureRecord, int, error) {
+	where, args := s.where()
+	args = append(args, s.Limit, s.Offset)
+	rows, err := db.Query(fmt.Sprintf(`
+        SELECT `+signatureColumns+`, COALESCE(tags, '{}'), created_at, updated_at, deleted_at, COUNT(*) OVER ()
+        FROM signatures
+        WHERE %s`+exportOrder+`
+        LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	records := []SignatureRecord{}
+	total := 0
+	for rows.Next() {
+		record, err := scanSignatureRecord(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		record.Contents = s.contentMatches(record.CatalogEntry)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return records, total, nil
+}
+
+// loadSignatureRecord возвращает сигнатуру по sid, в том числе удалённую, или nil, если её нет.
+func loadSignatureRecord(db *sql.DB, sid string) (*SignatureRecord, error) {
+	row := db.QueryRow(`
+        SELECT `+signatureColumns+`, COALESCE(tags, '{}'), created_at, updated_at, deleted_at
+        FROM signatures
+        WHERE sid = $1`, sid)
+	record, err := scanSignatureRecord(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record.Contents = SignatureSearch{}.contentMatches(record.CatalogEntry)
+	return &record, nil
+}
+
+func scanSignatureRecord(row interface{ Scan(...interface{}) error }, extra ...interface{}) (SignatureRecord, error) {
+	var record SignatureRecord
+	var tags pq.StringArray
+	var stored, modified, deleted sql.NullTime
+	sig, err := scanSignature(row, append([]interface{}{&tags, &stored, &modified, &deleted}, extra...)...)
+	if err != nil {
+		return record, err
+	}
+	record.CatalogEntry = catalogEntry(sig)
+	record.Tags = nonNil(tags)
+	record.StoredAt = stored.Time
+	if modified.Valid {
+		record.ModifiedAt = &modified.Time
+	}
+	if deleted.Valid {
+		record.DeletedAt = &deleted.Time
+	}
+	return record, nil
+}
+
+// signatureHistory возвращает историю сигнатуры, новые события первыми.
+func signatureHistory(db *sql.DB, sid string) ([]HistoryEntry, error) {
+	rows, err := db.Query(`
+        SELECT event, COALESCE(actor, ''), COALESCE(comment, ''), COALESCE(details, ''), created_at
+        FROM signature_history
+        WHERE sid = $1
+        ORDER BY id DESC`, sid)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	history := []HistoryEntry{}
+	for rows.Next() {
+		var h HistoryEntry
+		if err := rows.Scan(&h.Event, &h.Actor, &h.Comment, &h.Details, &h.At); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return history, nil
+}
+
+// changeTags меняет метки аналитиков сигнатуры функцией change и записывает новый
+// список в историю. Возвращает метки после изменения.
+func changeTags(db *sql.DB, sid string, change func([]string) []string, note ChangeNote) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current pq.StringArray
+	err = tx.QueryRow(`SELECT COALESCE(tags, '{}') FROM signatures WHERE sid = $1 AND deleted_at IS NULL FOR UPDATE`, sid).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("Сигнатуры не найдены: %s", sid)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения меток (SID: %s): %v", sid, err)
+	}
+
+	tags := normalizeTags(change(append([]string{}, current...)))
+	if err := checkTags(tags); err != nil {
+		return nil, err
+	}
+	if strings.Join(tags, ",") == strings.Join(normalizeTags(current), ",") {
+		return tags, nil
+	}
+	// updated_at меняется: метки участвуют в фильтре экспорта и дельтах.
+	if _, err := tx.Exec(`UPDATE signatures SET tags = $2, updated_at = CURRENT_TIMESTAMP WHERE sid = $1`, sid, pq.Array(tags)); err != nil {
+		return nil, fmt.Errorf("Ошибка изменения меток (SID: %s): %v", sid, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO signature_history (sid, event, actor, comment, details) VALUES ($1, 'tags', $2, $3, $4)`,
+		sid, nullIfEmpty(note.Actor), nullIfEmpty(note.Comment), strings.Join(tags, ",")); err != nil {
+		return nil, fmt.Errorf("Ошибка записи истории (SID: %s): %v", sid, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return tags, nil
+}
+
+// checkTags проверяет метки: буквы, цифры и символы _ . : + - без пробелов и запятых.
+func checkTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagRe.MatchString(strings.TrimSpace(tag)) {
+			return fmt.Errorf("Недопустимая метка: %q", tag)
+		}
+	}
+	return nil
+}
+
+// normalizeTags убирает пустые и повторяющиеся метки и сортирует список.
+func normalizeTags(list []string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, tag := range list {
+		if tag = strings.TrimSpace(tag); tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+type Signature struct {
+	Type        string // действие правила: alert, drop, pass...
+	Proto       string
+	SrcIP       string
+	SrcPort     string
+	Direction   string
+	DstIP       string
+	DstPort     string
+	SID         string
+	Msg         string
+	Filename    string
+	Source      string // имя источника из locals.yaml
+	Local       bool   // правило из локального источника, имеет приоритет над правилами поставщиков
+	Enabled     bool   // состояние правила в файле поставщика
+	Metadata    RuleMetadata
+	Flowbits    []Flowbit
+	Options     []RuleOption // все опции правила в исходном порядке
+	Fingerprint string       // отпечаток канонической формы правила, см. ruleFingerprint
+	Dialect     string       // синтаксис правила: snort2, snort3 или suricata
+	MinVersion  string       // минимальная версия Suricata, см. ruleMinVersion
+	Raw         string       // исходный текст правила, с которым сверяется выгрузка
+}
+
+// signatureFromRule заполняет сигнатуру из разобранного правила.
+func signatureFromRule(rule *Rule, filename string, source string) (Signature, error) {
+	sid, ok := rule.Option("sid")
+	if !ok || sid == "" {
+		return Signature{}, fmt.Errorf("В правиле отсутствует sid: %s", rule.Raw)
+	}
+	msg, _ := rule.Option("msg")
+
+	return Signature{
+		Type:        rule.Action,
+		Proto:       rule.Proto,
+		SrcIP:       rule.SrcIP,
+		SrcPort:     rule.SrcPort,
+		Direction:   rule.Direction,
+		DstIP:       rule.DstIP,
+		DstPort:     rule.DstPort,
+		SID:         sid,
+		Msg:         unquote(msg),
+		Filename:    filename,
+		Source:      source,
+		Enabled:     rule.Enabled,
+		Metadata:    parseMetadata(rule),
+		Flowbits:    parseFlowbits(rule),
+		Options:     rule.Options,
+		Fingerprint: ruleFingerprint(rule),
+		Dialect:     rule.Dialect,
+		MinVersion:  ruleMinVersion(rule),
+		Raw:         rule.Raw,
+	}, nil
+}
+
+// rule восстанавливает разобранное правило из сохранённой сигнатуры.
+func (s Signature) rule() *Rule {
+	// Правила сервиса Snort 3 не содержат адресов и направления.
+	direction := s.Direction
+	if direction == "" && s.SrcIP != "" {
+		direction = "->"
+	}
+	return &Rule{
+		Action:    s.Type,
+		Proto:     s.Proto,
+		SrcIP:     s.SrcIP,
+		SrcPort:   s.SrcPort,
+		Direction: direction,
+		DstIP:     s.DstIP,
+		DstPort:   s.DstPort,
+		Options:   s.Options,
+		Enabled:   s.Enabled,
+		Dialect:   s.Dialect,
+	}
+}
+
+// signatureColumns - столбцы сигнатуры в порядке scanSignature.
+const signatureColumns = `COALESCE(type, ''), COALESCE(proto, ''), COALESCE(src_ip, ''), COALESCE(src_port, ''),
+               COALESCE(direction, '->'), COALESCE(dst_ip, ''), COALESCE(dst_port, ''), sid,
+               COALESCE(msg, ''), COALESCE(filename, ''), COALESCE(source, ''), COALESCE(local, FALSE), COALESCE(enabled_override, enabled, TRUE),
+               COALESCE(flowbits, '[]'::JSONB), COALESCE(options, '[]'::JSONB), COALESCE(fingerprint, ''),
+               COALESCE(dialect, ''), COALESCE(min_engine_version, ''), COALESCE(rule_text, '')`
+
+// querySignatures вызывает fn для каждой сигнатуры, удовлетворяющей условию where.
+// Поле Enabled содержит итоговое состояние с учётом ручного включения/отключения.
+func querySignatures(db *sql.DB, where string, args []interface{}, fn func(Signature) error) error {
+	rows, err := db.Query(`
+        SELECT `+signatureColumns+`
+        FROM signatures
+        WHERE `+where, args...)
+	if err != nil {
+		return fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sig, err := scanSignature(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(sig); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return nil
+}
+
+// scanSignature читает сигнатуру из строки запроса со столбцами signatureColumns;
+// extra - столбцы, выбранные после них. Для QueryRow без строк возвращает sql.ErrNoRows.
+func scanSignature(row interface{ Scan(...interface{}) error }, extra ...interface{}) (Signature, error) {
+	var sig Signature
+	var flowbits, options []byte
+	dest := []interface{}{&sig.Type, &sig.Proto, &sig.SrcIP, &sig.SrcPort, &sig.Direction, &sig.DstIP, &sig.DstPort,
+		&sig.SID, &sig.Msg, &sig.Filename, &sig.Source, &sig.Local, &sig.Enabled, &flowbits, &options, &sig.Fingerprint,
+		&sig.Dialect, &sig.MinVersion, &sig.Raw}
+	if err := row.Scan(append(dest, extra...)...); err == sql.ErrNoRows {
+		return sig, err
+	} else if err != nil {
+		return sig, fmt.Errorf("Ошибка сканирования данных: %v", err)
+	}
+	if err := json.Unmarshal(flowbits, &sig.Flowbits); err != nil {
+		return sig, fmt.Errorf("Ошибка разбора flowbits (SID: %s): %v", sig.SID, err)
+	}
+	if err := json.Unmarshal(options, &sig.Options); err != nil {
+		return sig, fmt.Errorf("Ошибка разбора опций (SID: %s): %v", sig.SID, err)
+	}
+	return sig, nil
+}
+
+func initDB(db *sql.DB) error {
+	query := `
+CREATE TABLE IF NOT EXISTS signatures (
+    id SERIAL PRIMARY KEY,
+    type TEXT,
+    proto TEXT,
+    src_ip TEXT,
+    src_port TEXT,
+    dst_ip TEXT,
+    dst_port TEXT,
+    sid TEXT UNIQUE,
+    msg TEXT,
+    filename TEXT,
+    details JSONB DEFAULT '{}'::JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NULL,
+    deleted_at TIMESTAMP DEFAULT NULL
+);
+
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS details JSONB DEFAULT '{}'::JSONB;
+
+-- Поля из опции metadata правила
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS signature_severity TEXT;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS policies TEXT[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS rule_created_at DATE;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS rule_updated_at DATE;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS mitre_techniques TEXT[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS deployment TEXT[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS metadata JSONB DEFAULT '{}'::JSONB;
+
+-- Состояние правила: enabled - как в файле поставщика, enabled_override - ручное
+-- включение или отключение, которое имеет приоритет и не меняется при загрузке.
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS enabled BOOLEAN DEFAULT TRUE;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS enabled_override BOOLEAN DEFAULT NULL;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS flowbits JSONB DEFAULT '[]'::JSONB;
+
+-- Полное правило: направление и все опции в исходном порядке
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS direction TEXT DEFAULT '->';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS options JSONB DEFAULT '[]'::JSONB;
+
+-- Источник правила и отпечаток для поиска дублей между источниками
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS source TEXT;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS fingerprint TEXT;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS local BOOLEAN DEFAULT FALSE;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS dialect TEXT;
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS min_engine_version TEXT;
+
+-- Исходный текст правила из файла источника: выгрузка Suricata сверяется с ним
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS rule_text TEXT;
+
+-- Метки аналитиков (API), учитываются фильтром tag вместе с metadata tag
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS tags TEXT[] DEFAULT '{}';
+
+-- Сети из src_ip и dst_ip для поиска по адресу: указанные в заголовке и исключённые (!)
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS src_nets CIDR[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS src_nets_negated CIDR[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS dst_nets CIDR[] DEFAULT '{}';
+ALTER TABLE signatures ADD COLUMN IF NOT EXISTS dst_nets_negated CIDR[] DEFAULT '{}';
+
+-- История сигнатуры: загрузка и изменение правила (details - текст правила), удаление,
+-- включение и отключение с комментарием, изменение меток (details - новый список меток)
+CREATE TABLE IF NOT EXISTS signature_history (
+    id SERIAL PRIMARY KEY,
+    sid TEXT NOT NULL,
+    event TEXT NOT NULL,
+    actor TEXT,
+    comment TEXT,
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Опции content правил для поиска по содержимому: pattern - байты после раскрытия
+-- экранирования и участков |hex|, pattern_lower - они же в нижнем регистре ASCII (для nocase),
+-- buffer - буфер проверки (pkt_data, http_uri, dns_query...), modifiers - depth, offset и др.
+CREATE TABLE IF NOT EXISTS signature_contents (
+    id SERIAL PRIMARY KEY,
+    sid TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    buffer TEXT NOT NULL,
+    value TEXT NOT NULL,
+    pattern BYTEA NOT NULL,
+    pattern_lower BYTEA NOT NULL,
+    negated BOOLEAN NOT NULL DEFAULT FALSE,
+    nocase BOOLEAN NOT NULL DEFAULT FALSE,
+    modifiers JSONB NOT NULL DEFAULT '{}'::JSONB
+);
+
+-- Опции reference правил (cve, url, bugtraq, md5...): key - значение для поиска
+-- (CVE-2021-44228 для cve, адрес без схемы для url), url - ссылка по reference.config
+CREATE TABLE IF NOT EXISTS signature_references (
+    id SERIAL PRIMARY KEY,
+    sid TEXT NOT NULL,
+    type TEXT NOT NULL,
+    value TEXT NOT NULL,
+    key TEXT NOT NULL,
+    url TEXT
+);
+
+-- Правила, которые аналитики добавляют напрямую в БД (источник типа local)
+CREATE TABLE IF NOT EXISTS local_rules (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    comment TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NULL
+);
+
+-- Адреса из списков блокировки и файлов репутации (источники iplist и iprep)
+CREATE TABLE IF NOT EXISTS ip_indicators (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL,
+    address INET NOT NULL,
+    category TEXT NOT NULL DEFAULT '',
+    score INTEGER,
+    first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expired_at TIMESTAMP DEFAULT NULL,
+    UNIQUE (source, address, category)
+);
+
+-- Метки выгрузок профилей экспорта: время начала последней выгрузки и SHA-256 файла,
+-- относительно которых строится следующая дельта
+CREATE TABLE IF NOT EXISTS export_watermarks (
+    profile TEXT PRIMARY KEY,
+    exported_at TIMESTAMP NOT NULL,
+    sha256 TEXT
+);
+
+-- Загруженные архивы источников: хэши архивов, из которых собраны выгрузки
+CREATE TABLE IF NOT EXISTS source_archives (
+    id SERIAL PRIMARY KEY,
+    source TEXT NOT NULL,
+    archive TEXT NOT NULL,
+    sha256 TEXT NOT NULL,
+    size BIGINT,
+    loaded_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Снимки выгрузок: файлы снимка хранятся в каталоге хранилища снимков под номером id
+CREATE TABLE IF NOT EXISTS export_snapshots (
+    id SERIAL PRIMARY KEY,
+    profile TEXT NOT NULL,
+    format TEXT NOT NULL,
+    output TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sha256 TEXT NOT NULL,
+    rules INTEGER NOT NULL,
+    filter TEXT,
+    files JSONB NOT NULL DEFAULT '[]'::JSONB,
+    sources JSONB NOT NULL DEFAULT '[]'::JSONB
+);
+
+-- Время публикации снимка: выгрузка или откат. Раздача отдаёт последний опубликованный снимок профиля.
+ALTER TABLE export_snapshots ADD COLUMN IF NOT EXISTS published_at TIMESTAMP;
+
+-- Получение выгрузок сенсорами через раздачу (serve): какой снимок и когда получил сенсор
+CREATE TABLE IF NOT EXISTS sensor_fetches (
+    id SERIAL PRIMARY KEY,
+    sensor TEXT NOT NULL,
+    profile TEXT NOT NULL,
+    snapshot_id INTEGER,
+    file TEXT,
+    status INTEGER,
+    remote_addr TEXT,
+    fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS signatures_severity_idx ON signatures (lower(signature_severity));
+CREATE INDEX IF NOT EXISTS signatures_policies_idx ON signatures USING GIN (policies);
+CREATE INDEX IF NOT EXISTS signatures_rule_created_idx ON signatures (rule_created_at);
+CREATE INDEX IF NOT EXISTS signatures_rule_updated_idx ON signatures (rule_updated_at);
+CREATE INDEX IF NOT EXISTS signatures_mitre_idx ON signatures USING GIN (mitre_techniques);
+CREATE INDEX IF NOT EXISTS signatures_deployment_idx ON signatures USING GIN (deployment);
+CREATE INDEX IF NOT EXISTS signatures_metadata_idx ON signatures USING GIN (metadata);
+CREATE INDEX IF NOT EXISTS signatures_flowbits_idx ON signatures USING GIN (flowbits);
+CREATE INDEX IF NOT EXISTS signatures_source_idx ON signatures (source);
+CREATE INDEX IF NOT EXISTS signatures_fingerprint_idx ON signatures (fingerprint);
+CREATE INDEX IF NOT EXISTS signatures_updated_idx ON signatures (updated_at);
+CREATE INDEX IF NOT EXISTS signatures_deleted_idx ON signatures (deleted_at);
+CREATE INDEX IF NOT EXISTS signatures_tags_idx ON signatures USING GIN (tags);
+CREATE INDEX IF NOT EXISTS signatures_msg_fts_idx ON signatures USING GIN (to_tsvector('simple', COALESCE(msg, '')));
+CREATE INDEX IF NOT EXISTS signature_history_sid_idx ON signature_history (sid, id);
+CREATE INDEX IF NOT EXISTS signature_contents_sid_idx ON signature_contents (sid, position);
+CREATE INDEX IF NOT EXISTS signature_contents_buffer_idx ON signature_contents (buffer);
+CREATE INDEX IF NOT EXISTS signature_references_sid_idx ON signature_references (sid);
+CREATE INDEX IF NOT EXISTS signature_references_key_idx ON signature_references (type, key);
+CREATE INDEX IF NOT EXISTS source_archives_source_idx ON source_archives (source, loaded_at);
+CREATE INDEX IF NOT EXISTS export_snapshots_profile_idx ON export_snapshots (profile, id);
+CREATE INDEX IF NOT EXISTS sensor_fetches_sensor_idx ON sensor_fetches (sensor, profile, fetched_at);
+CREATE INDEX IF NOT EXISTS ip_indicators_address_idx ON ip_indicators USING GIST (address inet_ops);
+`
+	_, err := db.Exec(query)
+	return err
+}
+
+// nullIfEmpty превращает пустую строку в NULL при записи в БД.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Snapshot - неизменяемая копия файлов одной выгрузки профиля в хранилище снимков.
+// Файлы снимка лежат в каталоге <хранилище>/<номер>, метаданные - в таблице export_snapshots.
+type Snapshot struct {
+	ID        int
+	Profile   string
+	Format    string
+	Output    string // файл выгрузки профиля, для Dionis-NX - имя до деления на части
+	CreatedAt time.Time
+	SHA256    string // хэш списка файлов снимка и их хэшей
+	Rules     int
+	Filter    string
+	Files     []SnapshotFile
+	Sources   []SnapshotSource
+}
+
+// SnapshotFile - файл выгрузки в снимке: путь публикации, хэш и размер.
+type SnapshotFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// SnapshotSource - последний загруженный архив источника, правила которого вошли в выгрузку.
+// Для локальных источников и источников без архива Archive пустой.
+type SnapshotSource struct {
+	Source   string     `json:"source"`
+	Archive  string     `json:"archive,omitempty"`
+	SHA256   string     `json:"sha256,omitempty"`
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+}
+
+// recordArchive сохраняет хэш загруженного архива источника для снимков выгрузок.
+func recordArchive(db *sql.DB, archive, source string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия архива: %v", err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения архива: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO source_archives (source, archive, sha256, size) VALUES ($1, $2, $3, $4)`,
+		source, filepath.Base(archive), hex.EncodeToString(hash.Sum(nil)), size)
+	if err != nil {
+		return fmt.Errorf("Ошибка записи архива источника %s: %v", source, err)
+	}
+	return nil
+}
+
+// createSnapshot копирует файлы выгрузки в хранилище dir под следующим номером
+// и записывает снимок. Файлы снимка доступны только для чтения.
+func createSnapshot(db *sql.DB, dir string, s *Snapshot, files []string, sources []string) error {
+	var err error
+	if s.Sources, err = snapshotSources(db, sources); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+        INSERT INTO export_snapshots (profile, format, output, sha256, rules, filter, published_at)
+        VALUES ($1, $2, $3, '', $4, $5, CURRENT_TIMESTAMP) RETURNING id, created_at`,
+		s.Profile, s.Format, s.Output, s.Rules, s.Filter).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("Ошибка записи снимка: %v", err)
+	}
+
+	target := snapshotDir(dir, s.ID)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("Ошибка создания каталога %s: %v", target, err)
+	}
+	s.Files = nil
+	for _, path := range files {
+		f, err := copySnapshotFile(path, filepath.Join(target, filepath.Base(path)))
+		if err != nil {
+			os.RemoveAll(target)
+			return err
+		}
+		s.Files = append(s.Files, f)
+	}
+	s.SHA256 = snapshotHash(s.Files)
+
+	filesJSON, _ := json.Marshal(s.Files)
+	sourcesJSON, _ := json.Marshal(s.Sources)
+	if _, err := tx.Exec(`UPDATE export_snapshots SET sha256 = $1, files = $2, sources = $3 WHERE id = $4`,
+		s.SHA256, string(filesJSON), string(sourcesJSON), s.ID); err != nil {
+		os.RemoveAll(target)
+		return fmt.Errorf("Ошибка записи снимка: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		os.RemoveAll(target)
+		return fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return nil
+}
+
+// copySnapshotFile копирует файл выгрузки в снимок и возвращает его хэш.
+func copySnapshotFile(path, target string) (SnapshotFile, error) {
+	f := SnapshotFile{Path: path}
+	in, err := os.Open(path)
+	if err != nil {
+		return f, fmt.Errorf("Ошибка открытия файла %s: %v", path, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return f, fmt.Errorf("Ошибка создания файла %s: %v", target, err)
+	}
+	hash := sha256.New()
+	f.Size, err = io.Copy(io.MultiWriter(out, hash), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return f, fmt.Errorf("Ошибка записи в файл %s: %v", target, err)
+	}
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return f, nil
+}
+
+// snapshotHash - хэш снимка по списку файлов в формате sha256sum.
+func snapshotHash(files []SnapshotFile) string {
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%s  %s\n", f.SHA256, filepath.Base(f.Path))
+	}
+	return sha256Hex([]byte(b.String()))
+}
+
+func snapshotDir(dir string, id int) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d", id))
+}
+
+// snapshotSources находит последние загруженные архивы источников.
+func snapshotSources(db *sql.DB, sources []string) ([]SnapshotSource, error) {
+	found := map[string]SnapshotSource{}
+	rows, err := db.Query(`
+        SELECT DISTINCT ON (source) source, archive, sha256, loaded_at
+        FROM source_archives WHERE source = ANY($1)
+        ORDER BY source, loaded_at DESC, id DESC`, pq.Array(sources))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s SnapshotSource
+		var loaded time.Time
+		if err := rows.Scan(&s.Source, &s.Archive, &s.SHA256, &loaded); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		s.LoadedAt = &loaded
+		found[s.Source] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+
+	result := []SnapshotSource{}
+	sort.Strings(sources)
+	for _, name := range sources {
+		if s, ok := found[name]; ok {
+			result = append(result, s)
+		} else {
+			result = append(result, SnapshotSource{Source: name})
+		}
+	}
+	return result, nil
+}
+
+// listSnapshots возвращает снимки профиля (все снимки, если profile пустой), новые первыми.
+func listSnapshots(db *sql.DB, profile string) ([]Snapshot, error) {
+	return querySnapshots(db, `WHERE $1 = '' OR profile = $1 ORDER BY id DESC`, profile)
+}
+
+// publishedSnapshot возвращает последний опубликованный (выгруженный или возвращённый
+// откатом) снимок профиля или nil, если снимков нет.
+func publishedSnapshot(db *sql.DB, profile string) (*Snapshot, error) {
+	list, err := querySnapshots(db, `WHERE profile = $1 ORDER BY published_at DESC NULLS LAST, id DESC LIMIT 1`, profile)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// loadSnapshot возвращает снимок по номеру.
+func loadSnapshot(db *sql.DB, id int) (*Snapshot, error) {
+	list, err := querySnapshots(db, `WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("Снимок %d не найден", id)
+	}
+	return &list[0], nil
+}
+
+func querySnapshots(db *sql.DB, where string, args ...interface{}) ([]Snapshot, error) {
+	rows, err := db.Query(`
+        SELECT id, profile, format, output, created_at, sha256, rules, COALESCE(filter, ''), files, sources
+        FROM export_snapshots `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	var list []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		var files, sources []byte
+		if err := rows.Scan(&s.ID, &s.Profile, &s.Format, &s.Output, &s.CreatedAt, &s.SHA256, &s.Rules, &s.Filter,
+			&files, &sources); err != nil {
+			return nil, fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		if err := json.Unmarshal(files, &s.Files); err != nil {
+			return nil, fmt.Errorf("Ошибка разбора файлов снимка %d: %v", s.ID, err)
+		}
+		if err := json.Unmarshal(sources, &s.Sources); err != nil {
+			return nil, fmt.Errorf("Ошибка разбора источников снимка %d: %v", s.ID, err)
+		}
+		list = append(list, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+	return list, nil
+}
+
+// readSnapshotFile читает файл снимка и проверяет его хэш.
+func readSnapshotFile(dir string, s *Snapshot, f SnapshotFile) ([]byte, error) {
+	path := filepath.Join(snapshotDir(dir, s.ID), filepath.Base(f.Path))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения файла %s: %v", path, err)
+	}
+	if sum := sha256Hex(data); sum != f.SHA256 {
+		return nil, fmt.Errorf("Файл снимка %s повреждён: SHA-256 %s, ожидается %s", path, sum, f.SHA256)
+	}
+	return data, nil
+}
+
+// snapshotRules возвращает правила снимка по sid. Сравнение правил возможно
+// для форматов правил: suricata, snort3 и dionis.
+func snapshotRules(dir string, s *Snapshot) (map[string]DeltaRule, error) {
+	rules := map[string]DeltaRule{}
+	for _, f := range s.Files {
+		data, err := readSnapshotFile(dir, s, f)
+		if err != nil {
+			return nil, err
+		}
+		var parsed map[string]DeltaRule
+		switch s.Format {
+		case DialectSuricata, DialectSnort3:
+			parsed, err = ruleLines(string(data), parseRule)
+		case DialectDionis:
+			parsed, err = ruleLines(string(data), parseDionisRecord)
+		default:
+			return nil, fmt.Errorf("Сравнение правил не поддерживается для формата %s", s.Format)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Path, err)
+		}
+		for sid, r := range parsed {
+			rules[sid] = r
+		}
+	}
+	return rules, nil
+}
+
+// SnapshotDiff - различия правил двух снимков по sid.
+type SnapshotDiff struct {
+	Added, Removed, Changed []DeltaRule
+}
+
+// diffSnapshots сравнивает правила снимков a и b; изменёнными считаются правила
+// с одинаковым sid и разным текстом.
+func diffSnapshots(a, b map[string]DeltaRule) SnapshotDiff {
+	var d SnapshotDiff
+	for sid, rb := range b {
+		ra, ok := a[sid]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, rb)
+		case ra.Rule != rb.Rule:
+			d.Changed = append(d.Changed, rb)
+		}
+	}
+	for sid, ra := range a {
+		if _, ok := b[sid]; !ok {
+			d.Removed = append(d.Removed, ra)
+		}
+	}
+	for _, list := range [][]DeltaRule{d.Added, d.Removed, d.Changed} {
+		sort.Slice(list, func(i, j int) bool { return lessGIDSID(list[i], list[j]) })
+	}
+	return d
+}
+
+// rollbackSnapshot публикует файлы снимка по их путям выгрузки и отмечает снимок
+// опубликованным, чтобы раздача отдавала его сенсорам. Лишние части
+// выгрузки Dionis-NX удаляются, метка выгрузки профиля сбрасывается: дельта
+// относительно отменённой выгрузки сенсорам не подходит.
+func rollbackSnapshot(db *sql.DB, dir string, s *Snapshot) error {
+	var published []string
+	for _, f := range s.Files {
+		data, err := readSnapshotFile(dir, s, f)
+		if err != nil {
+			return err
+		}
+		if d := filepath.Dir(f.Path); d != "." {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				return fmt.Errorf("Ошибка создания каталога %s: %v", d, err)
+			}
+		}
+		if err := writeFileAtomic(f.Path, data); err != nil {
+			return err
+		}
+		published = append(published, f.Path)
+	}
+	if s.Format == DialectDionis {
+		if err := removeStaleDionisParts(s.Output, published); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM export_watermarks WHERE profile = $1`, s.Profile); err != nil {
+		return fmt.Errorf("Ошибка сброса метки выгрузки %s: %v", s.Profile, err)
+	}
+	if _, err := db.Exec(`UPDATE export_snapshots SET published_at = CURRENT_TIMESTAMP WHERE id = $1`, s.ID); err != nil {
+		return fmt.Errorf("Ошибка записи публикации снимка %d: %v", s.ID, err)
+	}
+	return nil
+}
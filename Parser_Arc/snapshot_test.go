@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// storeSnapshot кладёт файлы снимка id в хранилище store. Пути публикации файлов -
+// в каталоге published; files - пары имя файла, содержимое.
+func storeSnapshot(t *testing.T, store, published string, id int, format, output string, files ...string) *Snapshot {
+	t.Helper()
+	s := &Snapshot{ID: id, Profile: "test", Format: format, Output: filepath.Join(published, output)}
+	if err := os.MkdirAll(snapshotDir(store, id), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(files); i += 2 {
+		name, data := files[i], files[i+1]
+		writeTestFile(t, filepath.Join(snapshotDir(store, id), name), data)
+		s.Files = append(s.Files, SnapshotFile{Path: filepath.Join(published, name), SHA256: sha256Hex([]byte(data)), Size: int64(len(data))})
+	}
+	return s
+}
+
+func TestSnapshotRules(t *testing.T) {
+	store := t.TempDir()
+	suricata := storeSnapshot(t, store, "/out", 1, DialectSuricata, "export_suricata.txt", "export_suricata.txt",
+		"# export format:suricata\n\nalert tcp any any -> any any (msg:\"a\"; sid:1;)\nalert tcp any any -> any any (msg:\"b\"; gid:3; sid:2;)\n")
+	rules, err := snapshotRules(store, suricata)
+	if err != nil {
+		t.Fatalf("snapshotRules: %v", err)
+	}
+	want := map[string]DeltaRule{
+		"1": {GID: "1", SID: "1", Rule: `alert tcp any any -> any any (msg:"a"; sid:1;)`},
+		"2": {GID: "3", SID: "2", Rule: `alert tcp any any -> any any (msg:"b"; gid:3; sid:2;)`},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("правила %+v, ожидалось %+v", rules, want)
+	}
+
+	// Правила всех частей Dionis-NX.
+	dionis := storeSnapshot(t, store, "/out", 2, DialectDionis, "export_dionis.txt",
+		"export_dionis_001.txt", "# dionis-nx schema:2; part:1/2; rules:1\naction:alert;proto:tcp;sid:10;\n",
+		"export_dionis_002.txt", "# dionis-nx schema:2; part:2/2; rules:1\naction:drop;proto:udp;sid:11;msg:x;\n")
+	if rules, err = snapshotRules(store, dionis); err != nil {
+		t.Fatalf("snapshotRules: %v", err)
+	}
+	if len(rules) != 2 || rules["11"].Rule != "action:drop;proto:udp;sid:11;msg:x;" {
+		t.Errorf("правила Dionis-NX %+v", rules)
+	}
+
+	for name, s := range map[string]*Snapshot{
+		"unsupported format": storeSnapshot(t, store, "/out", 3, "csv", "export.csv", "export.csv", "sid\n1\n"),
+		"bad rule":           storeSnapshot(t, store, "/out", 4, DialectSuricata, "export.txt", "export.txt", "not a rule\n"),
+	} {
+		if _, err := snapshotRules(store, s); err == nil {
+			t.Errorf("%s: ожидалась ошибка", name)
+		}
+	}
+
+	// Изменённый после записи файл снимка не читается.
+	writeTestFile(t, filepath.Join(snapshotDir(store, 1), "export_suricata.txt"), "# changed\n")
+	if _, err := snapshotRules(store, suricata); err == nil || !strings.Contains(err.Error(), "повреждён") {
+		t.Errorf("ошибка для повреждённого файла: %v", err)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	rule := func(gid, sid, text string) DeltaRule { return DeltaRule{GID: gid, SID: sid, Rule: text} }
+	a := map[string]DeltaRule{
+		"1":  rule("1", "1", "same"),
+		"2":  rule("1", "2", "old"),
+		"10": rule("1", "10", "removed"),
+		"9":  rule("1", "9", "removed"),
+		"5":  rule("3", "5", "old"),
+	}
+	b := map[string]DeltaRule{
+		"1":   rule("1", "1", "same"),
+		"2":   rule("1", "2", "new"),
+		"5":   rule("3", "5", "new"),
+		"100": rule("1", "100", "added"),
+		"20":  rule("1", "20", "added"),
+		"7":   rule("3", "7", "added"),
+	}
+	d := diffSnapshots(a, b)
+	sids := func(list []DeltaRule) []string {
+		var result []string
+		for _, r := range list {
+			result = append(result, r.GID+":"+r.SID+" "+r.Rule)
+		}
+		return result
+	}
+	// Порядок - как в выгрузке: gid, затем sid по числовому значению.
+	if got, want := sids(d.Added), []string{"1:20 added", "1:100 added", "3:7 added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("добавлены %v, ожидалось %v", got, want)
+	}
+	if got, want := sids(d.Removed), []string{"1:9 removed", "1:10 removed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("удалены %v, ожидалось %v", got, want)
+	}
+	// Изменённые правила - в версии снимка b.
+	if got, want := sids(d.Changed), []string{"1:2 new", "3:5 new"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("изменены %v, ожидалось %v", got, want)
+	}
+	if d := diffSnapshots(a, a); len(d.Added)+len(d.Removed)+len(d.Changed) != 0 {
+		t.Errorf("различия снимка с самим собой: %+v", d)
+	}
+}
+
+func TestRollbackSnapshotDionis(t *testing.T) {
+	tests := []struct {
+		name      string
+		published []string // файлы текущей выгрузки
+		snapshot  []string // файлы снимка: пары имя, содержимое
+		want      []string // файлы выгрузки после отката
+	}{
+		{"parts to single file",
+			[]string{"export_dionis_001.txt", "export_dionis_002.txt", "export_dionis_003.txt", "other.txt"},
+			[]string{"export_dionis.txt", "# 1/1\n"},
+			[]string{"export_dionis.txt", "other.txt"}},
+		{"fewer parts",
+			[]string{"export_dionis.txt", "export_dionis_001.txt", "export_dionis_002.txt", "export_dionis_003.txt"},
+			[]string{"export_dionis_001.txt", "# 1/2\n", "export_dionis_002.txt", "# 2/2\n"},
+			[]string{"export_dionis_001.txt", "export_dionis_002.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, published := t.TempDir(), t.TempDir()
+			for _, name := range tt.published {
+				writeTestFile(t, filepath.Join(published, name), "current\n")
+			}
+			s := storeSnapshot(t, store, published, 5, DialectDionis, "export_dionis.txt", tt.snapshot...)
+
+			var queries []string
+			db := openFakeDB(t, func(query string, args []driver.Value) ([][]driver.Value, error) {
+				queries = append(queries, fmt.Sprint(strings.Fields(query)[0], " ", args[0]))
+				return nil, nil
+			})
+			s.Profile = "dionis"
+			if err := rollbackSnapshot(db, store, s); err != nil {
+				t.Fatalf("rollbackSnapshot: %v", err)
+			}
+
+			entries, err := os.ReadDir(published)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("файлы после отката %v, ожидалось %v", got, tt.want)
+			}
+			for i := 0; i+1 < len(tt.snapshot); i += 2 {
+				data, _ := os.ReadFile(filepath.Join(published, tt.snapshot[i]))
+				if string(data) != tt.snapshot[i+1] {
+					t.Errorf("файл %s: %q, ожидалось %q", tt.snapshot[i], data, tt.snapshot[i+1])
+				}
+			}
+			if want := []string{"DELETE dionis", "UPDATE 5"}; !reflect.DeepEqual(queries, want) {
+				t.Errorf("запросы %v, ожидалось %v", queries, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Диалекты правил.
+const (
+	DialectSnort2   = "snort2"
+	DialectSnort3   = "snort3"
+	DialectSuricata = "suricata"
+	DialectDionis   = "dionis" // записи Dionis-NX, см. parseDionisRules
+)
+
+// Модификаторы content, которые в Snort 3 записываются внутри опции через запятую:
+// content:"abc",nocase,depth 4;
+var snort3ContentModifiers = keywordSet(`nocase depth offset distance within fast_pattern
+fast_pattern_offset fast_pattern_length`)
+
+// Модификаторы content Snort 2, указывающие HTTP-буфер. В Snort 3 это sticky-буферы,
+// которые ставятся перед content.
+var snort2HTTPBuffers = keywordSet(`http_uri http_raw_uri http_header http_raw_header
+http_client_body http_cookie http_raw_cookie http_method http_stat_code http_stat_msg`)
+
+// Sticky-буферы Snort 3, которые ставятся перед content и pcre. В Snort 2 те же имена
+// HTTP-буферов - модификаторы, которые идут после content.
+var snort3StickyBuffers = keywordSet(`http_uri http_raw_uri http_header http_raw_header
+http_client_body http_raw_body http_cookie http_raw_cookie http_method http_stat_code http_stat_msg
+http_raw_request http_raw_status http_version http_true_ip http_param http_trailer http_raw_trailer
+js_data vba_data`)
+
+// Флаги pcre Snort 2, выбирающие HTTP-буфер.
+var pcreBufferFlags = map[rune]string{
+	'U': "http_uri", 'I': "http_raw_uri", 'H': "http_header", 'D': "http_raw_header",
+	'P': "http_client_body", 'C': "http_cookie", 'K': "http_raw_cookie",
+	'M': "http_method", 'S': "http_stat_code", 'Y': "http_stat_msg",
+}
+
+// Опции Snort 2 без аналога в правилах Snort 3.
+var snort3Unsupported = map[string]string{
+	"threshold":         "в Snort 3 задаётся через event_filter в конфигурации",
+	"activates":         "динамические правила удалены в Snort 3",
+	"activated_by":      "динамические правила удалены в Snort 3",
+	"count":             "динамические правила удалены в Snort 3",
+	"logto":             "удалено в Snort 3",
+	"session":           "удалено в Snort 3",
+	"resp":              "используйте действие reject",
+	"react":             "используйте действие react",
+	"rawbytes":          "используйте буфер raw_data",
+	"urilen":            "используйте http_uri; bufferlen",
+	"http_encode":       "удалено в Snort 3",
+	"stream_reassemble": "удалено в Snort 3",
+}
+
+// applyDialect задаёт диалект правила. Для источников Snort диалект определяется
+// по самому правилу: Snort 3 узнаётся по заголовку сервиса, опции service и
+// модификаторам внутри content. Правила Snort 3 приводятся к общей модели:
+// модификаторы content становятся отдельными опциями.
+func applyDialect(rule *Rule, dialect string) {
+	if dialect == DialectSnort2 || dialect == DialectSnort3 {
+		dialect = DialectSnort2
+		if looksLikeSnort3(rule) {
+			dialect = DialectSnort3
+		}
+	}
+	rule.Dialect = dialect
+	if dialect == DialectSnort3 {
+		rule.Options = expandSnort3Options(rule.Options)
+	}
+}
+
+func looksLikeSnort3(rule *Rule) bool {
+	if rule.Direction == "" {
+		return true
+	}
+	if _, ok := rule.Option("service"); ok {
+		return true
+	}
+	for _, content := range rule.Values("content") {
+		if len(splitTopLevel(content)) > 1 {
+			return true
+		}
+	}
+
+	// Буфер, который не относится к предшествующему content (с его модификаторами)
+	// и за которым следует content или pcre, - sticky-буфер Snort 3: http_uri; content:"...".
+	modifying, sticky := false, false
+	for _, opt := range rule.Options {
+		switch {
+		case opt.Name == "content" || opt.Name == "uricontent" || opt.Name == "pcre":
+			if sticky {
+				return true
+			}
+			modifying = opt.Name != "pcre"
+		case snort3StickyBuffers[opt.Name] && (opt.Value != "" || !modifying || !snort2HTTPBuffers[opt.Name]):
+			sticky = true
+		case modifying && (snort2HTTPBuffers[opt.Name] || contentModifiers[opt.Name] || snort3ContentModifiers[opt.Name]):
+		default:
+			modifying = false
+		}
+	}
+	return false
+}
+
+// expandSnort3Options раскладывает content:"abc",nocase,depth 4 на
+// content:"abc"; nocase; depth:4.
+func expandSnort3Options(options []RuleOption) []RuleOption {
+	var expanded []RuleOption
+	for _, opt := range options {
+		parts := splitTopLevel(opt.Value)
+		if opt.Name != "content" || len(parts) < 2 {
+			expanded = append(expanded, opt)
+			continue
+		}
+		expanded = append(expanded, RuleOption{Name: opt.Name, Value: parts[0]})
+		for _, mod := range parts[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(mod), " ")
+			expanded = append(expanded, RuleOption{Name: name, Value: strings.TrimSpace(value)})
+		}
+	}
+	return expanded
+}
+
+// collapseSnort3Options выполняет обратное преобразование для вывода в синтаксисе Snort 3.
+func collapseSnort3Options(options []RuleOption) []RuleOption {
+	var collapsed []RuleOption
+	content := -1
+	for _, opt := range options {
+		if content >= 0 && snort3ContentModifiers[opt.Name] {
+			mod := opt.Name
+			if opt.Value != "" {
+				mod += " " + opt.Value
+			}
+			collapsed[content].Value += "," + mod
+			continue
+		}
+		content = -1
+		if opt.Name == "content" {
+			content = len(collapsed)
+		}
+		collapsed = append(collapsed, opt)
+	}
+	return collapsed
+}
+
+// formatSnort3 собирает текст правила в синтаксисе Snort 3.
+func formatSnort3(rule *Rule) string {
+	out := *rule
+	out.Options = collapseSnort3Options(rule.Options)
+	return out.String()
+}
+
+// convertSnort2ToSnort3 переводит правило Snort 2 в синтаксис Snort 3: HTTP-модификаторы
+// content и флаги pcre становятся sticky-буферами, uricontent - http_uri; content,
+// metadata service - опцией service. Возвращает список причин, если перевести нельзя.
+func convertSnort2ToSnort3(rule *Rule) (*Rule, []string) {
+	var problems []string
+	if rule.Action == "sdrop" || rule.Action == "activate" || rule.Action == "dynamic" {
+		problems = append(problems, fmt.Sprintf("действие %s не поддерживается Snort 3", rule.Action))
+	}
+
+	var options, deferred []RuleOption
+	var services []string
+	buffer := "pkt_data"
+	pending := -1 // индекс последнего content в options, к которому относятся модификаторы
+	pendingBuffer := ""
+
+	// setBuffer вставляет sticky-буфер перед опцией с индексом at, если он отличается от текущего.
+	setBuffer := func(at int, name string) {
+		if name == buffer {
+			return
+		}
+		options = append(options[:at], append([]RuleOption{{Name: name}}, options[at:]...)...)
+		buffer = name
+	}
+	// Опции между content и его модификаторами откладываются, чтобы модификаторы
+	// остались сразу после content.
+	emit := func(opt RuleOption) {
+		if pending >= 0 {
+			deferred = append(deferred, opt)
+			return
+		}
+		options = append(options, opt)
+	}
+	closeContent := func() {
+		if pending < 0 {
+			return
+		}
+		setBuffer(pending, pendingBuffer)
+		options = append(options, deferred...)
+		pending, deferred = -1, nil
+	}
+
+	for _, opt := range rule.Options {
+		switch {
+		case opt.Name == "content" || opt.Name == "uricontent":
+			closeContent()
+			pending, pendingBuffer = len(options), "pkt_data"
+			if opt.Name == "uricontent" {
+				pendingBuffer = "http_uri"
+			}
+			options = append(options, RuleOption{Name: "content", Value: opt.Value})
+		case pending >= 0 && snort2HTTPBuffers[opt.Name] && opt.Value == "":
+			pendingBuffer = opt.Name
+		case pending >= 0 && opt.Name == "fast_pattern" && opt.Value != "":
+			problems = append(problems, "fast_pattern:"+opt.Value+" не поддерживается Snort 3")
+		case pending >= 0 && snort3ContentModifiers[opt.Name]:
+			options = append(options, opt)
+		case opt.Name == "pcre":
+			closeContent()
+			value, pcreBuffer, err := convertPcre(opt.Value)
+			if err != "" {
+				problems = append(problems, err)
+			}
+			setBuffer(len(options), pcreBuffer)
+			options = append(options, RuleOption{Name: "pcre", Value: value})
+		case opt.Name == "file_data" || opt.Name == "pkt_data":
+			closeContent()
+			options = append(options, opt)
+			buffer = opt.Name
+		case opt.Name == "metadata":
+			var pairs []string
+			for _, pair := range strings.Split(opt.Value, ",") {
+				pair = strings.TrimSpace(pair)
+				if key, val, _ := strings.Cut(pair, " "); key == "service" {
+					services = appendUnique(services, strings.TrimSpace(val))
+					continue
+				}
+				if pair != "" {
+					pairs = append(pairs, pair)
+				}
+			}
+			if len(pairs) > 0 {
+				emit(RuleOption{Name: "metadata", Value: strings.Join(pairs, ", ")})
+			}
+		default:
+			if reason, ok := snort3Unsupported[opt.Name]; ok {
+				problems = append(problems, fmt.Sprintf("%s: %s", opt.Name, reason))
+				continue
+			}
+			emit(opt)
+		}
+	}
+	closeContent()
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	if len(services) > 0 {
+		options = insertBefore(options, "sid", RuleOption{Name: "service", Value: strings.Join(services, ",")})
+	}
+
+	out := *rule
+	out.Options = options
+	out.Dialect = DialectSnort3
+	return &out, nil
+}
+
+// convertPcre убирает из pcre флаги HTTP-буферов Snort 2 и возвращает нужный sticky-буфер.
+func convertPcre(value string) (string, string, string) {
+	pattern := strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+	negated := strings.HasPrefix(pattern, "!")
+	pattern = strings.TrimPrefix(pattern, "!")
+	end := strings.LastIndex(pattern, "/")
+	if !strings.HasPrefix(pattern, "/") || end <= 0 {
+		return value, "pkt_data", ""
+	}
+
+	buffer := "pkt_data"
+	var flags strings.Builder
+	for _, f := range pattern[end+1:] {
+		switch {
+		case pcreBufferFlags[f] != "":
+			buffer = pcreBufferFlags[f]
+		case f == 'B':
+			return value, buffer, "pcre с флагом B (rawbytes) не поддерживается Snort 3"
+		default:
+			flags.WriteRune(f)
+		}
+	}
+
+	result := pattern[:end+1] + flags.String()
+	if negated {
+		result = "!" + result
+	}
+	return `"` + result + `"`, buffer, ""
+}
+
+// splitTopLevel разбивает значение опции по запятым вне кавычек.
+func splitTopLevel(value string) []string {
+	var parts []string
+	var current strings.Builder
+	inQuote, escaped := false, false
+	for _, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case r == ',' && !inQuote:
+			parts = append(parts, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	return append(parts, current.String())
+}
+
+func insertBefore(options []RuleOption, name string, opt RuleOption) []RuleOption {
+	for i, o := range options {
+		if o.Name == name {
+			return append(options[:i], append([]RuleOption{opt}, options[i:]...)...)
+		}
+	}
+	return append(options, opt)
+}
+
+// snort3Text возвращает сохранённую сигнатуру в синтаксисе Snort 3 или причины,
+// по которым перевести её нельзя.
+func snort3Text(sig Signature) (string, []string) {
+	if len(sig.Options) == 0 {
+		return "", []string{"опции правила не сохранены, требуется повторная загрузка источника"}
+	}
+	rule := sig.rule()
+	switch sig.Dialect {
+	case DialectSnort3:
+		return formatSnort3(rule), nil
+	case DialectSuricata:
+		return "", []string{"правило в синтаксисе Suricata"}
+	}
+	converted, problems := convertSnort2ToSnort3(rule)
+	if len(problems) > 0 {
+		return "", problems
+	}
+	return formatSnort3(converted), nil
+}
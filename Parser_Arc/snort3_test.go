@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLooksLikeSnort3(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want bool
+	}{
+		{"snort2 modifier after content",
+			`alert tcp any any -> any 80 (msg:"x"; content:"/a"; http_uri; content:"b"; nocase; http_header; sid:1;)`, false},
+		{"snort2 modifier after content modifiers",
+			`alert tcp any any -> any 80 (msg:"x"; content:"/a"; nocase; depth:4; http_uri; pcre:"/b/U"; sid:1;)`, false},
+		{"snort2 without buffers",
+			`alert tcp any any -> any any (msg:"x"; flow:established; content:"abc"; sid:1;)`, false},
+		{"snort3 sticky buffer before content",
+			`alert tcp any any -> any 80 (msg:"x"; flow:established; http_uri; content:"/a"; sid:1;)`, true},
+		{"snort3 sticky buffer after content",
+			`alert tcp any any -> any 80 (msg:"x"; content:"GET"; http_method; flow:established; http_uri; content:"/a"; sid:1;)`, true},
+		{"snort3 sticky buffer before pcre",
+			`alert tcp any any -> any 80 (msg:"x"; http_header; pcre:"/host/i"; sid:1;)`, true},
+		{"snort3 buffer with parameter",
+			`alert tcp any any -> any 80 (msg:"x"; content:"a"; http_header:field host; content:"b"; sid:1;)`, true},
+		{"snort3 buffer unknown to snort2",
+			`alert tcp any any -> any 80 (msg:"x"; content:"a"; http_raw_body; content:"b"; sid:1;)`, true},
+		{"snort3 content modifiers",
+			`alert tcp any any -> any 80 (msg:"x"; content:"a",nocase,depth 4; sid:1;)`, true},
+		{"snort3 service rule",
+			`alert http (msg:"x"; content:"a"; sid:1;)`, true},
+		{"snort3 service option",
+			`alert tcp any any -> any any (msg:"x"; service:http; content:"a"; sid:1;)`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := parseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := looksLikeSnort3(rule); got != tt.want {
+				t.Errorf("looksLikeSnort3 = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertSnort2ToSnort3(t *testing.T) {
+	rule, err := parseRule(`alert tcp $EXTERNAL_NET any -> $HOME_NET 80 (msg:"x"; flow:established,to_server; ` +
+		`content:"GET"; http_method; uricontent:"/a"; nocase; pcre:"/b/U"; metadata:service http, policy balanced-ips; sid:1; rev:1;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyDialect(rule, DialectSnort2)
+	if rule.Dialect != DialectSnort2 {
+		t.Fatalf("диалект %s, ожидался snort2", rule.Dialect)
+	}
+	out, problems := convertSnort2ToSnort3(rule)
+	if len(problems) > 0 {
+		t.Fatalf("правило не переведено: %v", problems)
+	}
+	got := formatSnort3(out)
+	for _, part := range []string{
+		`http_method; content:"GET";`,
+		`http_uri; content:"/a",nocase; pcre:"/b/";`,
+		`metadata:policy balanced-ips; service:http; sid:1;`,
+	} {
+		if !strings.Contains(got, part) {
+			t.Errorf("в правиле Snort 3 нет %q:\n%s", part, got)
+		}
+	}
+
+	// Переведённое правило снова узнаётся как Snort 3, в том числе по sticky-буферам.
+	parsed, err := parseRule(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyDialect(parsed, DialectSnort2)
+	if parsed.Dialect != DialectSnort3 {
+		t.Errorf("переведённое правило определено как %s", parsed.Dialect)
+	}
+}
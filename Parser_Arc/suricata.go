@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// suricataText возвращает сохранённую сигнатуру в синтаксисе Suricata со всеми
+// опциями в исходном порядке. Текст разбирается повторно и сравнивается с исходным
+// текстом правила из файла источника, чтобы в выгрузку не попало искажённое правило.
+// Сигнатуры, загруженные до сохранения исходного текста, сверяются с сохранёнными опциями.
+func suricataText(sig Signature) (string, error) {
+	if sig.Dialect == DialectSnort3 {
+		return "", fmt.Errorf("правило в синтаксисе Snort 3")
+	}
+	if len(sig.Options) == 0 {
+		return "", fmt.Errorf("опции правила не сохранены, требуется повторная загрузка источника")
+	}
+
+	rule := sig.rule()
+	text := rule.String()
+	parsed, err := parseRule(text)
+	if err != nil {
+		return "", fmt.Errorf("выгруженное правило не разбирается: %v", err)
+	}
+	source := rule
+	if sig.Raw != "" {
+		if source, err = parseRule(sig.Raw); err != nil {
+			return "", fmt.Errorf("исходный текст правила не разбирается: %v", err)
+		}
+	}
+	if !equalRules(source, parsed) {
+		return "", fmt.Errorf("выгруженное правило не совпадает с сохранённым: %s", text)
+	}
+	return text, nil
+}
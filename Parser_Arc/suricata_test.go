@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+// Правила для проверки выгрузки: экранирование в msg и content, участки |hex|,
+// отрицание, списки адресов и правило без значения у части опций.
+var suricataRoundTripRules = []string{
+	`alert http $HOME_NET any -> $EXTERNAL_NET any (msg:"ET TEST escaped \"quote\" and \; semicolon"; flow:established,to_server; http.uri; content:"/a\;b"; nocase; content:!"\"x\""; sid:1000001; rev:2;)`,
+	`alert tcp [10.0.0.0/8,!10.1.0.0/16] any -> any [80,443] (msg:"ET TEST hex"; content:"|0d 0a|Host|3a 20|"; depth:20; content:!"|00 00|"; distance:0; sid:1000002; rev:1;)`,
+	`drop udp any 53 <> any any (msg:"ET TEST backslash \\ path"; content:"C:\\Windows"; pcre:"/a\;b/i"; reference:url,example.com/a?b=1; sid:1000003; rev:1;)`,
+	`alert dns any any -> any any (msg:"ET TEST sticky"; dns.query; content:"evil"; endswith; metadata:created_at 2024_01_01, updated_at 2024_02_01; sid:1000004; rev:3;)`,
+}
+
+func TestSuricataTextRoundTrip(t *testing.T) {
+	for _, text := range suricataRoundTripRules {
+		source, err := parseRule(text)
+		if err != nil {
+			t.Fatalf("parseRule(%s): %v", text, err)
+		}
+		applyDialect(source, DialectSuricata)
+		sig, err := signatureFromRule(source, "test.rules", "test")
+		if err != nil {
+			t.Fatalf("signatureFromRule: %v", err)
+		}
+
+		out, err := suricataText(sig)
+		if err != nil {
+			t.Fatalf("suricataText(%s): %v", sig.SID, err)
+		}
+		parsed, err := parseRule(out)
+		if err != nil {
+			t.Fatalf("выгрузка не разбирается: %v\n%s", err, out)
+		}
+		original, _ := parseRule(text)
+		if len(parsed.Options) != len(original.Options) {
+			t.Fatalf("SID %s: %d опций вместо %d\n%s", sig.SID, len(parsed.Options), len(original.Options), out)
+		}
+		for i, opt := range original.Options {
+			// Значения сравниваются побайтно: кавычки и экранирование должны сохраниться.
+			if parsed.Options[i] != opt {
+				t.Errorf("SID %s: опция %d = %+v, ожидалось %+v", sig.SID, i, parsed.Options[i], opt)
+			}
+		}
+		if !equalRules(parsed, original) {
+			t.Errorf("SID %s: заголовок не совпадает: %s", sig.SID, out)
+		}
+	}
+}
+
+func TestSuricataTextDetectsChangedOptions(t *testing.T) {
+	rule, err := parseRule(suricataRoundTripRules[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(rule, "test.rules", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Сохранённые опции разошлись с исходным текстом: выгрузка должна быть отклонена.
+	sig.Options = append([]RuleOption{}, sig.Options...)
+	sig.Options[3] = RuleOption{Name: "content", Value: `"/ab"`}
+	if _, err := suricataText(sig); err == nil {
+		t.Error("ожидалась ошибка для правила, не совпадающего с исходным текстом")
+	}
+
+	sig.Dialect = DialectSnort3
+	if _, err := suricataText(sig); err == nil {
+		t.Error("ожидалась ошибка для правила Snort 3")
+	}
+}
+
+func TestSuricataTextWithSubstitutedVars(t *testing.T) {
+	rule, err := parseRule(`alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"x"; content:"a"; sid:1;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(rule, "test.rules", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	substituteVars(&sig, map[string]string{"home_net": "10.0.0.0/8", "external_net": "!$HOME_NET", "http_ports": "[80,8080]"})
+	out, err := suricataText(sig)
+	if err != nil {
+		t.Fatalf("suricataText: %v", err)
+	}
+	want := `alert tcp 10.0.0.0/8 any -> !10.0.0.0/8 [80,8080] (msg:"x"; content:"a"; sid:1;)`
+	if out != want {
+		t.Errorf("выгрузка %s, ожидалось %s", out, want)
+	}
+
+	// Правило, не совпадающее с исходным текстом, по-прежнему отклоняется.
+	sig.Options = append([]RuleOption{}, sig.Options...)
+	sig.Options[1] = RuleOption{Name: "content", Value: `"b"`}
+	if _, err := suricataText(sig); err == nil {
+		t.Error("ожидалась ошибка для правила, не совпадающего с исходным текстом")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Версия протокола командного сокета Suricata (unix-command).
+const suricataSocketVersion = "0.2"
+
+// SuricataReload - сенсор Suricata, которому после выгрузки профиля передаются правила:
+// файл выгрузки записывается в каталог правил сенсора (локальный или смонтированный),
+// затем через командный сокет выполняется reload-rules.
+type SuricataReload struct {
+	Name     string `mapstructure:"name"`
+	RulesDir string `mapstructure:"rules_dir"`
+	File     string `mapstructure:"file"`    // имя файла в rules_dir, по умолчанию имя файла выгрузки
+	Socket   string `mapstructure:"socket"`  // например /var/run/suricata/suricata-command.socket
+	Timeout  int    `mapstructure:"timeout"` // секунд на перезагрузку, по умолчанию 120
+}
+
+// RulesetStats - ответ ruleset-stats для одного движка обнаружения.
+type RulesetStats struct {
+	ID      int `json:"id"`
+	Loaded  int `json:"rules_loaded"`
+	Failed  int `json:"rules_failed"`
+	Skipped int `json:"rules_skipped"`
+}
+
+func (t SuricataReload) check() error {
+	if t.RulesDir == "" || t.Socket == "" {
+		return fmt.Errorf("у сенсора %s должны быть rules_dir и socket", t.Name)
+	}
+	return nil
+}
+
+func (t SuricataReload) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return 120 * time.Second
+	}
+	return time.Duration(t.Timeout) * time.Second
+}
+
+// reloadSuricata копирует выгрузку ruleset в каталог правил сенсора и перезагружает правила.
+// ruleset-stats суммирует правила всех файлов сенсора, поэтому успех проверяется по разнице
+// до и после перезагрузки: загружено должно стать не меньше, чем было, без правил прежнего
+// файла выгрузки и с rules правилами новой, а правил с ошибками не должно прибавиться.
+// При любой ошибке после записи возвращается прежний файл, чтобы отклонённые правила не
+// загрузились при следующем запуске Suricata; если новые правила уже загружены, они
+// перезагружаются ещё раз из прежнего файла.
+func reloadSuricata(t SuricataReload, ruleset string, rules int) (RulesetStats, error) {
+	var after RulesetStats
+	data, err := os.ReadFile(ruleset)
+	if err != nil {
+		return after, fmt.Errorf("Ошибка чтения файла %s: %v", ruleset, err)
+	}
+	file := t.File
+	if file == "" {
+		file = filepath.Base(ruleset)
+	}
+	target := filepath.Join(t.RulesDir, file)
+
+	socket, err := dialSuricata(t.Socket, t.timeout())
+	if err != nil {
+		return after, err
+	}
+	defer socket.Close()
+
+	before, err := socket.rulesetStats()
+	if err != nil {
+		return after, err
+	}
+	old, err := os.ReadFile(target)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return after, fmt.Errorf("Ошибка чтения файла %s: %v", target, err)
+	}
+	previous := countRules(old)
+	if err := writeFileAtomic(target, data); err != nil {
+		return after, err
+	}
+
+	// restore возвращает прежний файл правил и, если reload, перезагружает его.
+	restore := func(cause error, reload bool) error {
+		if existed {
+			err = writeFileAtomic(target, old)
+		} else {
+			err = os.Remove(target)
+		}
+		if err != nil {
+			return fmt.Errorf("%v; прежний файл правил не восстановлен: %v", cause, err)
+		}
+		if reload {
+			if _, err := socket.command("reload-rules"); err != nil {
+				return fmt.Errorf("%v; прежний файл правил восстановлен, но не перезагружен: %v", cause, err)
+			}
+		}
+		return fmt.Errorf("%v; восстановлен прежний файл правил", cause)
+	}
+
+	if _, err := socket.command("reload-rules"); err != nil {
+		return after, restore(fmt.Errorf("Ошибка reload-rules: %v", err), false)
+	}
+	if after, err = socket.rulesetStats(); err != nil {
+		return after, restore(err, true)
+	}
+	if after.Failed > before.Failed {
+		return after, restore(fmt.Errorf("правил с ошибками после перезагрузки: %d (до перезагрузки %d)", after.Failed, before.Failed), true)
+	}
+	if expected := before.Loaded - previous + rules; after.Loaded < expected {
+		return after, restore(fmt.Errorf("загружено правил %d, ожидалось не меньше %d (до перезагрузки %d, в прежнем файле %d, в выгрузке %d)",
+			after.Loaded, expected, before.Loaded, previous, rules), true)
+	}
+	return after, nil
+}
+
+// countRules считает правила - непустые строки без комментария.
+func countRules(data []byte) int {
+	count := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			count++
+		}
+	}
+	return count
+}
+
+// suricataSocket - соединение с командным сокетом Suricata: JSON-запрос, JSON-ответ
+// вида {"return": "OK"|"NOK", "message": ...}.
+type suricataSocket struct {
+	conn net.Conn
+	dec  *json.Decoder
+}
+
+// dialSuricata подключается к сокету и согласует версию протокола;
+// timeout ограничивает всё время работы с соединением.
+func dialSuricata(path string, timeout time.Duration) (*suricataSocket, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подключения к сокету %s: %v", path, err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	s := &suricataSocket{conn: conn, dec: json.NewDecoder(conn)}
+	if _, err := s.send(map[string]string{"version": suricataSocketVersion}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Ошибка согласования протокола с %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// rulesetStats выполняет ruleset-stats и суммирует ответы движков обнаружения.
+func (s *suricataSocket) rulesetStats() (RulesetStats, error) {
+	var total RulesetStats
+	message, err := s.command("ruleset-stats")
+	if err != nil {
+		return total, fmt.Errorf("Ошибка ruleset-stats: %v", err)
+	}
+	var stats []RulesetStats
+	if err := json.Unmarshal(message, &stats); err != nil {
+		return total, fmt.Errorf("Ошибка разбора ответа ruleset-stats: %v", err)
+	}
+	for _, st := range stats {
+		total.Loaded += st.Loaded
+		total.Failed += st.Failed
+		total.Skipped += st.Skipped
+	}
+	return total, nil
+}
+
+func (s *suricataSocket) command(name string) (json.RawMessage, error) {
+	return s.send(map[string]string{"command": name})
+}
+
+// send отправляет запрос и возвращает message ответа; ответ NOK возвращается ошибкой.
+func (s *suricataSocket) send(request map[string]string) (json.RawMessage, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.conn.Write(data); err != nil {
+		return nil, err
+	}
+	var reply struct {
+		Return  string          `json:"return"`
+		Message json.RawMessage `json:"message"`
+	}
+	if err := s.dec.Decode(&reply); err != nil {
+		return nil, err
+	}
+	if reply.Return != "OK" {
+		return nil, fmt.Errorf("%s", strings.Trim(string(reply.Message), `"`))
+	}
+	return reply.Message, nil
+}
+
+func (s *suricataSocket) Close() error {
+	return s.conn.Close()
+}
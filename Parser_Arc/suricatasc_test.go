@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSuricata - командный сокет, отвечающий на команды заданными ответами.
+// stats - ответы ruleset-stats по порядку (до и после перезагрузки).
+type fakeSuricata struct {
+	reload  string // return ответа reload-rules: OK или NOK
+	stats   [][]RulesetStats
+	reloads int // число полученных reload-rules
+}
+
+func startFakeSuricata(t *testing.T, f *fakeSuricata) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "suricata-command.socket")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+		for {
+			var req map[string]string
+			if err := dec.Decode(&req); err != nil {
+				return
+			}
+			reply := map[string]interface{}{"return": "OK", "message": "OK"}
+			switch req["command"] {
+			case "":
+				// согласование версии протокола
+			case "reload-rules":
+				f.reloads++
+				if f.reload == "NOK" {
+					reply = map[string]interface{}{"return": "NOK", "message": "reload failed"}
+				}
+			case "ruleset-stats":
+				reply["message"] = f.stats[0]
+				f.stats = f.stats[1:]
+			default:
+				reply = map[string]interface{}{"return": "NOK", "message": "Unknown command"}
+			}
+			if err := enc.Encode(reply); err != nil {
+				return
+			}
+		}
+	}()
+	return path
+}
+
+func writeRuleset(t *testing.T, dir string, rules int) string {
+	t.Helper()
+	lines := []string{"# export"}
+	for i := 0; i < rules; i++ {
+		lines = append(lines, `alert ip any any -> any any (msg:"x"; sid:1;)`)
+	}
+	path := filepath.Join(dir, "export_suricata.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReloadSuricata(t *testing.T) {
+	stats := func(loaded, failed int) []RulesetStats {
+		// Два движка обнаружения: ответы суммируются.
+		return []RulesetStats{{ID: 1, Loaded: loaded - 1, Failed: failed}, {ID: 2, Loaded: 1}}
+	}
+	tests := []struct {
+		name     string
+		previous int // правил в прежнем файле выгрузки на сенсоре
+		reload   string
+		stats    [][]RulesetStats
+		wantErr  string
+		reloads  int // ожидаемое число reload-rules: 2, если прежний файл перезагружен
+	}{
+		{name: "ok", stats: [][]RulesetStats{stats(100, 0), stats(110, 0)}, reloads: 1},
+		{name: "ok replaces previous export", previous: 4, stats: [][]RulesetStats{stats(104, 0), stats(110, 0)}, reloads: 1},
+		{name: "nok", reload: "NOK", stats: [][]RulesetStats{stats(100, 0)}, wantErr: "reload failed", reloads: 1},
+		{name: "nok restores previous export", previous: 4, reload: "NOK", stats: [][]RulesetStats{stats(104, 0)},
+			wantErr: "восстановлен прежний файл", reloads: 1},
+		{name: "failed rules", previous: 4, stats: [][]RulesetStats{stats(104, 1), stats(108, 3)}, wantErr: "правил с ошибками", reloads: 2},
+		// Другие файлы сенсора дают больше правил, чем в выгрузке, но выгрузка загружена не полностью.
+		{name: "short count", stats: [][]RulesetStats{stats(1000, 0), stats(1005, 0)}, wantErr: "ожидалось не меньше 1010", reloads: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rulesDir := t.TempDir()
+			target := filepath.Join(rulesDir, "export_suricata.txt")
+			var old []byte
+			if tt.previous > 0 {
+				writeRuleset(t, rulesDir, tt.previous)
+				old, _ = os.ReadFile(target)
+			}
+			ruleset := writeRuleset(t, t.TempDir(), 10)
+			fake := &fakeSuricata{reload: tt.reload, stats: tt.stats}
+			sensor := SuricataReload{Name: "sensor", RulesDir: rulesDir, Socket: startFakeSuricata(t, fake), Timeout: 5}
+
+			_, err := reloadSuricata(sensor, ruleset, 10)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("reloadSuricata: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+			}
+
+			data, readErr := os.ReadFile(target)
+			switch {
+			case tt.wantErr == "":
+				if n := countRules(data); n != 10 {
+					t.Errorf("в каталоге правил сенсора %d правил, ожидалось 10", n)
+				}
+			case tt.previous == 0:
+				// Прежнего файла не было: отклонённая выгрузка удаляется.
+				if !os.IsNotExist(readErr) {
+					t.Errorf("отклонённая выгрузка осталась в каталоге правил: %v", readErr)
+				}
+			case string(data) != string(old):
+				t.Errorf("прежний файл правил не восстановлен:\n%s", data)
+			}
+			if fake.reloads != tt.reloads {
+				t.Errorf("reload-rules выполнено %d раз, ожидалось %d", fake.reloads, tt.reloads)
+			}
+		})
+	}
+}
+
+func TestReloadSuricataNoSocket(t *testing.T) {
+	ruleset := writeRuleset(t, t.TempDir(), 1)
+	target := SuricataReload{Name: "sensor", RulesDir: t.TempDir(), Socket: filepath.Join(t.TempDir(), "missing.socket"), Timeout: 1}
+	if _, err := reloadSuricata(target, ruleset, 1); err == nil {
+		t.Fatal("ожидалась ошибка подключения к сокету")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// TemplateFormat - пользовательский формат выгрузки, описанный шаблоном text/template.
+// Файл шаблона определяет блок "rule", который выполняется для каждой сигнатуры
+// (данные - CatalogEntry), и необязательные блоки "header" и "footer" (данные - TemplateExport).
+type TemplateFormat struct {
+	Name     string `mapstructure:"name"`
+	Template string `mapstructure:"template"` // путь к файлу шаблона
+	Output   string `mapstructure:"output"`   // файл выгрузки по умолчанию
+}
+
+// TemplateExport - параметры выгрузки для блоков header и footer.
+type TemplateExport struct {
+	Format  string
+	Profile string
+	Created string
+	Filter  string
+	Comment string
+	Header  []string // строки комментария, как в заголовке выгрузок правил
+	Rules   int      // число выгруженных сигнатур, заполняется для footer
+}
+
+// Правило, на котором шаблон проверяется при запуске.
+const templateSampleRule = `alert http $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"ET EXAMPLE Template check"; ` +
+	`flow:established,to_server; http.uri; content:"/check"; nocase; reference:cve,2021-44228; reference:url,example.com; ` +
+	`classtype:trojan-activity; metadata:signature_severity Major, created_at 2024_01_01, updated_at 2024_01_02; sid:1; rev:1;)`
+
+// templateFuncs - функции шаблонов: экранирование, раскрытие content, объединение списков
+// и подстановка переменных профиля.
+func templateFuncs(vars map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"vars":    varReplacer(vars),
+		"join":    func(list []string, sep string) string { return strings.Join(list, sep) },
+		"quote":   quote,
+		"unquote": unquote,
+		"decodeContent": func(value string) (string, error) {
+			pattern, _, err := decodeContent(value)
+			return string(pattern), err
+		},
+		"modsec": modsecEscape,
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"csv": func(value string) (string, error) {
+			var b bytes.Buffer
+			w := csv.NewWriter(&b)
+			w.Write([]string{value})
+			w.Flush()
+			return strings.TrimSuffix(b.String(), "\n"), w.Error()
+		},
+		"xml": func(value string) (string, error) {
+			var b bytes.Buffer
+			err := xml.EscapeText(&b, []byte(value))
+			return b.String(), err
+		},
+		"regex":   regexp.QuoteMeta,
+		"lower":   strings.ToLower,
+		"upper":   strings.ToUpper,
+		"trim":    strings.TrimSpace,
+		"replace": func(value, old, new string) string { return strings.ReplaceAll(value, old, new) },
+		"default": func(def, value string) string {
+			if value == "" {
+				return def
+			}
+			return value
+		},
+	}
+}
+
+// modsecEscape экранирует строку для аргумента в двойных кавычках правила ModSecurity.
+func modsecEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
+
+// parseTemplateFormat разбирает шаблон формата и проверяет его на примере правила,
+// чтобы ошибки в именах полей и функций обнаруживались до выгрузки.
+func parseTemplateFormat(f TemplateFormat, vars map[string]string) (*template.Template, error) {
+	if f.Template == "" {
+		return nil, fmt.Errorf("Для формата %s не задан файл шаблона", f.Name)
+	}
+	t, err := template.New(filepath.Base(f.Template)).Funcs(templateFuncs(vars)).
+		Option("missingkey=zero").ParseFiles(f.Template)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка разбора шаблона формата %s: %v", f.Name, err)
+	}
+	if t.Lookup("rule") == nil {
+		return nil, fmt.Errorf("В шаблоне формата %s нет блока {{define \"rule\"}}", f.Name)
+	}
+
+	rule, err := parseRule(templateSampleRule)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signatureFromRule(rule, "example.rules", "example")
+	if err != nil {
+		return nil, err
+	}
+	info := TemplateExport{Format: f.Name, Created: "2024-01-01T00:00:00Z", Header: []string{"# export format:" + f.Name}}
+	w, err := newTemplateWriter(io.Discard, t, info)
+	if err == nil {
+		err = w.write(catalogEntry(sig))
+	}
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Шаблон формата %s не прошёл проверку: %v", f.Name, err)
+	}
+	return t, nil
+}
+
+// templateWriter записывает выгрузку пользовательского формата: header, rule для
+// каждой сигнатуры и footer.
+type templateWriter struct {
+	t    *template.Template
+	w    io.Writer
+	info TemplateExport
+}
+
+func newTemplateWriter(w io.Writer, t *template.Template, info TemplateExport) (*templateWriter, error) {
+	tw := &templateWriter{t: t, w: w, info: info}
+	if err := tw.execute("header", info); err != nil {
+		return nil, err
+	}
+	return tw, nil
+}
+
+func (w *templateWriter) write(entry CatalogEntry) error {
+	if err := w.t.ExecuteTemplate(w.w, "rule", entry); err != nil {
+		return fmt.Errorf("Ошибка шаблона (SID: %s): %v", entry.SID, err)
+	}
+	w.info.Rules++
+	return nil
+}
+
+func (w *templateWriter) flush() error {
+	return w.execute("footer", w.info)
+}
+
+// execute выполняет необязательный блок шаблона, если он определён.
+func (w *templateWriter) execute(name string, info TemplateExport) error {
+	if w.t.Lookup(name) == nil {
+		return nil
+	}
+	if err := w.t.ExecuteTemplate(w.w, name, info); err != nil {
+		return fmt.Errorf("Ошибка шаблона в блоке %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// templateEntry - запись каталога для правила в синтаксисе Suricata.
+func templateEntry(t *testing.T, text string) CatalogEntry {
+	t.Helper()
+	rule, err := parseRule(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(rule, "test.rules", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalogEntry(sig)
+}
+
+// writeTemplate записывает шаблон во временный файл и возвращает формат.
+func writeTemplate(t *testing.T, content string) TemplateFormat {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.tmpl")
+	writeTestFile(t, path, content)
+	return TemplateFormat{Name: "test", Template: path}
+}
+
+func TestParseTemplateFormatErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{"syntax", `{{define "rule"}}{{.SID}{{end}}`, "Ошибка разбора шаблона"},
+		{"no rule block", `{{define "header"}}x{{end}}`, `нет блока {{define "rule"}}`},
+		{"unknown function", `{{define "rule"}}{{nosuch .SID}}{{end}}`, "Ошибка разбора шаблона"},
+		{"unknown field", `{{define "rule"}}{{.NoSuchField}}{{end}}`, "не прошёл проверку"},
+		{"unknown header field", `{{define "header"}}{{.NoSuchField}}{{end}}{{define "rule"}}{{.SID}}{{end}}`, "не прошёл проверку"},
+		{"bad content", `{{define "rule"}}{{decodeContent "|zz|"}}{{end}}`, "не прошёл проверку"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTemplateFormat(writeTemplate(t, tt.template), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := parseTemplateFormat(TemplateFormat{Name: "test"}, nil); err == nil {
+		t.Error("ожидалась ошибка для формата без шаблона")
+	}
+}
+
+func TestTemplateWriter(t *testing.T) {
+	f := writeTemplate(t, `{{define "header"}}{{range .Header}}{{.}}
+{{end}}# {{.Format}} {{.Profile}}
+{{end}}{{define "rule"}}{{.SID}} {{json .Msg}} {{join .Policies ","}}
+{{end}}{{define "footer"}}# rules: {{.Rules}}
+{{end}}`)
+	tmpl, err := parseTemplateFormat(f, nil)
+	if err != nil {
+		t.Fatalf("parseTemplateFormat: %v", err)
+	}
+
+	var b bytes.Buffer
+	info := TemplateExport{Format: "test", Profile: "waf", Header: []string{"# export format:test", "# WAF"}}
+	w, err := newTemplateWriter(&b, tmpl, info)
+	if err != nil {
+		t.Fatalf("newTemplateWriter: %v", err)
+	}
+	for _, text := range []string{
+		`alert tcp any any -> any any (msg:"a \"quoted\" msg"; content:"a"; metadata:policy balanced-ips drop; sid:1;)`,
+		`alert tcp any any -> any any (msg:"b"; content:"b"; sid:2;)`,
+	} {
+		if err := w.write(templateEntry(t, text)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	want := "# export format:test\n# WAF\n# test waf\n" +
+		`1 "a \"quoted\" msg" balanced-ips` + "\n" +
+		`2 "b" ` + "\n" +
+		"# rules: 2\n"
+	if b.String() != want {
+		t.Errorf("выгрузка:\n%s\nожидалось:\n%s", b.String(), want)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	funcs := templateFuncs(map[string]string{"HOME_NET": "10.0.0.0/8"})
+	tests := []struct {
+		name string
+		call func() (string, error)
+		want string
+	}{
+		{"csv", func() (string, error) { return funcs["csv"].(func(string) (string, error))(`a,"b"`) }, `"a,""b"""`},
+		{"xml", func() (string, error) { return funcs["xml"].(func(string) (string, error))(`<a & "b">`) }, `&lt;a &amp; &#34;b&#34;&gt;`},
+		{"json", func() (string, error) { return funcs["json"].(func(interface{}) (string, error))(`a"b`) }, `"a\"b"`},
+		{"decodeContent", func() (string, error) {
+			return funcs["decodeContent"].(func(string) (string, error))(`"GET|20|/a\"b"`)
+		}, `GET /a"b`},
+		{"modsec", func() (string, error) { return modsecEscape(`C:\dir "x"`), nil }, `C:\\dir \"x\"`},
+		{"vars", func() (string, error) { return funcs["vars"].(func(string) string)("[$HOME_NET,!$home_net]"), nil }, "[10.0.0.0/8,!10.0.0.0/8]"},
+	}
+	for _, tt := range tests {
+		got, err := tt.call()
+		if err != nil || got != tt.want {
+			t.Errorf("%s = %q, %v, ожидалось %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := funcs["decodeContent"].(func(string) (string, error))(`"a|0d"`); err == nil {
+		t.Error("ожидалась ошибка для незакрытого |hex|")
+	}
+}
+
+func TestModSecurityTemplate(t *testing.T) {
+	tmpl, err := parseTemplateFormat(TemplateFormat{Name: "modsecurity", Template: "templates/modsecurity.tmpl"}, nil)
+	if err != nil {
+		t.Fatalf("parseTemplateFormat: %v", err)
+	}
+	tests := []struct {
+		name string
+		rule string
+		want string
+	}{
+		{"uri content after other buffer",
+			`alert http any any -> any any (msg:"it's \"bad\""; http.host; content:"example.com"; http.uri; content:"/a|20|b\"c"; classtype:web-application-attack; sid:10;)`,
+			`SecRule REQUEST_URI "@contains /a b\"c" "id:10,phase:1,log,deny,msg:'it\'s \"bad\"',tag:'web-application-attack',tag:'severity/unknown'"` + "\n"},
+		{"snort 2 modifier",
+			`alert tcp any any -> any any (msg:"x"; content:"body"; content:"!neg"; content:"/admin"; http_uri; sid:11;)`,
+			`SecRule REQUEST_URI "@contains /admin" "id:11,phase:1,log,deny,msg:'x',tag:'',tag:'severity/unknown'"` + "\n"},
+		{"negated uri content skipped",
+			`alert http any any -> any any (msg:"x"; http.uri; content:!"/ok"; content:"/bad"; sid:12;)`,
+			`SecRule REQUEST_URI "@contains /bad" "id:12,phase:1,log,deny,msg:'x',tag:'',tag:'severity/unknown'"` + "\n"},
+		{"no uri content",
+			`alert http any any -> any any (msg:"x"; http.header; content:"evil"; sid:13;)`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&b, "rule", templateEntry(t, tt.rule)); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("правило:\n%s\nожидалось:\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ChangeNote - кто и почему изменил правило; записывается в историю сигнатуры.
+type ChangeNote struct {
+	Actor   string
+	Comment string
+}
+
+// enableRules включает правила sids. Возвращает отключённые правила-установщики
+// flowbits, без которых включённые правила не сработают; при withDeps они
+// включаются вместе с запрошенными в одной транзакции.
+func enableRules(db *sql.DB, sids []string, withDeps bool, note ChangeNote) ([]string, error) {
+	if err := checkSIDs(db, sids); err != nil {
+		return nil, err
+	}
+	graph, err := loadFlowbitGraph(db)
+	if err != nil {
+		return nil, err
+	}
+	// Запрошенные правила уже считаются включёнными: установщики среди них не нужны.
+	for _, sid := range sids {
+		graph.enabled[sid] = true
+	}
+	required := map[string]bool{}
+	for _, sid := range sids {
+		for _, setter := range graph.requiredSetters(sid) {
+			required[setter] = true
+		}
+	}
+	deps := sortedKeys(required)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := setEnabledOverride(tx, sids, true, note); err != nil {
+		return nil, err
+	}
+	if withDeps && len(deps) > 0 {
+		if err := setEnabledOverride(tx, deps, true, note); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return deps, nil
+}
+
+// disableRules отключает правила sids и возвращает проверки isset других
+// включённых правил, которые после этого никогда не сработают.
+func disableRules(db *sql.DB, sids []string, note ChangeNote) ([]FlowbitIssue, error) {
+	if err := checkSIDs(db, sids); err != nil {
+		return nil, err
+	}
+	graph, err := loadFlowbitGraph(db)
+	if err != nil {
+		return nil, err
+	}
+	broken := graph.brokenBy(sids)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка начала транзакции: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := setEnabledOverride(tx, sids, false, note); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Ошибка фиксации транзакции: %v", err)
+	}
+	return broken, nil
+}
+
+// setEnabledOverride меняет состояние правил и записывает изменение в историю.
+func setEnabledOverride(tx *sql.Tx, sids []string, enabled bool, note ChangeNote) error {
+	event := "disabled"
+	if enabled {
+		event = "enabled"
+	}
+	_, err := tx.Exec(`
+        WITH changed AS (
+            UPDATE signatures
+            SET enabled_override = $1, updated_at = CURRENT_TIMESTAMP
+            WHERE sid = ANY($2) AND deleted_at IS NULL
+              AND COALESCE(enabled_override, enabled, TRUE) != $1
+            RETURNING sid
+        )
+        INSERT INTO signature_history (sid, event, actor, comment)
+        SELECT sid, $3, $4, $5 FROM changed
+    `, enabled, pq.Array(sids), event, nullIfEmpty(note.Actor), nullIfEmpty(note.Comment))
+	if err != nil {
+		return fmt.Errorf("Ошибка изменения состояния правил: %v", err)
+	}
+	return nil
+}
+
+// checkSIDs проверяет, что все sid есть в базе.
+func checkSIDs(db *sql.DB, sids []string) error {
+	rows, err := db.Query(`SELECT sid FROM signatures WHERE sid = ANY($1) AND deleted_at IS NULL`, pq.Array(sids))
+	if err != nil {
+		return fmt.Errorf("Ошибка выполнения запроса: %v", err)
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	for rows.Next() {
+		var sid string
+		if err := rows.Scan(&sid); err != nil {
+			return fmt.Errorf("Ошибка сканирования данных: %v", err)
+		}
+		found[sid] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("Ошибка при чтении строк: %v", err)
+	}
+
+	var missing []string
+	for _, sid := range sids {
+		if !found[sid] {
+			missing = append(missing, sid)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Сигнатуры не найдены: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import "strings"
+
+// substituteVars заменяет переменные ($HOME_NET, $HTTP_PORTS...) в адресах и портах
+// заголовка сигнатуры значениями из vars. Значения могут ссылаться на другие переменные
+// (EXTERNAL_NET: "!$HOME_NET"). Имена сравниваются без учёта регистра: viper приводит
+// ключи конфигурации к нижнему регистру. Переменные без значения остаются как есть.
+func substituteVars(sig *Signature, vars map[string]string) {
+	if len(vars) == 0 {
+		return
+	}
+	replace := varReplacer(vars)
+	sig.SrcIP = replace(sig.SrcIP)
+	sig.SrcPort = replace(sig.SrcPort)
+	sig.DstIP = replace(sig.DstIP)
+	sig.DstPort = replace(sig.DstPort)
+
+	// Исходный текст, с которым сверяется выгрузка (см. suricataText), получает те же значения.
+	if raw, err := parseRule(sig.Raw); err == nil && raw.Direction != "" {
+		raw.SrcIP, raw.SrcPort = replace(raw.SrcIP), replace(raw.SrcPort)
+		raw.DstIP, raw.DstPort = replace(raw.DstIP), replace(raw.DstPort)
+		sig.Raw = raw.String()
+	}
+}
+
+// varReplacer возвращает функцию, заменяющую переменные в строке значениями из vars.
+func varReplacer(vars map[string]string) func(string) string {
+	lookup := map[string]string{}
+	for name, value := range vars {
+		lookup[strings.ToLower(strings.TrimPrefix(name, "$"))] = value
+	}
+	return func(field string) string {
+		// Число проходов ограничено на случай переменных, ссылающихся друг на друга.
+		for i := 0; i <= len(lookup); i++ {
+			next := headerVarRe.ReplaceAllStringFunc(field, func(v string) string {
+				if value, ok := lookup[strings.ToLower(v[1:])]; ok {
+					return value
+				}
+				return v
+			})
+			if next == field {
+				break
+			}
+			field = next
+		}
+		return field
+	}
+}
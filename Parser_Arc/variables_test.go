@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestVarReplacer(t *testing.T) {
+	replace := varReplacer(map[string]string{
+		"home_net":      "[10.0.0.0/8,192.168.0.0/16]",
+		"$EXTERNAL_NET": "!$HOME_NET",
+		"HTTP_PORTS":    "[80,8080]",
+		"dmz":           "$Home_Net",
+	})
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"$HOME_NET", "[10.0.0.0/8,192.168.0.0/16]"},
+		{"$home_net", "[10.0.0.0/8,192.168.0.0/16]"},
+		{"$http_ports", "[80,8080]"},
+		// Значение ссылается на другую переменную.
+		{"$EXTERNAL_NET", "![10.0.0.0/8,192.168.0.0/16]"},
+		{"$DMZ", "[10.0.0.0/8,192.168.0.0/16]"},
+		{"[$HTTP_PORTS,443]", "[[80,8080],443]"},
+		{"![$HOME_NET,$SMTP_SERVERS]", "![[10.0.0.0/8,192.168.0.0/16],$SMTP_SERVERS]"},
+		// Переменные без значения и поля без переменных не меняются.
+		{"$SQL_SERVERS", "$SQL_SERVERS"},
+		{"any", "any"},
+		{"$HOME_NETWORK", "$HOME_NETWORK"},
+	}
+	for _, tt := range tests {
+		if got := replace(tt.field); got != tt.want {
+			t.Errorf("replace(%q) = %q, ожидалось %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestVarReplacerCycle(t *testing.T) {
+	replace := varReplacer(map[string]string{"A": "$B", "B": "[$A,10.0.0.1]", "SELF": "!$SELF"})
+	// Замена завершается: проходов не больше, чем переменных, плюс один.
+	if got := replace("$A"); got != "[[$A,10.0.0.1],10.0.0.1]" {
+		t.Errorf("replace($A) = %q", got)
+	}
+	if got := replace("$SELF"); got != "!!!!$SELF" {
+		t.Errorf("replace($SELF) = %q", got)
+	}
+}
+
+func TestSubstituteVars(t *testing.T) {
+	rule, err := parseRule(`alert tcp $HOME_NET any -> $EXTERNAL_NET $HTTP_PORTS (msg:"x"; content:"$HOME_NET"; sid:1;)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signatureFromRule(rule, "test.rules", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	substituteVars(&sig, map[string]string{"home_net": "10.0.0.0/8", "external_net": "!$HOME_NET"})
+
+	if sig.SrcIP != "10.0.0.0/8" || sig.DstIP != "!10.0.0.0/8" || sig.DstPort != "$HTTP_PORTS" {
+		t.Errorf("заголовок %s %s -> %s %s", sig.SrcIP, sig.SrcPort, sig.DstIP, sig.DstPort)
+	}
+	// В исходном тексте заменяется только заголовок, опции не меняются.
+	want := `alert tcp 10.0.0.0/8 any -> !10.0.0.0/8 $HTTP_PORTS (msg:"x"; content:"$HOME_NET"; sid:1;)`
+	if sig.Raw != want {
+		t.Errorf("текст правила\n%s\nожидалось\n%s", sig.Raw, want)
+	}
+}